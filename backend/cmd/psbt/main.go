@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"hellomix-backend/internal/config"
+	"hellomix-backend/internal/database"
+	"hellomix-backend/internal/services"
+	"hellomix-backend/pkg/crypto"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm/logger"
+)
+
+const usage = `usage: psbt <command> [arguments]
+
+commands:
+  list [status]          list PSBTs, optionally only unsigned, broadcast or cancelled ones
+  get <id> [file]        write the unsigned PSBT to file, or print it in base64
+  submit <id> <file|->   finalize and broadcast a signed PSBT, binary or base64
+  cancel <id>            abandon an unsigned PSBT`
+
+// psbt exports transactions waiting for an offline signature and imports
+// them back once signed, like the admin API does
+func main() {
+	timeout := flag.Duration("timeout", 2*time.Minute, "maximum time to spend on the command")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		logrus.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	db, err := database.New(&cfg.Database)
+	if err != nil {
+		logrus.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+	// Keep SQL logging off stdout, where PSBTs are printed
+	db.DB.Logger = logger.Default.LogMode(logger.Warn)
+
+	network, err := crypto.ParseNetwork(cfg.Wallet.NetworkName())
+	if err != nil {
+		logrus.Fatalf("Invalid WALLET_NETWORK: %v", err)
+	}
+	netParams := network.Params()
+	chainBackend, err := crypto.NewChainBackend(crypto.ChainBackendOptions{
+		Kind:             cfg.Chain.Backend,
+		Network:          network,
+		EsploraURL:       cfg.Chain.EsploraURL,
+		BitcoindURL:      cfg.Chain.BitcoindURL,
+		BitcoindUser:     cfg.Chain.BitcoindUser,
+		BitcoindPassword: cfg.Chain.BitcoindPassword,
+	})
+	if err != nil {
+		logrus.Fatalf("Failed to initialize chain backend: %v", err)
+	}
+	psbtService := services.NewPSBTService(db.DB, chainBackend)
+
+	addressType, err := crypto.ParseAddressType(cfg.Wallet.AddressType)
+	if err != nil {
+		logrus.Fatalf("Invalid WALLET_ADDRESS_TYPE: %v", err)
+	}
+	keychain, err := crypto.NewHDKeychain(cfg.Wallet.ExtendedKey(), addressType, netParams)
+	if err != nil {
+		logrus.Fatalf("Failed to load HD keychain: %v", err)
+	}
+	walletService := services.NewWalletService(db.DB, cfg.Wallet.MasterKey, crypto.NewWalletManager(keychain))
+
+	priceSources, err := services.NewPriceSources(strings.Split(cfg.Price.Sources, ","), cfg.API.CoinGeckoAPIKey)
+	if err != nil {
+		logrus.Fatalf("Invalid PRICE_SOURCES: %v", err)
+	}
+	priceService, err := services.NewPriceService(db.DB, nil, services.PriceOptions{
+		Sources:         priceSources,
+		MaxDeviationBps: int64(cfg.Price.MaxDeviationBps),
+		MinSources:      cfg.Price.MinSources,
+		RefreshInterval: time.Duration(cfg.Price.RefreshInterval) * time.Second,
+		MaxAge:          time.Duration(cfg.Price.MaxAge) * time.Second,
+	})
+	if err != nil {
+		logrus.Fatalf("Invalid price configuration: %v", err)
+	}
+
+	payoutRails := services.NewPayoutRailRegistry()
+	feeService, err := services.NewFeeService(chainBackend, priceService, payoutRails, netParams, services.FeeOptions{
+		AddressType:  addressType,
+		TargetBlocks: cfg.Fee.TargetBlocks,
+		FallbackRate: int64(cfg.Fee.FallbackRate),
+	})
+	if err != nil {
+		logrus.Fatalf("Invalid fee configuration: %v", err)
+	}
+
+	// Submitted payouts mark their transaction paid out, and submitted sweeps
+	// deactivate the wallets they emptied
+	if _, err := services.NewPayoutService(db.DB, walletService, psbtService, payoutRails, feeService, chainBackend, netParams, services.PayoutOptions{
+		FeeRate:          int64(cfg.Payout.FeeRate),
+		MinConfirmations: cfg.Payout.MinConfirmations,
+	}); err != nil {
+		logrus.Fatalf("Invalid payout configuration: %v", err)
+	}
+	if cfg.Sweep.Destination != "" {
+		if _, err := services.NewSweepService(db.DB, walletService, psbtService, chainBackend, netParams, services.SweepOptions{
+			Destination: cfg.Sweep.Destination,
+			AddressType: addressType,
+			FeeRate:     int64(cfg.Sweep.FeeRate),
+		}); err != nil {
+			logrus.Fatalf("Invalid sweep configuration: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch args[0] {
+	case "list":
+		status := ""
+		if len(args) > 1 {
+			status = args[1]
+		}
+		records, err := psbtService.List(ctx, status)
+		if err != nil {
+			logrus.Fatalf("Failed to list PSBTs: %v", err)
+		}
+		for _, record := range records {
+			fmt.Printf("%s  %-6s  %-9s  %12d sats  %d inputs  %s\n",
+				record.ID, record.Kind, record.Status, record.AmountSats, len(record.Outpoints), record.CreatedAt.Format(time.RFC3339))
+		}
+
+	case "get":
+		record, err := psbtService.Get(ctx, parseID(args))
+		if err != nil {
+			logrus.Fatalf("Failed to get PSBT: %v", err)
+		}
+		if len(args) < 3 {
+			fmt.Println(record.UnsignedPSBT)
+			return
+		}
+		raw, err := base64.StdEncoding.DecodeString(record.UnsignedPSBT)
+		if err != nil {
+			logrus.Fatalf("Failed to decode PSBT: %v", err)
+		}
+		if err := os.WriteFile(args[2], raw, 0600); err != nil {
+			logrus.Fatalf("Failed to write PSBT: %v", err)
+		}
+		logrus.Infof("Wrote PSBT %s to %s", record.ID, args[2])
+
+	case "submit":
+		if len(args) < 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		var signed []byte
+		if args[2] == "-" {
+			signed, err = io.ReadAll(os.Stdin)
+		} else {
+			signed, err = os.ReadFile(args[2])
+		}
+		if err != nil {
+			logrus.Fatalf("Failed to read signed PSBT: %v", err)
+		}
+		record, err := psbtService.Submit(ctx, parseID(args), signed)
+		if err != nil {
+			logrus.Fatalf("Failed to submit PSBT: %v", err)
+		}
+		output, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			logrus.Fatalf("Failed to encode result: %v", err)
+		}
+		fmt.Println(string(output))
+
+	case "cancel":
+		if err := psbtService.Cancel(ctx, parseID(args)); err != nil {
+			logrus.Fatalf("Failed to cancel PSBT: %v", err)
+		}
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// parseID parses the PSBT id argument of a command
+func parseID(args []string) uuid.UUID {
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	id, err := uuid.Parse(args[1])
+	if err != nil {
+		logrus.Fatalf("Invalid PSBT ID: %v", err)
+	}
+	return id
+}
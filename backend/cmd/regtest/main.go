@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"hellomix-backend/internal/config"
+	"hellomix-backend/internal/database"
+	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/crypto"
+	"hellomix-backend/pkg/money"
+
+	"github.com/sirupsen/logrus"
+)
+
+// regtest drives a BTC exchange end to end against a running server on a
+// local regtest bitcoind, as in CI: it pays the deposit from a miner wallet,
+// mines blocks until the exchange completes and checks that the payout
+// arrived. It reads the same configuration as the server, which has to use
+// the bitcoind backend on regtest, see docker-compose.regtest.yml.
+func main() {
+	apiURL := flag.String("api", "http://localhost:8080/api/v1", "base URL of the server API")
+	amount := flag.String("amount", "0.01", "BTC amount to exchange")
+	minerWallet := flag.String("miner-wallet", "miner", "bitcoind wallet that mines blocks and pays the deposit")
+	interval := flag.Duration("interval", 2*time.Second, "time between blocks")
+	timeout := flag.Duration("timeout", 5*time.Minute, "maximum time for the exchange to complete")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		logrus.Fatalf("Failed to load configuration: %v", err)
+	}
+	network, err := crypto.ParseNetwork(cfg.Wallet.NetworkName())
+	if err != nil {
+		logrus.Fatalf("Invalid WALLET_NETWORK: %v", err)
+	}
+	if network != crypto.NetworkRegtest || cfg.Chain.Backend != crypto.ChainBackendBitcoind {
+		logrus.Fatalf("Exchanges can only be driven on regtest with the bitcoind backend, not %s with %s", network, cfg.Chain.Backend)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	// The server watches deposits in the wallet of its RPC URL, the miner
+	// has a wallet of its own
+	nodeURL, serverWallet, _ := strings.Cut(cfg.Chain.BitcoindURL, "/wallet/")
+	node := crypto.NewBitcoindClient(nodeURL, cfg.Chain.BitcoindUser, cfg.Chain.BitcoindPassword)
+	if err := node.CheckNetwork(ctx, network); err != nil {
+		logrus.Fatal(err)
+	}
+	if serverWallet != "" {
+		if err := node.LoadWallet(ctx, serverWallet, true); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if err := node.LoadWallet(ctx, *minerWallet, false); err != nil {
+		logrus.Fatal(err)
+	}
+	miner := crypto.NewBitcoindClient(nodeURL+"/wallet/"+*minerWallet, cfg.Chain.BitcoindUser, cfg.Chain.BitcoindPassword)
+
+	parsed, err := money.ParseDecimal(*amount, 8)
+	if err != nil {
+		logrus.Fatalf("Invalid amount: %v", err)
+	}
+	amountSats, ok := parsed.Int64()
+	if !ok || amountSats <= 0 {
+		logrus.Fatalf("Invalid amount: %s", *amount)
+	}
+	minerAddress, err := miner.GetNewAddress(ctx)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := fund(ctx, miner, minerAddress, amountSats); err != nil {
+		logrus.Fatal(err)
+	}
+
+	payoutAddress, err := miner.GetNewAddress(ctx)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	var exchange struct {
+		TransactionID  string `json:"transaction_id"`
+		PaymentAddress string `json:"payment_address"`
+		BTCAmount      string `json:"btc_amount"`
+	}
+	if err := post(ctx, *apiURL+"/exchange/initiate", map[string]interface{}{
+		"btc_amount":       *amount,
+		"output_currency":  "BTC",
+		"output_addresses": []models.OutputAddress{{Address: payoutAddress, Percentage: 100}},
+	}, &exchange); err != nil {
+		logrus.Fatalf("Failed to initiate exchange: %v", err)
+	}
+	logrus.Infof("Exchange %s: pay %s BTC to %s", exchange.TransactionID, exchange.BTCAmount, exchange.PaymentAddress)
+
+	depositTxID, err := miner.SendToAddress(ctx, exchange.PaymentAddress, amountSats)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("Deposit paid in %s", depositTxID)
+
+	// Mine until the deposit is confirmed, the payout sent and confirmed
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	status := ""
+	for status != models.StatusCompleted {
+		select {
+		case <-ctx.Done():
+			logrus.Fatalf("Exchange %s did not complete, last status %q", exchange.TransactionID, status)
+		case <-ticker.C:
+		}
+
+		if _, err := miner.GenerateToAddress(ctx, 1, minerAddress); err != nil {
+			logrus.Fatal(err)
+		}
+
+		var transaction struct {
+			Status string `json:"status"`
+		}
+		if err := get(ctx, *apiURL+"/exchange/status/"+exchange.TransactionID, &transaction); err != nil {
+			logrus.Fatalf("Failed to get exchange status: %v", err)
+		}
+		if transaction.Status != status {
+			logrus.Infof("Exchange %s is %s", exchange.TransactionID, transaction.Status)
+			status = transaction.Status
+		}
+		switch status {
+		case models.StatusFailed, models.StatusExpired, models.StatusUnderpaid:
+			logrus.Fatalf("Exchange %s ended %s", exchange.TransactionID, status)
+		}
+	}
+
+	received, err := miner.GetReceivedByAddress(ctx, payoutAddress, 1)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if received == 0 {
+		logrus.Fatalf("Exchange %s completed without a confirmed payout to %s", exchange.TransactionID, payoutAddress)
+	}
+
+	// The payout spent the deposit, whose payments the server still has to
+	// verify until they are final without taking it for a reorg
+	db, err := database.New(&cfg.Database)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := awaitFinality(ctx, db, miner, minerAddress, exchange.TransactionID, *interval); err != nil {
+		logrus.Fatalf("Exchange %s: %v", exchange.TransactionID, err)
+	}
+	deposit, err := miner.GetTransaction(ctx, depositTxID)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := checkPayments(ctx, db, exchange.TransactionID, deposit); err != nil {
+		logrus.Fatalf("Exchange %s: %v", exchange.TransactionID, err)
+	}
+
+	fmt.Printf("Exchange %s completed: paid %s BTC, received %s BTC at %s\n",
+		exchange.TransactionID, exchange.BTCAmount, money.NewAmount(received).Decimal(8), payoutAddress)
+}
+
+// awaitFinality mines blocks until the server has finalized a transaction
+func awaitFinality(ctx context.Context, db *database.Database, miner *crypto.BitcoindClient, address, transactionID string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var transaction models.Transaction
+		if err := db.DB.WithContext(ctx).Where("id = ?", transactionID).First(&transaction).Error; err != nil {
+			return fmt.Errorf("failed to get transaction: %w", err)
+		}
+		if transaction.FinalizedAt != nil {
+			logrus.Infof("Exchange %s finalized at %s", transactionID, transaction.FinalizedAt.Format(time.RFC3339))
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("payments were not finalized, status %q", transaction.Status)
+		case <-ticker.C:
+		}
+		if _, err := miner.GenerateToAddress(ctx, 1, address); err != nil {
+			return err
+		}
+	}
+}
+
+// checkPayments checks that the payments of a transaction are still recorded
+// in the deposit's block. A payment that was dropped or moved to another
+// block would have raised a reorg alert, although none took place.
+func checkPayments(ctx context.Context, db *database.Database, transactionID string, deposit *crypto.Transaction) error {
+	var payments []models.Payment
+	if err := db.DB.WithContext(ctx).Where("transaction_id = ?", transactionID).Find(&payments).Error; err != nil {
+		return fmt.Errorf("failed to get payments: %w", err)
+	}
+	if len(payments) == 0 {
+		return fmt.Errorf("no payments recorded")
+	}
+	for _, payment := range payments {
+		if payment.TXID != deposit.TXID || payment.Status != "confirmed" || payment.BlockHash != deposit.Status.BlockHash {
+			return fmt.Errorf("payment %s:%d is %s in block %q, expected the deposit confirmed in block %s",
+				payment.TXID, payment.Vout, payment.Status, payment.BlockHash, deposit.Status.BlockHash)
+		}
+	}
+	return nil
+}
+
+// fund mines blocks to address until the miner wallet can pay sats, coinbase
+// outputs maturing after 100 blocks
+func fund(ctx context.Context, miner *crypto.BitcoindClient, address string, sats int64) error {
+	for {
+		balance, err := miner.GetBalance(ctx)
+		if err != nil {
+			return err
+		}
+		if balance > sats {
+			return nil
+		}
+		if _, err := miner.GenerateToAddress(ctx, 101, address); err != nil {
+			return err
+		}
+	}
+}
+
+// post sends a JSON request to the API and decodes the data of its response
+func post(ctx context.Context, url string, body interface{}, data interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(req, data)
+}
+
+// get fetches an API resource and decodes its data
+func get(ctx context.Context, url string, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	return do(req, data)
+}
+
+// do sends an API request and decodes the data of a successful response
+func do(req *http.Request, data interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if err := json.Unmarshal(response.Data, data); err != nil {
+		return fmt.Errorf("failed to unmarshal response data: %w", err)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"hellomix-backend/internal/config"
 	"hellomix-backend/internal/database"
 	"hellomix-backend/internal/services"
+	"hellomix-backend/pkg/crypto"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
@@ -60,17 +62,175 @@ func main() {
 	}
 
 	// Initialize services
-	priceService := services.NewPriceService(db.DB, redisClient, cfg.API.CoinGeckoAPIKey)
+	priceSources, err := services.NewPriceSources(strings.Split(cfg.Price.Sources, ","), cfg.API.CoinGeckoAPIKey)
+	if err != nil {
+		logrus.Fatalf("Invalid PRICE_SOURCES: %v", err)
+	}
+	priceService, err := services.NewPriceService(db.DB, redisClient, services.PriceOptions{
+		Sources:         priceSources,
+		MaxDeviationBps: int64(cfg.Price.MaxDeviationBps),
+		MinSources:      cfg.Price.MinSources,
+		RefreshInterval: time.Duration(cfg.Price.RefreshInterval) * time.Second,
+		MaxAge:          time.Duration(cfg.Price.MaxAge) * time.Second,
+	})
+	if err != nil {
+		logrus.Fatalf("Invalid price configuration: %v", err)
+	}
 	
-	// Use testnet from configuration
-	testnet := cfg.Wallet.Testnet
-	transactionService := services.NewTransactionService(db.DB, priceService, testnet)
+	// Use the Bitcoin network from configuration
+	network, err := crypto.ParseNetwork(cfg.Wallet.NetworkName())
+	if err != nil {
+		logrus.Fatalf("Invalid WALLET_NETWORK: %v", err)
+	}
+	netParams := network.Params()
+	addressType, err := crypto.ParseAddressType(cfg.Wallet.AddressType)
+	if err != nil {
+		logrus.Fatalf("Invalid WALLET_ADDRESS_TYPE: %v", err)
+	}
+	keychain, err := crypto.NewHDKeychain(cfg.Wallet.ExtendedKey(), addressType, netParams)
+	if err != nil {
+		logrus.Fatalf("Failed to load HD keychain: %v", err)
+	}
+	if cfg.Wallet.MasterFingerprint != "" {
+		if err := keychain.SetMasterFingerprint(cfg.Wallet.MasterFingerprint); err != nil {
+			logrus.Fatalf("Invalid WALLET_MASTER_FINGERPRINT: %v", err)
+		}
+	}
+	if cfg.Wallet.AccountPath != "" {
+		if err := keychain.SetAccountPath(cfg.Wallet.AccountPath); err != nil {
+			logrus.Fatalf("Invalid WALLET_ACCOUNT_PATH: %v", err)
+		}
+	}
+	if keychain.IsWatchOnly() {
+		logrus.Warn("HD keychain is watch-only, deposit keys cannot be signed by this server")
+	}
+	walletService := services.NewWalletService(db.DB, cfg.Wallet.MasterKey, crypto.NewWalletManager(keychain))
+
+	// Initialize the blockchain backend used for payment detection
+	chainBackend, err := crypto.NewChainBackend(crypto.ChainBackendOptions{
+		Kind:             cfg.Chain.Backend,
+		Network:          network,
+		EsploraURL:       cfg.Chain.EsploraURL,
+		BitcoindURL:      cfg.Chain.BitcoindURL,
+		BitcoindUser:     cfg.Chain.BitcoindUser,
+		BitcoindPassword: cfg.Chain.BitcoindPassword,
+	})
+	if err != nil {
+		logrus.Fatalf("Failed to initialize chain backend: %v", err)
+	}
+	if bitcoind, ok := chainBackend.(*crypto.BitcoindClient); ok {
+		checkCtx, cancelCheck := context.WithTimeout(context.Background(), 30*time.Second)
+		err := bitcoind.CheckNetwork(checkCtx, network)
+		cancelCheck()
+		if err != nil {
+			logrus.Fatalf("Chain backend is on the wrong network: %v", err)
+		}
+	}
+	logrus.Infof("Using %s chain backend on %s", cfg.Chain.Backend, network)
+
+	confirmationPolicy, err := services.NewConfirmationPolicy(cfg.Payment.ConfirmationTiers)
+	if err != nil {
+		logrus.Fatalf("Invalid PAYMENT_CONFIRMATION_TIERS: %v", err)
+	}
+
+	// Signed PSBTs are submitted through the admin API, the sweep and payout
+	// services complete the sweeps and payouts among them
+	psbtService := services.NewPSBTService(db.DB, chainBackend)
+
+	// Non-BTC outputs are delivered through payout rails
+	payoutRails := services.NewPayoutRailRegistry()
+	for _, currency := range strings.Split(cfg.Payout.DryRunCurrencies, ",") {
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if currency == "" || currency == "BTC" {
+			continue
+		}
+		payoutRails.Register(services.NewDryRunRail(currency))
+		logrus.Warnf("Payouts in %s are simulated by a dry-run rail, nothing will be sent", currency)
+	}
+
+	// Fees are quoted with fee rates estimated by the chain backend
+	feeService, err := services.NewFeeService(chainBackend, priceService, payoutRails, netParams, services.FeeOptions{
+		AddressType:  addressType,
+		TargetBlocks: cfg.Fee.TargetBlocks,
+		FallbackRate: int64(cfg.Fee.FallbackRate),
+		CacheTTL:     time.Duration(cfg.Fee.CacheTTL) * time.Second,
+		SpreadBps:    int64(cfg.Fee.SpreadBps),
+	})
+	if err != nil {
+		logrus.Fatalf("Invalid fee configuration: %v", err)
+	}
+
+	payoutService, err := services.NewPayoutService(db.DB, walletService, psbtService, payoutRails, feeService, chainBackend, netParams, services.PayoutOptions{
+		FeeRate:          int64(cfg.Payout.FeeRate),
+		MinConfirmations: cfg.Payout.MinConfirmations,
+		OfflineSigning:   cfg.Payout.OfflineSigning,
+		HotSignLimit:     int64(cfg.Payout.HotSignLimit),
+	})
+	if err != nil {
+		logrus.Fatalf("Invalid payout configuration: %v", err)
+	}
+	if cfg.Sweep.Destination != "" {
+		if _, err := services.NewSweepService(db.DB, walletService, psbtService, chainBackend, netParams, services.SweepOptions{
+			Destination:      cfg.Sweep.Destination,
+			AddressType:      addressType,
+			FeeRate:          int64(cfg.Sweep.FeeRate),
+			MinConfirmations: cfg.Sweep.MinConfirmations,
+			MaxInputs:        cfg.Sweep.MaxInputs,
+			OfflineSigning:   cfg.Sweep.OfflineSigning,
+			HotSignLimit:     int64(cfg.Sweep.HotSignLimit),
+		}); err != nil {
+			logrus.Fatalf("Invalid sweep configuration: %v", err)
+		}
+	}
+
+	paymentProcessor := services.NewPaymentProcessor(db.DB, priceService, payoutService, chainBackend, netParams, confirmationPolicy, int64(cfg.Payment.ToleranceBps), services.NewLogAlertNotifier())
+	// Chain notifications, when configured, trigger payment checks right away
+	var chainNotifier crypto.ChainNotifier
+	if cfg.Chain.ZMQRawTxURL != "" || cfg.Chain.ZMQHashBlockURL != "" {
+		chainNotifier = crypto.NewZMQNotifier(cfg.Chain.ZMQRawTxURL, cfg.Chain.ZMQHashBlockURL)
+		logrus.Info("Using ZMQ push notifications for payment detection")
+	}
+
+	paymentWatcher := services.NewPaymentWatcher(db.DB, paymentProcessor, services.PaymentWatcherOptions{
+		PollInterval:     time.Duration(cfg.Payment.PollInterval) * time.Second,
+		FastPollInterval: time.Duration(cfg.Payment.FastPollInterval) * time.Second,
+		LeaseDuration:    time.Duration(cfg.Payment.LeaseDuration) * time.Second,
+		Concurrency:      cfg.Payment.PollConcurrency,
+		Notifier:         chainNotifier,
+	})
+	// Quotes lock the exchange rate for a while
+	quoteSigningKey := []byte(cfg.Quote.SigningKey)
+	if len(quoteSigningKey) == 0 {
+		quoteSigningKey = services.DeriveQuoteSigningKey(cfg.Wallet.MasterKey)
+	}
+	quoteService, err := services.NewQuoteService(db.DB, priceService, feeService, services.QuoteOptions{
+		TTL:            time.Duration(cfg.Quote.TTL) * time.Second,
+		MaxSlippageBps: int64(cfg.Quote.MaxSlippageBps),
+		SigningKey:     quoteSigningKey,
+	})
+	if err != nil {
+		logrus.Fatalf("Invalid quote configuration: %v", err)
+	}
+
+	// Output addresses are validated against the networks payouts are sent on
+	addressValidator := crypto.NewAddressValidator(crypto.NewNetworkProfile(network, int64(cfg.Payout.EVMChainID)))
+
+	transactionService := services.NewTransactionService(db.DB, priceService, walletService, feeService, quoteService, addressValidator, paymentProcessor, paymentWatcher)
+
+	priceHistoryService, err := services.NewPriceHistoryService(db.DB, services.PriceHistoryOptions{
+		TickRetention:         time.Duration(cfg.Price.TickRetentionDays) * 24 * time.Hour,
+		MinuteCandleRetention: time.Duration(cfg.Price.MinuteCandleRetentionDays) * 24 * time.Hour,
+	})
+	if err != nil {
+		logrus.Fatalf("Invalid price history configuration: %v", err)
+	}
 
 	// Initialize handlers
 	transactionHandler := handlers.NewTransactionHandler(transactionService)
-	priceHandler := handlers.NewPriceHandler(priceService)
-	addressHandler := handlers.NewAddressHandler()
-	healthHandler := handlers.NewHealthHandler()
+	priceHandler := handlers.NewPriceHandler(priceService, priceHistoryService)
+	addressHandler := handlers.NewAddressHandler(walletService, addressValidator)
+	healthHandler := handlers.NewHealthHandler(transactionService)
+	psbtHandler := handlers.NewPSBTHandler(psbtService)
 
 	// Setup routes
 	router := routes.SetupRoutes(
@@ -78,8 +238,10 @@ func main() {
 		priceHandler,
 		addressHandler,
 		healthHandler,
+		psbtHandler,
 		redisClient,
 		cfg.API.RateLimit,
+		cfg.API.AdminToken,
 	)
 
 	// Create HTTP server
@@ -91,6 +253,14 @@ func main() {
 		MaxHeaderBytes: 1 << 20, // 1 MB
 	}
 
+	// Keep prices warm in the background, requests only read them, and
+	// downsample their history into candles
+	priceService.Start(context.Background())
+	priceHistoryService.Start(context.Background())
+
+	// Resume monitoring of open transactions
+	paymentWatcher.Start(context.Background())
+
 	// Start server in a goroutine
 	go func() {
 		logrus.Infof("Server starting on port %s", cfg.Server.Port)
@@ -117,6 +287,17 @@ func main() {
 		logrus.Info("Server exited gracefully")
 	}
 
+	// Stop payment monitoring before the database goes away
+	if err := paymentWatcher.Stop(ctx); err != nil {
+		logrus.Errorf("Failed to stop payment watcher: %v", err)
+	}
+	if err := priceService.Stop(ctx); err != nil {
+		logrus.Errorf("Failed to stop price refresher: %v", err)
+	}
+	if err := priceHistoryService.Stop(ctx); err != nil {
+		logrus.Errorf("Failed to stop price history maintenance: %v", err)
+	}
+
 	// Close database connection
 	if err := db.Close(); err != nil {
 		logrus.Errorf("Failed to close database: %v", err)
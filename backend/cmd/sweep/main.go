@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"hellomix-backend/internal/config"
+	"hellomix-backend/internal/database"
+	"hellomix-backend/internal/services"
+	"hellomix-backend/pkg/crypto"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm/logger"
+)
+
+// sweep moves the deposits of finalized transactions to cold storage. Run it
+// periodically, e.g. from cron; with -dry-run it prints the unsigned PSBT
+// instead of signing and broadcasting. Sweeps that must be signed offline
+// are stored as PSBTs, see cmd/psbt.
+func main() {
+	dryRun := flag.Bool("dry-run", false, "print the unsigned sweep as a PSBT instead of broadcasting it")
+	offline := flag.Bool("offline", false, "export the sweep as a PSBT for offline signing")
+	timeout := flag.Duration("timeout", 5*time.Minute, "maximum time to spend on the sweep")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		logrus.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	db, err := database.New(&cfg.Database)
+	if err != nil {
+		logrus.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+	// Keep SQL logging off stdout, where the PSBT is printed
+	db.DB.Logger = logger.Default.LogMode(logger.Warn)
+
+	network, err := crypto.ParseNetwork(cfg.Wallet.NetworkName())
+	if err != nil {
+		logrus.Fatalf("Invalid WALLET_NETWORK: %v", err)
+	}
+	netParams := network.Params()
+	addressType, err := crypto.ParseAddressType(cfg.Wallet.AddressType)
+	if err != nil {
+		logrus.Fatalf("Invalid WALLET_ADDRESS_TYPE: %v", err)
+	}
+	keychain, err := crypto.NewHDKeychain(cfg.Wallet.ExtendedKey(), addressType, netParams)
+	if err != nil {
+		logrus.Fatalf("Failed to load HD keychain: %v", err)
+	}
+	if cfg.Wallet.MasterFingerprint != "" {
+		if err := keychain.SetMasterFingerprint(cfg.Wallet.MasterFingerprint); err != nil {
+			logrus.Fatalf("Invalid WALLET_MASTER_FINGERPRINT: %v", err)
+		}
+	}
+	if cfg.Wallet.AccountPath != "" {
+		if err := keychain.SetAccountPath(cfg.Wallet.AccountPath); err != nil {
+			logrus.Fatalf("Invalid WALLET_ACCOUNT_PATH: %v", err)
+		}
+	}
+	walletService := services.NewWalletService(db.DB, cfg.Wallet.MasterKey, crypto.NewWalletManager(keychain))
+
+	chainBackend, err := crypto.NewChainBackend(crypto.ChainBackendOptions{
+		Kind:             cfg.Chain.Backend,
+		Network:          network,
+		EsploraURL:       cfg.Chain.EsploraURL,
+		BitcoindURL:      cfg.Chain.BitcoindURL,
+		BitcoindUser:     cfg.Chain.BitcoindUser,
+		BitcoindPassword: cfg.Chain.BitcoindPassword,
+	})
+	if err != nil {
+		logrus.Fatalf("Failed to initialize chain backend: %v", err)
+	}
+
+	psbtService := services.NewPSBTService(db.DB, chainBackend)
+	sweepService, err := services.NewSweepService(db.DB, walletService, psbtService, chainBackend, netParams, services.SweepOptions{
+		Destination:      cfg.Sweep.Destination,
+		AddressType:      addressType,
+		FeeRate:          int64(cfg.Sweep.FeeRate),
+		MinConfirmations: cfg.Sweep.MinConfirmations,
+		MaxInputs:        cfg.Sweep.MaxInputs,
+		OfflineSigning:   cfg.Sweep.OfflineSigning || *offline,
+		HotSignLimit:     int64(cfg.Sweep.HotSignLimit),
+	})
+	if err != nil {
+		logrus.Fatalf("Invalid sweep configuration: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	result, err := sweepService.Sweep(ctx, *dryRun)
+	if errors.Is(err, services.ErrNothingToSweep) {
+		logrus.Info("Nothing to sweep")
+		return
+	}
+	if err != nil {
+		logrus.Fatalf("Sweep failed: %v", err)
+	}
+
+	if *dryRun {
+		logrus.Infof("Dry run: %d inputs from %d wallets, %d sats to %s after a %d sats fee",
+			result.InputCount, len(result.Wallets), result.AmountSats, result.Destination, result.FeeSats)
+		fmt.Println(result.PSBT)
+		return
+	}
+	if result.PSBTID != nil {
+		logrus.Infof("Sweep of %d sats exported as PSBT %s for offline signing", result.AmountSats, result.PSBTID)
+	}
+
+	output, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		logrus.Fatalf("Failed to encode result: %v", err)
+	}
+	fmt.Println(string(output))
+}
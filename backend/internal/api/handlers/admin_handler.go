@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"hellomix-backend/internal/models"
+	"hellomix-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// maxPSBTSize bounds the size of an uploaded signed PSBT
+const maxPSBTSize = 4 << 20
+
+// PSBTHandler handles the admin endpoints for offline signing
+type PSBTHandler struct {
+	psbtService *services.PSBTService
+}
+
+// NewPSBTHandler creates a new PSBT handler
+func NewPSBTHandler(psbtService *services.PSBTService) *PSBTHandler {
+	return &PSBTHandler{
+		psbtService: psbtService,
+	}
+}
+
+// ListPSBTs handles GET /api/v1/admin/psbts
+func (ph *PSBTHandler) ListPSBTs(c *gin.Context) {
+	records, err := ph.psbtService.List(c.Request.Context(), c.Query("status"))
+	if err != nil {
+		logrus.Errorf("Failed to list PSBTs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list PSBTs",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    records,
+	})
+}
+
+// GetPSBT handles GET /api/v1/admin/psbts/:id
+func (ph *PSBTHandler) GetPSBT(c *gin.Context) {
+	record, ok := ph.loadPSBT(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    record,
+	})
+}
+
+// DownloadPSBT handles GET /api/v1/admin/psbts/:id/download, returning the
+// unsigned PSBT as a binary .psbt file
+func (ph *PSBTHandler) DownloadPSBT(c *gin.Context) {
+	record, ok := ph.loadPSBT(c)
+	if !ok {
+		return
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(record.UnsignedPSBT)
+	if err != nil {
+		logrus.Errorf("Failed to decode PSBT %s: %v", record.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to decode PSBT",
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.psbt"`, record.Kind, record.ID))
+	c.Data(http.StatusOK, "application/octet-stream", raw)
+}
+
+// SubmitPSBTRequest carries a signed PSBT in base64
+type SubmitPSBTRequest struct {
+	PSBT string `json:"psbt" binding:"required"`
+}
+
+// SubmitPSBT handles POST /api/v1/admin/psbts/:id/signed. The signed PSBT is
+// sent either as JSON {"psbt": "<base64>"} or as the raw request body.
+func (ph *PSBTHandler) SubmitPSBT(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid PSBT ID",
+		})
+		return
+	}
+
+	var signed []byte
+	if strings.HasPrefix(c.ContentType(), "application/json") {
+		var req SubmitPSBTRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+		signed = []byte(req.PSBT)
+	} else {
+		signed, err = io.ReadAll(io.LimitReader(c.Request.Body, maxPSBTSize))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Failed to read request body",
+			})
+			return
+		}
+	}
+
+	record, err := ph.psbtService.Submit(c.Request.Context(), id, signed)
+	if err != nil {
+		logrus.Errorf("Failed to submit PSBT %s: %v", id, err)
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrPSBTNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"error":   "Failed to submit PSBT",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    record,
+	})
+}
+
+// CancelPSBT handles POST /api/v1/admin/psbts/:id/cancel
+func (ph *PSBTHandler) CancelPSBT(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid PSBT ID",
+		})
+		return
+	}
+
+	if err := ph.psbtService.Cancel(c.Request.Context(), id); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrPSBTNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"error":   "Failed to cancel PSBT",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// loadPSBT loads the PSBT named by the :id parameter, answering the request
+// itself when it cannot
+func (ph *PSBTHandler) loadPSBT(c *gin.Context) (*models.PSBT, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid PSBT ID",
+		})
+		return nil, false
+	}
+
+	record, err := ph.psbtService.Get(c.Request.Context(), id)
+	if errors.Is(err, services.ErrPSBTNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "PSBT not found",
+		})
+		return nil, false
+	}
+	if err != nil {
+		logrus.Errorf("Failed to get PSBT %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get PSBT",
+		})
+		return nil, false
+	}
+
+	return record, true
+}
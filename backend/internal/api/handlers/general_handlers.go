@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"hellomix-backend/internal/services"
 	"hellomix-backend/pkg/crypto"
+	"hellomix-backend/pkg/money"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -12,51 +18,140 @@ import (
 
 // PriceHandler handles price-related HTTP requests
 type PriceHandler struct {
-	priceService *services.PriceService
+	priceService        *services.PriceService
+	priceHistoryService *services.PriceHistoryService
 }
 
 // NewPriceHandler creates a new price handler
-func NewPriceHandler(priceService *services.PriceService) *PriceHandler {
+func NewPriceHandler(priceService *services.PriceService, priceHistoryService *services.PriceHistoryService) *PriceHandler {
 	return &PriceHandler{
-		priceService: priceService,
+		priceService:        priceService,
+		priceHistoryService: priceHistoryService,
 	}
 }
 
 // GetPrices handles GET /api/v1/prices
 func (ph *PriceHandler) GetPrices(c *gin.Context) {
-	prices, err := ph.priceService.GetPrices(c.Request.Context())
+	snapshot, err := ph.priceService.Snapshot(c.Request.Context())
 	if err != nil {
 		logrus.Errorf("Failed to get prices: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrStalePrices) {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{
 			"error": "Failed to fetch cryptocurrency prices",
 		})
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"data":        snapshot.Prices,
+		"sources":     snapshot.Sources,
+		"timestamp":   snapshot.FetchedAt,
+		"age_seconds": int64(snapshot.Age().Seconds()),
+	})
+}
+
+// GetPriceHistory handles GET /api/v1/prices/history. Times are RFC 3339 or
+// Unix seconds; to defaults to now and from to 100 intervals before it.
+func (ph *PriceHandler) GetPriceHistory(c *gin.Context) {
+	symbol := strings.ToUpper(c.Query("symbol"))
+	if symbol == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "symbol is required",
+		})
+		return
+	}
+	currency, err := money.LookupCurrency(symbol)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Unsupported symbol",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	interval := c.DefaultQuery("interval", services.CandleHour)
+	duration, err := services.CandleDuration(interval)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid interval",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		if to, err = parseTime(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid to",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	from := to.Add(-100 * duration)
+	if value := c.Query("from"); value != "" {
+		if from, err = parseTime(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid from",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	candles, err := ph.priceHistoryService.Candles(c.Request.Context(), symbol, interval, from, to)
+	if err != nil {
+		logrus.Warnf("Failed to get price history: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to get price history",
+			"details": err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data": prices,
-		"timestamp": "now",
+		"data": gin.H{
+			"symbol":   symbol,
+			"asset":    currency.Asset,
+			"interval": interval,
+			"from":     from.UTC(),
+			"to":       to.UTC(),
+			"candles":  candles,
+		},
 	})
 }
 
+// parseTime parses an RFC 3339 time or Unix seconds
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
 // AddressHandler handles address-related HTTP requests
 type AddressHandler struct {
-	bitcoinService *crypto.BitcoinService
-	validator      *crypto.AddressValidator
+	walletService *services.WalletService
+	validator     *crypto.AddressValidator
 }
 
 // NewAddressHandler creates a new address handler
-func NewAddressHandler() *AddressHandler {
+func NewAddressHandler(walletService *services.WalletService, validator *crypto.AddressValidator) *AddressHandler {
 	return &AddressHandler{
-		bitcoinService: crypto.NewBitcoinService(false), // mainnet
-		validator:      crypto.NewAddressValidator(),
+		walletService: walletService,
+		validator:     validator,
 	}
 }
 
 // GenerateBitcoinAddress handles POST /api/v1/addresses/generate
 func (ah *AddressHandler) GenerateBitcoinAddress(c *gin.Context) {
-	address, err := ah.bitcoinService.GenerateAddress()
+	wallet, err := ah.walletService.GenerateAddress(c.Request.Context(), nil)
 	if err != nil {
 		logrus.Errorf("Failed to generate address: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -68,7 +163,8 @@ func (ah *AddressHandler) GenerateBitcoinAddress(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
-			"address": address,
+			"address":      wallet.Address,
+			"address_type": wallet.AddressType,
 		},
 	})
 }
@@ -77,6 +173,7 @@ func (ah *AddressHandler) GenerateBitcoinAddress(c *gin.Context) {
 type ValidateAddressRequest struct {
 	Address  string `json:"address" binding:"required"`
 	Currency string `json:"currency" binding:"required"`
+	Memo     string `json:"memo"`
 }
 
 // ValidateAddress handles POST /api/v1/addresses/validate
@@ -84,30 +181,49 @@ func (ah *AddressHandler) ValidateAddress(c *gin.Context) {
 	var req ValidateAddressRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
+			"error":   "Invalid request body",
 			"details": err.Error(),
 		})
 		return
 	}
 
-	isValid := ah.validator.ValidateAddress(req.Address, req.Currency)
+	err := ah.validator.Validate(req.Address, req.Currency)
+	if err == nil {
+		err = ah.validator.ValidateMemo(req.Memo, req.Currency)
+	}
+
+	data := gin.H{
+		"valid":       err == nil,
+		"address":     req.Address,
+		"currency":    req.Currency,
+		"network":     ah.validator.Profile().Name(),
+		"memo_policy": crypto.MemoRuleFor(req.Currency).Policy,
+	}
+	var addressErr *crypto.AddressError
+	if errors.As(err, &addressErr) {
+		data["reason"] = addressErr.Reason
+		data["message"] = addressErr.Message
+	}
+	if addressType, ok := ah.validator.BitcoinAddressType(req.Address); ok && req.Currency == "BTC" {
+		data["address_type"] = addressType
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data": gin.H{
-			"valid": isValid,
-			"address": req.Address,
-			"currency": req.Currency,
-		},
+		"data":    data,
 	})
 }
 
 // HealthHandler handles health check requests
-type HealthHandler struct{}
+type HealthHandler struct {
+	transactionService *services.TransactionService
+}
 
 // NewHealthHandler creates a new health handler
-func NewHealthHandler() *HealthHandler {
-	return &HealthHandler{}
+func NewHealthHandler(transactionService *services.TransactionService) *HealthHandler {
+	return &HealthHandler{
+		transactionService: transactionService,
+	}
 }
 
 // Health handles GET /api/v1/health
@@ -119,20 +235,29 @@ func (hh *HealthHandler) Health(c *gin.Context) {
 	})
 }
 
-// GetSupportedCurrencies handles GET /api/v1/supported-currencies
+// GetSupportedCurrencies handles GET /api/v1/supported-currencies, listing
+// the output currencies exchanges can be created in: each asset on each
+// network it is paid out on
 func (hh *HealthHandler) GetSupportedCurrencies(c *gin.Context) {
-	currencies := []gin.H{
-		{"symbol": "BTC", "name": "Bitcoin", "min_amount": 0.001, "max_amount": 10, "fee": 0.002},
-		{"symbol": "ETH", "name": "Ethereum", "min_amount": 0.01, "max_amount": 100, "fee": 0.005},
-		{"symbol": "USDT", "name": "Tether", "min_amount": 10, "max_amount": 50000, "fee": 0.005},
-		{"symbol": "USDC", "name": "USD Coin", "min_amount": 10, "max_amount": 50000, "fee": 0.005},
-		{"symbol": "ADA", "name": "Cardano", "min_amount": 100, "max_amount": 500000, "fee": 0.005},
-		{"symbol": "SOL", "name": "Solana", "min_amount": 1, "max_amount": 10000, "fee": 0.005},
-		{"symbol": "MATIC", "name": "Polygon", "min_amount": 100, "max_amount": 1000000, "fee": 0.005},
+	currencies := []gin.H{}
+	for _, currency := range hh.transactionService.SupportedCurrencies() {
+		currencies = append(currencies, gin.H{
+			"symbol":         currency.Code,
+			"name":           currency.Name,
+			"asset":          currency.Asset,
+			"network":        currency.Network,
+			"standard":       currency.Standard,
+			"decimals":       currency.Decimals,
+			"address_format": currency.AddressFormat,
+			"memo":           crypto.MemoRuleFor(currency.Code).Policy,
+			"min_amount":     json.Number(currency.MinAmount.Decimal(currency.Decimals)),
+			"max_amount":     json.Number(currency.MaxAmount.Decimal(currency.Decimals)),
+			"fee":            float64(currency.FeeBps) / 10000,
+		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data": currencies,
+		"data":    currencies,
 	})
 }
@@ -3,7 +3,9 @@ package handlers
 import (
 	"net/http"
 
+	"hellomix-backend/internal/models"
 	"hellomix-backend/internal/services"
+	"hellomix-backend/pkg/money"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -22,6 +24,45 @@ func NewTransactionHandler(transactionService *services.TransactionService) *Tra
 	}
 }
 
+// CreateQuote handles POST /api/v1/exchange/quote
+func (th *TransactionHandler) CreateQuote(c *gin.Context) {
+	var req services.CreateQuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logrus.Warnf("Invalid request body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	quote, signedID, err := th.transactionService.CreateQuote(c.Request.Context(), &req)
+	if err != nil {
+		logrus.Errorf("Failed to create quote: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to create quote",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"quote_id":         signedID,
+			"btc_amount":       formatSats(quote.BTCAmountSats),
+			"output_currency":  quote.OutputCurrency,
+			"output_addresses": quote.OutputAddresses,
+			"rate":             formatAmount(quote.Rate, quote.OutputCurrency),
+			"estimated_output": formatAmount(quote.OutputAmount, quote.OutputCurrency),
+			"fee":              formatSats(quote.FeeBreakdown.TotalSats),
+			"fee_breakdown":    feeBreakdownResponse(quote.FeeBreakdown),
+			"max_slippage_bps": quote.SlippageBps,
+			"expires_at":       quote.ExpiresAt,
+		},
+	})
+}
+
 // InitiateExchange handles POST /api/v1/exchange/initiate
 func (th *TransactionHandler) InitiateExchange(c *gin.Context) {
 	var req services.CreateTransactionRequest
@@ -49,11 +90,13 @@ func (th *TransactionHandler) InitiateExchange(c *gin.Context) {
 		"data": gin.H{
 			"transaction_id":    transaction.ID,
 			"payment_address":   transaction.PaymentAddress,
-			"btc_amount":        transaction.BTCAmount,
+			"btc_amount":        formatSats(transaction.BTCAmountSats),
 			"output_currency":   transaction.OutputCurrency,
 			"output_addresses":  transaction.OutputAddresses,
-			"estimated_output":  transaction.EstimatedOutput,
-			"fee":              transaction.Fee,
+			"estimated_output":  formatAmount(transaction.EstimatedOutput, transaction.OutputCurrency),
+			"fee":              formatSats(transaction.FeeSats),
+			"fee_breakdown":    feeBreakdownResponse(transaction.FeeBreakdown),
+			"quote_id":         transaction.QuoteID,
 			"status":           transaction.Status,
 			"created_at":       transaction.CreatedAt,
 		},
@@ -85,11 +128,13 @@ func (th *TransactionHandler) GetTransactionStatus(c *gin.Context) {
 		"data": gin.H{
 			"transaction_id":    transaction.ID,
 			"payment_address":   transaction.PaymentAddress,
-			"btc_amount":        transaction.BTCAmount,
+			"btc_amount":        formatSats(transaction.BTCAmountSats),
 			"output_currency":   transaction.OutputCurrency,
 			"output_addresses":  transaction.OutputAddresses,
-			"estimated_output":  transaction.EstimatedOutput,
-			"fee":              transaction.Fee,
+			"estimated_output":  formatAmount(transaction.EstimatedOutput, transaction.OutputCurrency),
+			"fee":              formatSats(transaction.FeeSats),
+			"fee_breakdown":    feeBreakdownResponse(transaction.FeeBreakdown),
+			"quote_id":         transaction.QuoteID,
 			"status":           transaction.Status,
 			"created_at":       transaction.CreatedAt,
 			"updated_at":       transaction.UpdatedAt,
@@ -123,3 +168,40 @@ func (th *TransactionHandler) GetPaymentStatus(c *gin.Context) {
 		"data":    paymentStatus,
 	})
 }
+
+// formatSats formats satoshis as an exact decimal BTC string
+func formatSats(sats int64) string {
+	return money.NewAmount(sats).Decimal(8)
+}
+
+// formatAmount formats base units of a currency as an exact decimal string,
+// falling back to the base units for currencies without known decimals
+func formatAmount(amount money.Amount, currency string) string {
+	formatted, err := money.FormatCurrency(amount, currency)
+	if err != nil {
+		return amount.String()
+	}
+	return formatted
+}
+
+// feeBreakdownResponse formats a fee breakdown as decimal BTC strings
+func feeBreakdownResponse(breakdown *models.FeeBreakdown) gin.H {
+	if breakdown == nil {
+		return nil
+	}
+
+	payoutFees := make([]string, len(breakdown.PayoutFeesSats))
+	for i, fee := range breakdown.PayoutFeesSats {
+		payoutFees[i] = formatSats(fee)
+	}
+	return gin.H{
+		"service_fee": formatSats(breakdown.ServiceFeeSats),
+		"network_fee": formatSats(breakdown.NetworkFeeSats),
+		"sweep_fee":   formatSats(breakdown.SweepFeeSats),
+		"payout_fee":  formatSats(breakdown.PayoutFeeSats),
+		"payout_fees": payoutFees,
+		"spread":      formatSats(breakdown.SpreadSats),
+		"fee_rate":    breakdown.FeeRate,
+		"total":       formatSats(breakdown.TotalSats),
+	}
+}
@@ -2,8 +2,10 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -110,6 +112,36 @@ func Security() gin.HandlerFunc {
 	}
 }
 
+// AdminAuth requires the admin bearer token. Admin endpoints are disabled
+// when no token is configured.
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Route not found",
+				"path":  c.Request.URL.Path,
+			})
+			c.Abort()
+			return
+		}
+
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			logrus.WithFields(logrus.Fields{
+				"path": c.Request.URL.Path,
+				"ip":   c.ClientIP(),
+			}).Warn("Rejected admin request")
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Unauthorized",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
 // RequestID middleware adds a unique request ID to each request
 func RequestID() gin.HandlerFunc {
 	return func(c *gin.Context) {
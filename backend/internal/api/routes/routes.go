@@ -16,8 +16,10 @@ func SetupRoutes(
 	priceHandler *handlers.PriceHandler,
 	addressHandler *handlers.AddressHandler,
 	healthHandler *handlers.HealthHandler,
+	psbtHandler *handlers.PSBTHandler,
 	redisClient *redis.Client,
 	rateLimit int,
+	adminToken string,
 ) *gin.Engine {
 	r := gin.New()
 
@@ -53,10 +55,12 @@ func SetupRoutes(
 
 		// Price endpoints
 		v1.GET("/prices", priceHandler.GetPrices)
+		v1.GET("/prices/history", priceHandler.GetPriceHistory)
 
 		// Exchange endpoints
 		exchange := v1.Group("/exchange")
 		{
+			exchange.POST("/quote", transactionHandler.CreateQuote)
 			exchange.POST("/initiate", transactionHandler.InitiateExchange)
 			exchange.GET("/status/:id", transactionHandler.GetTransactionStatus)
 			exchange.GET("/payment/:id", transactionHandler.GetPaymentStatus)
@@ -71,6 +75,16 @@ func SetupRoutes(
 
 		// Supported currencies
 		v1.GET("/supported-currencies", healthHandler.GetSupportedCurrencies)
+
+		// Admin endpoints
+		admin := v1.Group("/admin", middleware.AdminAuth(adminToken))
+		{
+			admin.GET("/psbts", psbtHandler.ListPSBTs)
+			admin.GET("/psbts/:id", psbtHandler.GetPSBT)
+			admin.GET("/psbts/:id/download", psbtHandler.DownloadPSBT)
+			admin.POST("/psbts/:id/signed", psbtHandler.SubmitPSBT)
+			admin.POST("/psbts/:id/cancel", psbtHandler.CancelPSBT)
+		}
 	}
 
 	// Serve static files (for frontend)
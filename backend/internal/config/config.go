@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 
@@ -14,6 +15,13 @@ type Config struct {
 	Redis    RedisConfig
 	API      APIConfig
 	Wallet   WalletConfig
+	Chain    ChainConfig
+	Payment  PaymentConfig
+	Sweep    SweepConfig
+	Payout   PayoutConfig
+	Fee      FeeConfig
+	Quote    QuoteConfig
+	Price    PriceConfig
 }
 
 type ServerConfig struct {
@@ -42,11 +50,173 @@ type RedisConfig struct {
 type APIConfig struct {
 	CoinGeckoAPIKey string
 	RateLimit       int
+	// AdminToken is the bearer token for the admin endpoints, which are
+	// disabled when it is empty
+	AdminToken string
 }
 
 type WalletConfig struct {
 	MasterKey string
-	Testnet   bool
+	// Network is the Bitcoin network: mainnet, testnet3, testnet4, signet
+	// or regtest
+	Network string
+	// Testnet selects testnet3 over mainnet when Network is not set. It is
+	// kept for existing deployments.
+	Testnet bool
+	// XPrv seeds the HD keychain deposit addresses are derived from
+	XPrv string
+	// XPub runs the server watch-only, it can derive addresses but not sign
+	XPub string
+	// AddressType is the deposit address type: p2wpkh, p2sh-p2wpkh, p2tr or p2pkh
+	AddressType string
+	// MasterFingerprint identifies the seed of an account-level key in PSBTs
+	// for offline signers
+	MasterFingerprint string
+	// AccountPath is the path an account-level key was derived at, e.g.
+	// m/84'/0'/1', when its purpose or coin differ from the address type and
+	// network
+	AccountPath string
+}
+
+// NetworkName returns the configured Bitcoin network, falling back to the
+// Testnet flag
+func (w WalletConfig) NetworkName() string {
+	if w.Network != "" {
+		return w.Network
+	}
+	if w.Testnet {
+		return "testnet3"
+	}
+	return "mainnet"
+}
+
+// ExtendedKey returns the configured HD key, preferring the xprv
+func (w WalletConfig) ExtendedKey() string {
+	if w.XPrv != "" {
+		return w.XPrv
+	}
+	return w.XPub
+}
+
+// ChainConfig selects the blockchain backend used for payment detection
+type ChainConfig struct {
+	// Backend is one of esplora, bitcoind or fake
+	Backend          string
+	EsploraURL       string
+	BitcoindURL      string
+	BitcoindUser     string
+	BitcoindPassword string
+	// ZMQRawTxURL and ZMQHashBlockURL enable push mode payment detection
+	// through bitcoind's -zmqpubrawtx and -zmqpubhashblock endpoints
+	ZMQRawTxURL     string
+	ZMQHashBlockURL string
+}
+
+// PaymentConfig controls when received payments are considered final
+type PaymentConfig struct {
+	// ConfirmationTiers is a list of min_btc:confirmations pairs, e.g. "0:1,0.01:3"
+	ConfirmationTiers string
+	// ToleranceBps is how far, in basis points, a payment may deviate from the
+	// expected amount before it is treated as underpaid or overpaid
+	ToleranceBps int
+	// PollInterval is how often, in seconds, open transactions are checked
+	PollInterval int
+	// FastPollInterval is how often, in seconds, addresses with unconfirmed
+	// payments are checked
+	FastPollInterval int
+	// PollConcurrency is the number of addresses checked in parallel
+	PollConcurrency int
+	// LeaseDuration is how long, in seconds, a replica may hold a transaction
+	// before another replica is allowed to take it over. Leases are renewed
+	// while checks run, so it only bounds how long a crashed replica's
+	// transactions stay unchecked.
+	LeaseDuration int
+}
+
+// SweepConfig controls how received deposits are moved to cold storage
+type SweepConfig struct {
+	// Destination is a cold storage address or account-level xpub
+	Destination string
+	// FeeRate is the sweep fee rate in sat/vB
+	FeeRate int
+	// MinConfirmations is how deep a deposit must be buried before it is swept
+	MinConfirmations int
+	// MaxInputs caps the number of deposits spent by one sweep transaction
+	MaxInputs int
+	// OfflineSigning exports every sweep as a PSBT for offline signing
+	OfflineSigning bool
+	// HotSignLimit is the largest sweep in satoshis signed with hot keys,
+	// larger ones are exported as PSBTs. Zero means no limit.
+	HotSignLimit int
+}
+
+// PayoutConfig controls how BTC exchange outputs are sent
+type PayoutConfig struct {
+	// FeeRate is the payout fee rate in sat/vB, deducted from the output.
+	// Zero uses the estimated fee rate.
+	FeeRate int
+	// MinConfirmations is how deep a payout must be buried before its
+	// transaction is completed
+	MinConfirmations int
+	// OfflineSigning exports every payout as a PSBT for offline signing
+	OfflineSigning bool
+	// HotSignLimit is the largest payout in satoshis signed with hot keys,
+	// larger ones are exported as PSBTs. Zero means no limit.
+	HotSignLimit int
+	// DryRunCurrencies lists the non-BTC output currencies, comma separated,
+	// whose payouts are simulated by a dry-run payout rail
+	DryRunCurrencies string
+	// EVMChainID is the chain ID of ETH and ERC-20 token payouts. Zero uses
+	// Ethereum mainnet, or Sepolia on testnet.
+	EVMChainID int
+}
+
+// FeeConfig controls fee rate estimation and the fees quoted for exchanges
+type FeeConfig struct {
+	// TargetBlocks is the confirmation target fee rates are estimated for
+	TargetBlocks int
+	// FallbackRate is the fee rate in sat/vB used when the chain backend has
+	// no estimate
+	FallbackRate int
+	// CacheTTL is how long, in seconds, an estimated fee rate is reused
+	CacheTTL int
+	// SpreadBps is the margin, in basis points, taken on the exchange rate of
+	// non-BTC outputs
+	SpreadBps int
+}
+
+// QuoteConfig controls the rates locked by exchange quotes
+type QuoteConfig struct {
+	// TTL is how long, in seconds, a quoted rate is locked
+	TTL int
+	// MaxSlippageBps is how far, in basis points, the output of a payment
+	// arriving after its quote expired may fall below the quoted output
+	MaxSlippageBps int
+	// SigningKey authenticates quote IDs; derived from the wallet master key
+	// when empty
+	SigningKey string
+}
+
+// PriceConfig controls where prices come from and when they are trusted
+type PriceConfig struct {
+	// Sources lists the price sources, comma separated: coingecko, kraken,
+	// binance, coinbase, or fixtures for fixed prices without network access
+	Sources string
+	// MaxDeviationBps is how far, in basis points, a source may be from the
+	// median price before it is dropped
+	MaxDeviationBps int
+	// MinSources is the number of sources that have to agree on a price
+	MinSources int
+	// RefreshInterval is how often, in seconds, prices are refreshed in the
+	// background
+	RefreshInterval int
+	// MaxAge is how old, in seconds, prices may be before they are refused
+	MaxAge int
+	// TickRetentionDays is how long every refreshed price is kept
+	TickRetentionDays int
+	// MinuteCandleRetentionDays is how long minute candles are kept, hour
+	// and day candles are kept forever
+	MinuteCandleRetentionDays int
 }
 
 func Load() (*Config, error) {
@@ -79,11 +249,84 @@ func Load() (*Config, error) {
 		API: APIConfig{
 			CoinGeckoAPIKey: getEnv("COINGECKO_API_KEY", ""),
 			RateLimit:       getEnvAsInt("RATE_LIMIT", 100),
+			AdminToken:      getEnv("ADMIN_API_TOKEN", ""),
 		},
 		Wallet: WalletConfig{
-			MasterKey: getEnv("WALLET_MASTER_KEY", ""),
-			Testnet:   getEnvAsBool("WALLET_TESTNET", false),
+			MasterKey:         getEnv("WALLET_MASTER_KEY", ""),
+			Network:           getEnv("WALLET_NETWORK", ""),
+			Testnet:           getEnvAsBool("WALLET_TESTNET", false),
+			XPrv:              getEnv("WALLET_XPRV", ""),
+			XPub:              getEnv("WALLET_XPUB", ""),
+			AddressType:       getEnv("WALLET_ADDRESS_TYPE", "p2wpkh"),
+			MasterFingerprint: getEnv("WALLET_MASTER_FINGERPRINT", ""),
+			AccountPath:       getEnv("WALLET_ACCOUNT_PATH", ""),
+		},
+		Chain: ChainConfig{
+			Backend:          getEnv("CHAIN_BACKEND", "esplora"),
+			EsploraURL:       getEnv("ESPLORA_URL", ""),
+			BitcoindURL:      getEnv("BITCOIND_RPC_URL", ""),
+			BitcoindUser:     getEnv("BITCOIND_RPC_USER", ""),
+			BitcoindPassword: getEnv("BITCOIND_RPC_PASSWORD", ""),
+			ZMQRawTxURL:      getEnv("BITCOIND_ZMQ_RAWTX", ""),
+			ZMQHashBlockURL:  getEnv("BITCOIND_ZMQ_HASHBLOCK", ""),
+		},
+		Payment: PaymentConfig{
+			ConfirmationTiers: getEnv("PAYMENT_CONFIRMATION_TIERS", "0:1,0.01:3"),
+			ToleranceBps:      getEnvAsInt("PAYMENT_TOLERANCE_BPS", 10),
+			PollInterval:      getEnvAsInt("PAYMENT_POLL_INTERVAL", 30),
+			FastPollInterval:  getEnvAsInt("PAYMENT_FAST_POLL_INTERVAL", 10),
+			PollConcurrency:   getEnvAsInt("PAYMENT_POLL_CONCURRENCY", 4),
+			LeaseDuration:     getEnvAsInt("PAYMENT_LEASE_DURATION", 120),
+		},
+		Sweep: SweepConfig{
+			Destination:      getEnv("SWEEP_DESTINATION", ""),
+			FeeRate:          getEnvAsInt("SWEEP_FEE_RATE", 5),
+			MinConfirmations: getEnvAsInt("SWEEP_MIN_CONFIRMATIONS", 6),
+			MaxInputs:        getEnvAsInt("SWEEP_MAX_INPUTS", 200),
+			OfflineSigning:   getEnvAsBool("SWEEP_OFFLINE_SIGNING", false),
+			HotSignLimit:     getEnvAsInt("SWEEP_HOT_SIGN_LIMIT_SATS", 0),
+		},
+		Payout: PayoutConfig{
+			FeeRate:          getEnvAsInt("PAYOUT_FEE_RATE", 0),
+			MinConfirmations: getEnvAsInt("PAYOUT_MIN_CONFIRMATIONS", 1),
+			OfflineSigning:   getEnvAsBool("PAYOUT_OFFLINE_SIGNING", false),
+			HotSignLimit:     getEnvAsInt("PAYOUT_HOT_SIGN_LIMIT_SATS", 0),
+			DryRunCurrencies: getEnv("PAYOUT_DRY_RUN_CURRENCIES", ""),
+			EVMChainID:       getEnvAsInt("PAYOUT_EVM_CHAIN_ID", 0),
 		},
+		Fee: FeeConfig{
+			TargetBlocks: getEnvAsInt("FEE_ESTIMATE_TARGET_BLOCKS", 6),
+			FallbackRate: getEnvAsInt("FEE_FALLBACK_RATE", 5),
+			CacheTTL:     getEnvAsInt("FEE_ESTIMATE_CACHE_TTL", 60),
+			SpreadBps:    getEnvAsInt("QUOTE_SPREAD_BPS", 0),
+		},
+		Quote: QuoteConfig{
+			TTL:            getEnvAsInt("QUOTE_TTL", 600),
+			MaxSlippageBps: getEnvAsInt("QUOTE_MAX_SLIPPAGE_BPS", 100),
+			SigningKey:     getEnv("QUOTE_SIGNING_KEY", ""),
+		},
+		Price: PriceConfig{
+			Sources:                   getEnv("PRICE_SOURCES", "coingecko,kraken,binance,coinbase"),
+			MaxDeviationBps:           getEnvAsInt("PRICE_MAX_DEVIATION_BPS", 200),
+			MinSources:                getEnvAsInt("PRICE_MIN_SOURCES", 2),
+			RefreshInterval:           getEnvAsInt("PRICE_REFRESH_INTERVAL", 30),
+			MaxAge:                    getEnvAsInt("PRICE_MAX_AGE", 300),
+			TickRetentionDays:         getEnvAsInt("PRICE_TICK_RETENTION_DAYS", 7),
+			MinuteCandleRetentionDays: getEnvAsInt("PRICE_MINUTE_CANDLE_RETENTION_DAYS", 30),
+		},
+	}
+
+	// Deposit keys are encrypted with the master key, refuse to start without one
+	if config.Wallet.MasterKey == "" {
+		return nil, fmt.Errorf("WALLET_MASTER_KEY is required")
+	}
+
+	// Deposit addresses are derived from a single HD key
+	if config.Wallet.XPrv == "" && config.Wallet.XPub == "" {
+		return nil, fmt.Errorf("one of WALLET_XPRV or WALLET_XPUB is required")
+	}
+	if config.Wallet.XPrv != "" && config.Wallet.XPub != "" {
+		return nil, fmt.Errorf("WALLET_XPRV and WALLET_XPUB are mutually exclusive")
 	}
 
 	return config, nil
@@ -2,13 +2,16 @@ package database
 
 import (
 	"fmt"
+	"strconv"
 
 	"hellomix-backend/internal/config"
 	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/money"
 
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -46,12 +49,23 @@ func New(cfg *config.DatabaseConfig) (*Database, error) {
 
 func (d *Database) Migrate() error {
 	logrus.Info("Running database migrations...")
-	
+
+	// Convert columns of earlier schemas before the models are migrated
+	if err := migrateAmountsToBaseUnits(d.DB); err != nil {
+		return fmt.Errorf("failed to convert amounts to base units: %w", err)
+	}
+
 	return d.DB.AutoMigrate(
 		&models.Transaction{},
+		&models.Quote{},
 		&models.Payment{},
 		&models.Wallet{},
+		&models.Sweep{},
+		&models.PSBT{},
+		&models.Payout{},
 		&models.PriceCache{},
+		&models.PriceTick{},
+		&models.PriceCandle{},
 		&models.SupportedCurrency{},
 	)
 }
@@ -59,77 +73,40 @@ func (d *Database) Migrate() error {
 func (d *Database) Seed() error {
 	logrus.Info("Seeding database with initial data...")
 
-	// Check if supported currencies already exist
-	var count int64
-	d.DB.Model(&models.SupportedCurrency{}).Count(&count)
-	if count > 0 {
-		logrus.Info("Database already seeded")
-		return nil
+	// The output currencies of the currency registry, brought up to date on
+	// every start
+	var supportedCurrencies []models.SupportedCurrency
+	var symbols []string
+	for _, currency := range money.ListCurrencies() {
+		if currency.AddressFormat == "" {
+			continue
+		}
+		minAmount, _ := strconv.ParseFloat(currency.MinAmount.Decimal(currency.Decimals), 64)
+		maxAmount, _ := strconv.ParseFloat(currency.MaxAmount.Decimal(currency.Decimals), 64)
+		supportedCurrencies = append(supportedCurrencies, models.SupportedCurrency{
+			Symbol:    currency.Code,
+			Name:      currency.Name,
+			MinAmount: minAmount,
+			MaxAmount: maxAmount,
+			Fee:       float64(currency.FeeBps) / 10000,
+			IsActive:  true,
+		})
+		symbols = append(symbols, currency.Code)
 	}
 
-	supportedCurrencies := []models.SupportedCurrency{
-		{
-			Symbol:    "BTC",
-			Name:      "Bitcoin",
-			MinAmount: 0.001,
-			MaxAmount: 10,
-			Fee:       0.002, // 0.2%
-			IsActive:  true,
-		},
-		{
-			Symbol:    "ETH",
-			Name:      "Ethereum",
-			MinAmount: 0.01,
-			MaxAmount: 100,
-			Fee:       0.005, // 0.5%
-			IsActive:  true,
-		},
-		{
-			Symbol:    "USDT",
-			Name:      "Tether",
-			MinAmount: 10,
-			MaxAmount: 50000,
-			Fee:       0.005, // 0.5%
-			IsActive:  true,
-		},
-		{
-			Symbol:    "USDC",
-			Name:      "USD Coin",
-			MinAmount: 10,
-			MaxAmount: 50000,
-			Fee:       0.005, // 0.5%
-			IsActive:  true,
-		},
-		{
-			Symbol:    "ADA",
-			Name:      "Cardano",
-			MinAmount: 100,
-			MaxAmount: 500000,
-			Fee:       0.005, // 0.5%
-			IsActive:  true,
-		},
-		{
-			Symbol:    "SOL",
-			Name:      "Solana",
-			MinAmount: 1,
-			MaxAmount: 10000,
-			Fee:       0.005, // 0.5%
-			IsActive:  true,
-		},
-		{
-			Symbol:    "MATIC",
-			Name:      "Polygon",
-			MinAmount: 100,
-			MaxAmount: 1000000,
-			Fee:       0.005, // 0.5%
-			IsActive:  true,
-		},
+	if err := d.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "symbol"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "min_amount", "max_amount", "fee", "is_active", "updated_at"}),
+	}).Create(&supportedCurrencies).Error; err != nil {
+		return fmt.Errorf("failed to seed currencies: %w", err)
 	}
 
-	for _, currency := range supportedCurrencies {
-		if err := d.DB.Create(&currency).Error; err != nil {
-			logrus.Errorf("Failed to seed currency %s: %v", currency.Symbol, err)
-		}
+	// Currencies that left the registry, such as USDT before it was told
+	// apart by network, are no longer offered
+	if err := d.DB.Model(&models.SupportedCurrency{}).
+		Where("symbol NOT IN ? AND is_active = ?", symbols, true).
+		Update("is_active", false).Error; err != nil {
+		return fmt.Errorf("failed to deactivate unregistered currencies: %w", err)
 	}
 
 	logrus.Info("Database seeded successfully")
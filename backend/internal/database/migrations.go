@@ -0,0 +1,102 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"hellomix-backend/pkg/money"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// migrateAmountsToBaseUnits converts the decimal amount columns of earlier
+// schemas to integer base units of their currency: BTC amounts and fees to
+// satoshis, outputs to base units of the output currency. Columns are
+// renamed, so each step runs once and is skipped on converted databases.
+func migrateAmountsToBaseUnits(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		migrator := tx.Migrator()
+
+		if migrator.HasTable("transactions") && migrator.HasColumn("transactions", "btc_amount") {
+			logrus.Info("Converting transaction amounts to base units...")
+			outputScale := decimalsScale("output_currency")
+			statements := []string{
+				`ALTER TABLE transactions RENAME COLUMN btc_amount TO btc_amount_sats`,
+				`ALTER TABLE transactions ALTER COLUMN btc_amount_sats TYPE bigint USING round(btc_amount_sats * 100000000)`,
+				`ALTER TABLE transactions RENAME COLUMN fee TO fee_sats`,
+				`ALTER TABLE transactions ALTER COLUMN fee_sats DROP DEFAULT`,
+				`ALTER TABLE transactions ALTER COLUMN fee_sats TYPE bigint USING round(coalesce(fee_sats, 0) * 100000000)`,
+				`ALTER TABLE transactions ALTER COLUMN fee_sats SET DEFAULT 0`,
+				`ALTER TABLE transactions ALTER COLUMN fee_sats SET NOT NULL`,
+				`ALTER TABLE transactions RENAME COLUMN estimated_output TO estimated_output_units`,
+				`ALTER TABLE transactions ALTER COLUMN estimated_output_units TYPE numeric(78,0) USING round(coalesce(estimated_output_units, 0) * ` + outputScale + `)`,
+				`ALTER TABLE transactions RENAME COLUMN final_output TO final_output_units`,
+				`ALTER TABLE transactions ALTER COLUMN final_output_units TYPE numeric(78,0) USING round(coalesce(final_output_units, 0) * ` + outputScale + `)`,
+			}
+			if migrator.HasColumn("transactions", "fee_breakdown") {
+				statements = append(statements, `UPDATE transactions SET fee_breakdown = jsonb_build_object(
+					'service_fee_sats', round((fee_breakdown->>'service_fee')::numeric * 100000000)::bigint,
+					'network_fee_sats', round((fee_breakdown->>'network_fee')::numeric * 100000000)::bigint,
+					'sweep_fee_sats', round((fee_breakdown->>'sweep_fee')::numeric * 100000000)::bigint,
+					'payout_fee_sats', round((fee_breakdown->>'payout_fee')::numeric * 100000000)::bigint,
+					'payout_fees_sats', coalesce((SELECT jsonb_agg(round(fee::numeric * 100000000)::bigint ORDER BY n)
+						FROM jsonb_array_elements_text(fee_breakdown->'payout_fees') WITH ORDINALITY AS fees(fee, n)), '[]'::jsonb),
+					'spread_sats', round((fee_breakdown->>'spread')::numeric * 100000000)::bigint,
+					'fee_rate', fee_breakdown->'fee_rate',
+					'total_sats', round((fee_breakdown->>'total')::numeric * 100000000)::bigint)
+				WHERE fee_breakdown->>'total' IS NOT NULL`)
+			}
+			if err := execAll(tx, statements); err != nil {
+				return err
+			}
+		}
+
+		if migrator.HasTable("payments") && migrator.HasColumn("payments", "amount_btc") {
+			// Payments already record satoshis
+			if err := tx.Exec(`ALTER TABLE payments DROP COLUMN amount_btc`).Error; err != nil {
+				return fmt.Errorf("failed to drop payments.amount_btc: %w", err)
+			}
+		}
+
+		if migrator.HasTable("payouts") && migrator.HasColumn("payouts", "amount") {
+			logrus.Info("Converting payout amounts to base units...")
+			scale := decimalsScale("currency")
+			if err := execAll(tx, []string{
+				`UPDATE payouts SET legs = (SELECT coalesce(jsonb_agg((leg - 'amount') ||
+						jsonb_build_object('amount_units', round(coalesce((leg->>'amount')::numeric, 0) * ` + scale + `)::text) ORDER BY n), '[]'::jsonb)
+					FROM jsonb_array_elements(legs) WITH ORDINALITY AS l(leg, n))`,
+				`ALTER TABLE payouts RENAME COLUMN amount TO amount_units`,
+				`ALTER TABLE payouts ALTER COLUMN amount_units DROP DEFAULT`,
+				`ALTER TABLE payouts ALTER COLUMN amount_units TYPE numeric(78,0) USING round(amount_units * ` + scale + `)`,
+				`ALTER TABLE payouts ALTER COLUMN amount_units SET DEFAULT 0`,
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// decimalsScale builds a SQL expression for 10^decimals of the currency in
+// column, from the currency decimals registry. Unknown currencies yield NULL,
+// failing the migration rather than guessing.
+func decimalsScale(column string) string {
+	var cases strings.Builder
+	for _, currency := range money.Currencies() {
+		decimals, _ := money.Decimals(currency)
+		fmt.Fprintf(&cases, " WHEN '%s' THEN %d", currency, decimals)
+	}
+	return fmt.Sprintf("power(10::numeric, CASE upper(%s)%s END)", column, cases.String())
+}
+
+// execAll runs SQL statements in order
+func execAll(tx *gorm.DB, statements []string) error {
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to run %q: %w", strings.SplitN(statement, "\n", 2)[0], err)
+		}
+	}
+	return nil
+}
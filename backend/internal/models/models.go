@@ -5,21 +5,34 @@ import (
 	"encoding/json"
 	"time"
 
+	"hellomix-backend/pkg/money"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-// Transaction represents a cryptocurrency exchange transaction
+// Transaction represents a cryptocurrency exchange transaction. Amounts are
+// integer base units: satoshis for BTC, base units of OutputCurrency for the
+// estimated and final output.
 type Transaction struct {
 	ID              uuid.UUID       `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	BTCAmount       float64         `json:"btc_amount" gorm:"type:decimal(18,8);not null"`
-	OutputCurrency  string          `json:"output_currency" gorm:"type:varchar(10);not null"`
+	BTCAmountSats   int64           `json:"btc_amount_sats" gorm:"not null"`
+	OutputCurrency  string          `json:"output_currency" gorm:"type:varchar(20);not null"`
 	OutputAddresses OutputAddresses `json:"output_addresses" gorm:"type:jsonb;not null"`
 	PaymentAddress  string          `json:"payment_address" gorm:"type:varchar(100);not null"`
 	Status          string          `json:"status" gorm:"type:varchar(20);not null;default:'pending'"`
-	Fee             float64         `json:"fee" gorm:"type:decimal(18,8);default:0"`
-	EstimatedOutput float64         `json:"estimated_output" gorm:"type:decimal(18,8)"`
-	FinalOutput     float64         `json:"final_output" gorm:"type:decimal(18,8)"`
+	FeeSats         int64           `json:"fee_sats" gorm:"not null;default:0"`
+	FeeBreakdown    *FeeBreakdown   `json:"fee_breakdown,omitempty" gorm:"type:jsonb"`
+	EstimatedOutput money.Amount    `json:"estimated_output_units" gorm:"column:estimated_output_units;type:numeric(78,0);not null;default:0"`
+	FinalOutput     money.Amount    `json:"final_output_units" gorm:"column:final_output_units;type:numeric(78,0);not null;default:0"`
+	ExpiresAt       *time.Time      `json:"expires_at"`
+	QuoteID         *uuid.UUID      `json:"quote_id,omitempty" gorm:"type:uuid;uniqueIndex"`
+	LeaseOwner      string          `json:"-" gorm:"type:varchar(100);not null;default:''"`
+	LeaseExpiresAt  *time.Time      `json:"-" gorm:"index"`
+	NextCheckAt     *time.Time      `json:"-" gorm:"index"`
+	PaidOutAt       *time.Time      `json:"paid_out_at,omitempty"`
+	FinalizedAt     *time.Time      `json:"finalized_at,omitempty"`
+	Payouts         []Payout        `json:"payouts,omitempty" gorm:"foreignKey:TransactionID"`
 	CreatedAt       time.Time       `json:"created_at"`
 	UpdatedAt       time.Time       `json:"updated_at"`
 }
@@ -28,6 +41,9 @@ type Transaction struct {
 type OutputAddress struct {
 	Address    string  `json:"address"`
 	Percentage float64 `json:"percentage"`
+	// Memo is the memo or destination tag of the payout, on chains that
+	// carry one
+	Memo string `json:"memo,omitempty"`
 }
 
 // OutputAddresses is a slice of OutputAddress that implements sql.Scanner and driver.Valuer
@@ -56,16 +72,165 @@ func (oa OutputAddresses) Value() (driver.Value, error) {
 	return json.Marshal(oa)
 }
 
-// PriceCache represents cached cryptocurrency prices
+// FeeBreakdown itemizes the fee of a transaction quoted when it was created.
+// Amounts are in satoshis; TotalSats is what FeeSats records.
+type FeeBreakdown struct {
+	// ServiceFeeSats is the exchange's own fee
+	ServiceFeeSats int64 `json:"service_fee_sats"`
+	// NetworkFeeSats is the projected miner fee, sweep plus payout fee
+	NetworkFeeSats int64 `json:"network_fee_sats"`
+	// SweepFeeSats is the miner fee of moving the deposit to cold storage
+	SweepFeeSats int64 `json:"sweep_fee_sats"`
+	// PayoutFeeSats is the miner fee of delivering the output, PayoutFeesSats per leg
+	PayoutFeeSats  int64   `json:"payout_fee_sats"`
+	PayoutFeesSats []int64 `json:"payout_fees_sats"`
+	// SpreadSats is the margin taken on the exchange rate
+	SpreadSats int64 `json:"spread_sats"`
+	// FeeRate is the Bitcoin fee rate in sat/vB the network fee was projected at
+	FeeRate   int64 `json:"fee_rate"`
+	TotalSats int64 `json:"total_sats"`
+}
+
+// Scan implements sql.Scanner interface
+func (fb *FeeBreakdown) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(bytes, fb)
+}
+
+// Value implements driver.Valuer interface
+func (fb FeeBreakdown) Value() (driver.Value, error) {
+	return json.Marshal(fb)
+}
+
+// StringList is a slice of strings stored as a JSON array
+type StringList []string
+
+// Scan implements sql.Scanner interface
+func (sl *StringList) Scan(value interface{}) error {
+	if value == nil {
+		*sl = StringList{}
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(bytes, sl)
+}
+
+// Value implements driver.Valuer interface
+func (sl StringList) Value() (driver.Value, error) {
+	if sl == nil {
+		return json.Marshal([]string{})
+	}
+	return json.Marshal(sl)
+}
+
+// UUIDList is a slice of UUIDs stored as a JSON array
+type UUIDList []uuid.UUID
+
+// Scan implements sql.Scanner interface
+func (ul *UUIDList) Scan(value interface{}) error {
+	if value == nil {
+		*ul = UUIDList{}
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(bytes, ul)
+}
+
+// Value implements driver.Valuer interface
+func (ul UUIDList) Value() (driver.Value, error) {
+	if ul == nil {
+		return json.Marshal([]uuid.UUID{})
+	}
+	return json.Marshal(ul)
+}
+
+// Quote is an exchange rate locked for a while. A transaction created from a
+// quote, at most one, is paid OutputAmount when its payment arrives before
+// ExpiresAt; later payments are repriced, within SlippageBps of OutputAmount.
+// Rate is the output, in base units of OutputCurrency, of 1 BTC.
+type Quote struct {
+	ID              uuid.UUID       `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	BTCAmountSats   int64           `json:"btc_amount_sats" gorm:"not null"`
+	OutputCurrency  string          `json:"output_currency" gorm:"type:varchar(20);not null"`
+	OutputAddresses OutputAddresses `json:"output_addresses" gorm:"type:jsonb;not null"`
+	FeeBreakdown    *FeeBreakdown   `json:"fee_breakdown" gorm:"type:jsonb;not null"`
+	Rate            money.Amount    `json:"rate_units" gorm:"column:rate_units;type:numeric(78,0);not null"`
+	OutputAmount    money.Amount    `json:"output_amount_units" gorm:"column:output_amount_units;type:numeric(78,0);not null"`
+	SlippageBps     int64           `json:"slippage_bps" gorm:"not null;default:0"`
+	ExpiresAt       time.Time       `json:"expires_at" gorm:"not null"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (q *Quote) BeforeCreate(tx *gorm.DB) error {
+	if q.ID == uuid.Nil {
+		q.ID = uuid.New()
+	}
+	return nil
+}
+
+// PriceCache represents cached cryptocurrency prices and the price sources
+// that agreed on them
 type PriceCache struct {
-	Currency    string    `json:"currency" gorm:"primary_key;type:varchar(10)"`
-	PriceUSD    float64   `json:"price_usd" gorm:"type:decimal(18,8);not null"`
-	LastUpdated time.Time `json:"last_updated" gorm:"default:now()"`
+	Currency    string     `json:"currency" gorm:"primary_key;type:varchar(10)"`
+	PriceUSD    float64    `json:"price_usd" gorm:"type:decimal(18,8);not null"`
+	Sources     StringList `json:"sources" gorm:"type:jsonb"`
+	LastUpdated time.Time  `json:"last_updated" gorm:"default:now()"`
+}
+
+// PriceTick is one price of a currency as published by a price refresh.
+// Ticks are only ever appended, and pruned once they are past retention.
+type PriceTick struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Currency  string     `json:"currency" gorm:"type:varchar(10);not null;index:idx_price_ticks_currency_fetched_at,priority:1"`
+	PriceUSD  float64    `json:"price_usd" gorm:"type:decimal(18,8);not null"`
+	Sources   StringList `json:"sources" gorm:"type:jsonb"`
+	FetchedAt time.Time  `json:"fetched_at" gorm:"not null;index:idx_price_ticks_currency_fetched_at,priority:2;index"`
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (pt *PriceTick) BeforeCreate(tx *gorm.DB) error {
+	if pt.ID == uuid.Nil {
+		pt.ID = uuid.New()
+	}
+	return nil
+}
+
+// PriceCandle is the open, high, low and close USD price of a currency over
+// the Interval starting at OpenTime, downsampled from price ticks
+type PriceCandle struct {
+	Currency  string    `json:"currency" gorm:"primary_key;type:varchar(10)"`
+	Interval  string    `json:"interval" gorm:"primary_key;type:varchar(4)"`
+	OpenTime  time.Time `json:"open_time" gorm:"primary_key"`
+	Open      float64   `json:"open" gorm:"type:decimal(18,8);not null"`
+	High      float64   `json:"high" gorm:"type:decimal(18,8);not null"`
+	Low       float64   `json:"low" gorm:"type:decimal(18,8);not null"`
+	Close     float64   `json:"close" gorm:"type:decimal(18,8);not null"`
+	TickCount int64     `json:"ticks" gorm:"not null"`
+	UpdatedAt time.Time `json:"-"`
 }
 
 // SupportedCurrency represents supported cryptocurrencies
 type SupportedCurrency struct {
-	Symbol      string  `json:"symbol" gorm:"primary_key;type:varchar(10)"`
+	Symbol      string  `json:"symbol" gorm:"primary_key;type:varchar(20)"`
 	Name        string  `json:"name" gorm:"type:varchar(50);not null"`
 	MinAmount   float64 `json:"min_amount" gorm:"type:decimal(18,8);default:0"`
 	MaxAmount   float64 `json:"max_amount" gorm:"type:decimal(18,8);default:0"`
@@ -83,6 +248,8 @@ const (
 	StatusCompleted  = "completed"
 	StatusFailed     = "failed"
 	StatusExpired    = "expired"
+	StatusUnderpaid  = "underpaid"
+	StatusOverpaid   = "overpaid"
 )
 
 // BeforeCreate will set a UUID rather than numeric ID.
@@ -93,16 +260,19 @@ func (t *Transaction) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
-// Payment represents a Bitcoin payment received for a transaction
+// Payment represents a single Bitcoin output received for a transaction.
+// A transaction may be paid with several outputs, one row per txid:vout.
 type Payment struct {
 	ID            uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	TransactionID uuid.UUID `json:"transaction_id" gorm:"type:uuid;not null;index"`
 	Address       string    `json:"address" gorm:"type:varchar(100);not null"`
 	AmountSats    int64     `json:"amount_sats" gorm:"not null"`
-	AmountBTC     float64   `json:"amount_btc" gorm:"type:decimal(18,8);not null"`
-	TXID          string    `json:"txid" gorm:"type:varchar(100)"`
+	TXID          string    `json:"txid" gorm:"type:varchar(100);uniqueIndex:idx_payments_txid_vout"`
+	Vout          int       `json:"vout" gorm:"not null;default:0;uniqueIndex:idx_payments_txid_vout"`
 	Confirmations int       `json:"confirmations" gorm:"default:0"`
-	Status        string    `json:"status" gorm:"type:varchar(20);not null"`
+	BlockHash     string    `json:"block_hash,omitempty" gorm:"type:varchar(64);not null;default:''"`
+	BlockHeight   int64     `json:"block_height,omitempty" gorm:"not null;default:0"`
+	Status        string    `json:"status" gorm:"type:varchar(20);not null"` // unconfirmed, confirmed, dropped
 	DetectedAt    time.Time `json:"detected_at"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
@@ -116,15 +286,30 @@ func (p *Payment) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
-// Wallet represents a Bitcoin wallet with encrypted private key
+// Wallet roles
+const (
+	WalletRoleDeposit = "deposit"
+	WalletRoleChange  = "change"
+)
+
+// Wallet represents a Bitcoin address of the service: the deposit address of
+// a transaction, or the change address of a BTC payout, which belongs to no
+// transaction. HD wallets record the keychain fingerprint and derivation
+// index the key can be re-derived from; legacy wallets carry an encrypted
+// private key instead.
 type Wallet struct {
-	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	Address          string     `json:"address" gorm:"type:varchar(100);not null;unique"`
-	EncryptedPrivKey string     `json:"-" gorm:"type:text;not null"` // Never expose in JSON
-	TransactionID    *uuid.UUID `json:"transaction_id" gorm:"type:uuid;index"`
-	IsActive         bool       `json:"is_active" gorm:"default:true"`
-	CreatedAt        time.Time  `json:"created_at"`
-	UpdatedAt        time.Time  `json:"updated_at"`
+	ID                  uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Address             string     `json:"address" gorm:"type:varchar(100);not null;unique"`
+	EncryptedPrivKey    string     `json:"-" gorm:"type:text"` // Never expose in JSON
+	AddressType         string     `json:"address_type" gorm:"type:varchar(16);not null;default:'p2pkh';uniqueIndex:idx_wallets_keychain_index"`
+	KeychainFingerprint string     `json:"keychain_fingerprint" gorm:"type:varchar(8);uniqueIndex:idx_wallets_keychain_index"`
+	DerivationIndex     *uint32    `json:"derivation_index" gorm:"uniqueIndex:idx_wallets_keychain_index"`
+	DerivationPath      string     `json:"derivation_path" gorm:"type:varchar(64)"`
+	TransactionID       *uuid.UUID `json:"transaction_id" gorm:"type:uuid;index"`
+	Role                string     `json:"role" gorm:"type:varchar(16);not null;default:'deposit'"`
+	IsActive            bool       `json:"is_active" gorm:"default:true"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
 }
 
 // BeforeCreate will set a UUID rather than numeric ID.
@@ -134,3 +319,171 @@ func (w *Wallet) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// Sweep records a transaction that consolidated deposits into cold storage.
+// DestinationIndex is set when the destination was derived from a cold xpub.
+type Sweep struct {
+	ID               uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TXID             string    `json:"txid" gorm:"type:varchar(100);not null;unique"`
+	Destination      string    `json:"destination" gorm:"type:varchar(100);not null"`
+	DestinationIndex *uint32   `json:"destination_index,omitempty"`
+	InputCount       int       `json:"input_count" gorm:"not null"`
+	AmountSats       int64     `json:"amount_sats" gorm:"not null"`
+	FeeSats          int64     `json:"fee_sats" gorm:"not null"`
+	Status           string    `json:"status" gorm:"type:varchar(20);not null"` // broadcast
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (s *Sweep) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// PSBT kinds
+const (
+	PSBTKindSweep  = "sweep"
+	PSBTKindPayout = "payout"
+)
+
+// PSBT statuses
+const (
+	PSBTStatusUnsigned  = "unsigned"
+	PSBTStatusBroadcast = "broadcast"
+	PSBTStatusCancelled = "cancelled"
+)
+
+// PSBT is a transaction exported for offline signing. It records the
+// exchange transactions it settles, the deposit outputs it spends so that
+// no other transaction spends them meanwhile, and the wallets that are empty
+// once it is broadcast.
+type PSBT struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Kind             string     `json:"kind" gorm:"type:varchar(20);not null;index"`
+	Status           string     `json:"status" gorm:"type:varchar(20);not null;index"`
+	UnsignedPSBT     string     `json:"unsigned_psbt" gorm:"type:text;not null"`
+	SignedPSBT       string     `json:"signed_psbt,omitempty" gorm:"type:text"`
+	TXID             string     `json:"txid,omitempty" gorm:"type:varchar(100);not null;default:''"`
+	TransactionIDs   UUIDList   `json:"transaction_ids" gorm:"type:jsonb;not null"`
+	Outpoints        StringList `json:"outpoints" gorm:"type:jsonb;not null"`
+	SweptWallets     StringList `json:"swept_wallets,omitempty" gorm:"type:jsonb"`
+	Destination      string     `json:"destination" gorm:"type:varchar(100);not null;default:''"`
+	DestinationIndex *uint32    `json:"destination_index,omitempty"`
+	AmountSats       int64      `json:"amount_sats" gorm:"not null"`
+	FeeSats          int64      `json:"fee_sats" gorm:"not null"`
+	BroadcastAt      *time.Time `json:"broadcast_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// TableName keeps gorm from naming the table "ps_bts"
+func (PSBT) TableName() string {
+	return "psbts"
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (p *PSBT) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// Payout statuses
+const (
+	PayoutStatusPending           = "pending"
+	PayoutStatusSigned            = "signed"
+	PayoutStatusAwaitingSignature = "awaiting_signature"
+	PayoutStatusBroadcast         = "broadcast"
+	PayoutStatusConfirmed         = "confirmed"
+	PayoutStatusCancelled         = "cancelled"
+	PayoutStatusFailed            = "failed"
+)
+
+// Payout leg statuses
+const (
+	PayoutLegPending   = "pending"
+	PayoutLegSent      = "sent"
+	PayoutLegConfirmed = "confirmed"
+	PayoutLegFailed    = "failed"
+)
+
+// PayoutLeg is the amount, in base units of the payout currency, paid to one
+// output address of a transaction. Payouts through a payout rail send every
+// leg separately and record its transaction hash and status; the legs of a
+// BTC payout share its transaction.
+type PayoutLeg struct {
+	Address    string       `json:"address"`
+	Memo       string       `json:"memo,omitempty"`
+	Percentage float64      `json:"percentage"`
+	Amount     money.Amount `json:"amount_units"`
+	AmountSats int64        `json:"amount_sats,omitempty"`
+	TxHash     string       `json:"tx_hash,omitempty"`
+	Status     string       `json:"status,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// PayoutLegs is a slice of PayoutLeg stored as a JSON array
+type PayoutLegs []PayoutLeg
+
+// Scan implements sql.Scanner interface
+func (pl *PayoutLegs) Scan(value interface{}) error {
+	if value == nil {
+		*pl = PayoutLegs{}
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(bytes, pl)
+}
+
+// Value implements driver.Valuer interface
+func (pl PayoutLegs) Value() (driver.Value, error) {
+	if pl == nil {
+		return json.Marshal([]PayoutLeg{})
+	}
+	return json.Marshal(pl)
+}
+
+// Payout records the delivery of the output of an exchange. A BTC payout
+// spends the deposit to every output address at once, returning the service
+// fee to a change address. The signed transaction is stored before it is
+// broadcast so that it can be rebroadcast rather than paid twice. Payouts in
+// other currencies go through a payout rail, leg by leg.
+type Payout struct {
+	ID            uuid.UUID    `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TransactionID uuid.UUID    `json:"transaction_id" gorm:"type:uuid;not null;index"`
+	Currency      string       `json:"currency" gorm:"type:varchar(20);not null"`
+	Legs          PayoutLegs   `json:"legs" gorm:"type:jsonb;not null"`
+	Amount        money.Amount `json:"amount_units" gorm:"column:amount_units;type:numeric(78,0);not null;default:0"`
+	AmountSats    int64        `json:"amount_sats" gorm:"not null"`
+	FeeSats       int64        `json:"fee_sats" gorm:"not null"`
+	ChangeAddress string       `json:"change_address,omitempty" gorm:"type:varchar(100);not null;default:''"`
+	ChangeSats    int64        `json:"change_sats" gorm:"not null;default:0"`
+	TXID          string       `json:"txid" gorm:"type:varchar(100);not null;index"`
+	RawTx         string       `json:"-" gorm:"type:text;not null;default:''"`
+	PSBTID        *uuid.UUID   `json:"psbt_id,omitempty" gorm:"type:uuid;index"`
+	Status        string       `json:"status" gorm:"type:varchar(20);not null;index"`
+	Confirmations int          `json:"confirmations" gorm:"default:0"`
+	BlockHash     string       `json:"block_hash,omitempty" gorm:"type:varchar(64);not null;default:''"`
+	BlockHeight   int64        `json:"block_height,omitempty" gorm:"not null;default:0"`
+	BroadcastAt   *time.Time   `json:"broadcast_at,omitempty"`
+	ConfirmedAt   *time.Time   `json:"confirmed_at,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (p *Payout) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
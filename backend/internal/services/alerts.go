@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Alert kinds
+const (
+	// AlertPaymentReorged is raised when a payment's block leaves the active chain
+	AlertPaymentReorged = "payment_reorged"
+	// AlertQuoteSlippage is raised when a late payment cannot be paid out at
+	// the rate it was quoted
+	AlertQuoteSlippage = "quote_slippage"
+)
+
+// Alert is an event operators need to look at
+type Alert struct {
+	Kind          string
+	TransactionID uuid.UUID
+	Message       string
+	Fields        map[string]interface{}
+}
+
+// AlertNotifier delivers alerts to operators
+type AlertNotifier interface {
+	Notify(ctx context.Context, alert Alert)
+}
+
+// LogAlertNotifier delivers alerts as warning log entries
+type LogAlertNotifier struct{}
+
+// NewLogAlertNotifier creates a new log alert notifier
+func NewLogAlertNotifier() *LogAlertNotifier {
+	return &LogAlertNotifier{}
+}
+
+// Notify logs the alert with its fields
+func (n *LogAlertNotifier) Notify(ctx context.Context, alert Alert) {
+	fields := logrus.Fields{
+		"alert":          alert.Kind,
+		"transaction_id": alert.TransactionID,
+	}
+	for key, value := range alert.Fields {
+		fields[key] = value
+	}
+	logrus.WithFields(fields).Warn(alert.Message)
+}
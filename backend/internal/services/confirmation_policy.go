@@ -0,0 +1,77 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"hellomix-backend/pkg/money"
+)
+
+// ConfirmationTier requires a number of confirmations for payments of at
+// least MinAmountSats
+type ConfirmationTier struct {
+	MinAmountSats int64
+	Confirmations int
+}
+
+// ConfirmationPolicy decides how many confirmations a payment needs before
+// the exchange is processed, based on the amount at stake
+type ConfirmationPolicy struct {
+	tiers []ConfirmationTier
+}
+
+// NewConfirmationPolicy parses a comma separated list of "min_btc:confirmations"
+// tiers, e.g. "0:1,0.01:3,1:6"
+func NewConfirmationPolicy(spec string) (*ConfirmationPolicy, error) {
+	var tiers []ConfirmationTier
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.Split(part, ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid confirmation tier %q, expected min_btc:confirmations", part)
+		}
+
+		minAmount, err := money.ParseCurrency(strings.TrimSpace(fields[0]), "BTC")
+		minSats, ok := minAmount.Int64()
+		if err != nil || !ok || minSats < 0 {
+			return nil, fmt.Errorf("invalid minimum amount in confirmation tier %q", part)
+		}
+
+		confirmations, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil || confirmations < 1 {
+			return nil, fmt.Errorf("invalid confirmations in confirmation tier %q", part)
+		}
+
+		tiers = append(tiers, ConfirmationTier{
+			MinAmountSats: minSats,
+			Confirmations: confirmations,
+		})
+	}
+
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("at least one confirmation tier is required")
+	}
+
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].MinAmountSats < tiers[j].MinAmountSats
+	})
+
+	return &ConfirmationPolicy{tiers: tiers}, nil
+}
+
+// Required returns the number of confirmations needed for a payment of amountSats
+func (cp *ConfirmationPolicy) Required(amountSats int64) int {
+	required := cp.tiers[0].Confirmations
+	for _, tier := range cp.tiers {
+		if amountSats >= tier.MinAmountSats {
+			required = tier.Confirmations
+		}
+	}
+	return required
+}
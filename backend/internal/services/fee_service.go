@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/crypto"
+	"hellomix-backend/pkg/money"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/sirupsen/logrus"
+)
+
+// FeeOptions configures a FeeService
+type FeeOptions struct {
+	// AddressType is the deposit address type, which sets the size of the
+	// inputs spending deposits
+	AddressType crypto.AddressType
+	// TargetBlocks is the confirmation target fee rates are estimated for
+	TargetBlocks int
+	// FallbackRate is the fee rate in sat/vB used when the chain backend
+	// has no estimate
+	FallbackRate int64
+	// CacheTTL is how long a fee rate is reused, estimated or the fallback
+	CacheTTL time.Duration
+	// SpreadBps is the margin, in basis points, taken on the exchange rate
+	// of non-BTC outputs
+	SpreadBps int64
+}
+
+// FeeService estimates Bitcoin fee rates and quotes the fees of exchanges.
+// A quote charges the service fee, the spread and the projected miner fees
+// of sweeping the deposit and of sending each payout leg.
+type FeeService struct {
+	backend      crypto.ChainBackend
+	priceService *PriceService
+	rails        *PayoutRailRegistry
+	netParams    *chaincfg.Params
+	opts         FeeOptions
+
+	mu          sync.Mutex
+	feeRate     int64
+	estimatedAt time.Time
+	// estimating is closed when the estimate in flight, if any, is done
+	estimating chan struct{}
+}
+
+// NewFeeService creates a new fee service
+func NewFeeService(backend crypto.ChainBackend, priceService *PriceService, rails *PayoutRailRegistry, netParams *chaincfg.Params, opts FeeOptions) (*FeeService, error) {
+	if opts.FallbackRate <= 0 {
+		return nil, fmt.Errorf("fallback fee rate must be positive")
+	}
+	if opts.SpreadBps < 0 {
+		return nil, fmt.Errorf("spread must not be negative")
+	}
+	if opts.TargetBlocks < 1 {
+		opts.TargetBlocks = 6
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = time.Minute
+	}
+
+	return &FeeService{
+		backend:      backend,
+		priceService: priceService,
+		rails:        rails,
+		netParams:    netParams,
+		opts:         opts,
+	}, nil
+}
+
+// FeeRate returns the fee rate in sat/vB for the configured confirmation
+// target. Rates are cached; when the chain backend has no estimate the
+// fallback rate is used and cached in its place, so FeeRate never fails.
+// One caller at a time asks the backend, without holding the lock, while
+// concurrent callers wait for its answer.
+func (fs *FeeService) FeeRate(ctx context.Context) int64 {
+	fs.mu.Lock()
+	if fs.feeRate > 0 && time.Since(fs.estimatedAt) < fs.opts.CacheTTL {
+		feeRate := fs.feeRate
+		fs.mu.Unlock()
+		return feeRate
+	}
+	if estimating := fs.estimating; estimating != nil {
+		fs.mu.Unlock()
+		select {
+		case <-estimating:
+		case <-ctx.Done():
+		}
+		return fs.cachedFeeRate()
+	}
+	estimating := make(chan struct{})
+	fs.estimating = estimating
+	fs.mu.Unlock()
+
+	feeRate := fs.opts.FallbackRate
+	estimate, err := fs.backend.EstimateFeeRate(ctx, fs.opts.TargetBlocks)
+	if err != nil {
+		logrus.Warnf("Failed to estimate fee rate, using %d sat/vB: %v", fs.opts.FallbackRate, err)
+	} else {
+		feeRate = max(int64(math.Ceil(estimate)), 1)
+	}
+
+	fs.mu.Lock()
+	// A cancelled caller says nothing about the backend, so its fallback is
+	// not kept for others
+	if err == nil || ctx.Err() == nil {
+		fs.feeRate = feeRate
+		fs.estimatedAt = time.Now()
+	}
+	fs.estimating = nil
+	fs.mu.Unlock()
+	close(estimating)
+
+	return feeRate
+}
+
+// cachedFeeRate returns the last fee rate, or the fallback rate before the
+// first one
+func (fs *FeeService) cachedFeeRate() int64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.feeRate > 0 {
+		return fs.feeRate
+	}
+	return fs.opts.FallbackRate
+}
+
+// defaultServiceFeeBps is the service fee of currencies the registry does
+// not know
+const defaultServiceFeeBps = 50
+
+// serviceFeeBps is the share of the BTC amount, in basis points, kept as
+// the service fee of an output currency, as set in the currency registry
+func serviceFeeBps(currency string) int64 {
+	entry, err := money.LookupCurrency(currency)
+	if err != nil {
+		return defaultServiceFeeBps
+	}
+	return entry.FeeBps
+}
+
+// Quote itemizes the fees of exchanging btcAmountSats into currency for the
+// given output addresses
+func (fs *FeeService) Quote(ctx context.Context, btcAmountSats int64, currency string, outputs []models.OutputAddress) (*models.FeeBreakdown, error) {
+	feeRate := fs.FeeRate(ctx)
+	breakdown := &models.FeeBreakdown{
+		ServiceFeeSats: btcAmountSats * serviceFeeBps(currency) / 10000,
+		FeeRate:        feeRate,
+		PayoutFeesSats: make([]int64, len(outputs)),
+	}
+
+	// The deposit, or for BTC outputs the change left behind by the payout,
+	// is swept to cold storage later
+	breakdown.SweepFeeSats = crypto.EstimateSweepInputVSize(fs.opts.AddressType) * feeRate
+
+	if currency == "BTC" {
+		if err := fs.quoteBTCPayout(breakdown, outputs, feeRate); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := fs.quoteRailPayout(ctx, breakdown, btcAmountSats, currency, outputs); err != nil {
+			return nil, err
+		}
+		breakdown.SpreadSats = btcAmountSats * fs.opts.SpreadBps / 10000
+	}
+
+	breakdown.NetworkFeeSats = breakdown.SweepFeeSats + breakdown.PayoutFeeSats
+	breakdown.TotalSats = breakdown.ServiceFeeSats + breakdown.NetworkFeeSats + breakdown.SpreadSats
+	return breakdown, nil
+}
+
+// quoteBTCPayout projects the fee of the single payout transaction. The
+// recipients share it by percentage, as the payout deducts it from the
+// output before splitting.
+func (fs *FeeService) quoteBTCPayout(breakdown *models.FeeBreakdown, outputs []models.OutputAddress, feeRate int64) error {
+	recipients := make([]btcutil.Address, len(outputs))
+	for i, output := range outputs {
+		address, err := btcutil.DecodeAddress(output.Address, fs.netParams)
+		if err != nil {
+			return fmt.Errorf("invalid output address %s: %w", output.Address, err)
+		}
+		recipients[i] = address
+	}
+
+	vsize, err := crypto.EstimatePayoutVSize(fs.opts.AddressType, recipients)
+	if err != nil {
+		return fmt.Errorf("failed to estimate payout size: %w", err)
+	}
+	fee := vsize * feeRate
+
+	breakdown.PayoutFeeSats = fee
+	for i, output := range outputs {
+		breakdown.PayoutFeesSats[i] = int64(math.Ceil(float64(fee) * output.Percentage / 100))
+	}
+	return nil
+}
+
+// quoteRailPayout asks the payout rail for the fee of each leg and converts
+// it to satoshis
+func (fs *FeeService) quoteRailPayout(ctx context.Context, breakdown *models.FeeBreakdown, btcAmountSats int64, currency string, outputs []models.OutputAddress) error {
+	rail, ok := fs.rails.Get(currency)
+	if !ok {
+		return fmt.Errorf("no payout rail for %s", currency)
+	}
+
+	outputAmount, err := fs.priceService.ConvertAmount(ctx, "BTC", currency, money.NewAmount(btcAmountSats))
+	if err != nil {
+		return fmt.Errorf("failed to calculate exchange rate: %w", err)
+	}
+
+	for i, legAmount := range splitAmount(outputAmount, outputs) {
+		fee, err := rail.EstimateFee(ctx, PayoutRequest{
+			Currency: currency,
+			Address:  outputs[i].Address,
+			Memo:     outputs[i].Memo,
+			Amount:   legAmount,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to estimate %s payout fee: %w", currency, err)
+		}
+		if fee.IsZero() {
+			continue
+		}
+
+		feeBTC, err := fs.priceService.ConvertAmount(ctx, currency, "BTC", fee)
+		if err != nil {
+			return fmt.Errorf("failed to convert payout fee: %w", err)
+		}
+		feeSats, ok := feeBTC.Int64()
+		if !ok {
+			return fmt.Errorf("%s payout fee of %s is out of range", currency, fee)
+		}
+		breakdown.PayoutFeesSats[i] = feeSats
+		breakdown.PayoutFeeSats += feeSats
+	}
+	return nil
+}
+
+// splitAmount splits an amount across output addresses by percentage. The
+// rounding leftovers go to the last address, so the legs add up to amount.
+func splitAmount(amount money.Amount, outputs []models.OutputAddress) []money.Amount {
+	legs := make([]money.Amount, len(outputs))
+	allocated := money.Amount{}
+	for i, output := range outputs {
+		if i == len(outputs)-1 {
+			legs[i] = amount.Sub(allocated)
+		} else {
+			legs[i] = amount.MulDiv(int64(math.Round(output.Percentage*100)), 10000)
+		}
+		allocated = allocated.Add(legs[i])
+	}
+	return legs
+}
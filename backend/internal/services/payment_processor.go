@@ -2,200 +2,625 @@ package services
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"hellomix-backend/internal/models"
 	"hellomix-backend/pkg/crypto"
+	"hellomix-backend/pkg/money"
 
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // PaymentProcessor handles real Bitcoin payment processing
 type PaymentProcessor struct {
-	db             *gorm.DB
-	paymentMonitor *crypto.PaymentMonitor
-	priceService   *PriceService
-	testnet        bool
+	db                 *gorm.DB
+	paymentMonitor     *crypto.PaymentMonitor
+	priceService       *PriceService
+	payoutService      *PayoutService
+	confirmationPolicy *ConfirmationPolicy
+	toleranceBps       int64
+	alerts             AlertNotifier
 }
 
-// NewPaymentProcessor creates a new payment processor
-func NewPaymentProcessor(db *gorm.DB, priceService *PriceService, testnet bool) *PaymentProcessor {
+// NewPaymentProcessor creates a new payment processor on top of a chain backend.
+// Payments within toleranceBps basis points of the expected amount are accepted,
+// and BTC outputs are sent through payoutService.
+func NewPaymentProcessor(db *gorm.DB, priceService *PriceService, payoutService *PayoutService, backend crypto.ChainBackend, netParams *chaincfg.Params, confirmationPolicy *ConfirmationPolicy, toleranceBps int64, alerts AlertNotifier) *PaymentProcessor {
 	return &PaymentProcessor{
-		db:             db,
-		paymentMonitor: crypto.NewPaymentMonitor(testnet),
-		priceService:   priceService,
-		testnet:        testnet,
+		db:                 db,
+		paymentMonitor:     crypto.NewPaymentMonitor(backend, netParams),
+		priceService:       priceService,
+		payoutService:      payoutService,
+		confirmationPolicy: confirmationPolicy,
+		toleranceBps:       toleranceBps,
+		alerts:             alerts,
 	}
 }
 
-// ProcessTransaction processes a transaction with real Bitcoin monitoring
-func (pp *PaymentProcessor) ProcessTransaction(ctx context.Context, transactionID uuid.UUID) error {
-	// Get transaction from database
-	var transaction models.Transaction
-	if err := pp.db.WithContext(ctx).Where("id = ?", transactionID).First(&transaction).Error; err != nil {
-		return fmt.Errorf("failed to get transaction: %w", err)
+// PaymentWindow is how long a user has to send the full payment
+const PaymentWindow = 30 * time.Minute
+
+// FinalityDepth is the number of confirmations after which payments are no
+// longer re-verified against reorgs
+const FinalityDepth = 6
+
+// settledStatuses are final transaction statuses whose payments are still
+// re-verified until they reach FinalityDepth
+var settledStatuses = []string{
+	models.StatusCompleted,
+	models.StatusOverpaid,
+}
+
+// isSettled reports whether a transaction status is in settledStatuses
+func isSettled(status string) bool {
+	for _, settled := range settledStatuses {
+		if status == settled {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTransaction advances a transaction by one monitoring step: it looks at
+// the deposit address once and moves the transaction to its next status. All
+// state lives in the database, so a check can be resumed by any replica after
+// a restart. Confirmations are counted from tipHeight, and the payment report
+// is returned when the address was looked at.
+func (pp *PaymentProcessor) CheckTransaction(ctx context.Context, transaction *models.Transaction, tipHeight int64) (*PaymentReport, error) {
+	transactionID := transaction.ID
+
+	if transaction.Status == models.StatusPending {
+		logrus.Infof("Starting payment processing for transaction: %s", transactionID)
+
+		// Make sure the backend reports on the deposit address
+		if err := pp.paymentMonitor.WatchAddress(ctx, transaction.PaymentAddress); err != nil {
+			return nil, fmt.Errorf("failed to watch payment address: %w", err)
+		}
+
+		// Update status to waiting for payment
+		if err := pp.updateTransactionStatus(ctx, transactionID, models.StatusWaiting); err != nil {
+			return nil, err
+		}
+		transaction.Status = models.StatusWaiting
+	}
+
+	if transaction.Status == models.StatusProcessing || isSettled(transaction.Status) {
+		// The payment was already confirmed and the payout may have spent it,
+		// so only its stored payments are re-verified
+		return nil, pp.checkStoredPayments(ctx, transaction, tipHeight)
 	}
 
-	logrus.Infof("Starting payment processing for transaction: %s", transactionID)
+	expectedSats := transaction.BTCAmountSats
+	requiredConfirmations := pp.confirmationPolicy.Required(expectedSats)
 
-	// Update status to waiting for payment
-	if err := pp.updateTransactionStatus(ctx, transactionID, models.StatusWaiting); err != nil {
-		return fmt.Errorf("failed to update transaction status: %w", err)
+	paymentStatus, err := pp.paymentMonitor.CheckPaymentAt(ctx, transaction.PaymentAddress, expectedSats, tipHeight)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check payment: %w", err)
 	}
 
-	// Convert BTC amount to satoshis
-	expectedSats := crypto.BTCToSatoshis(transaction.BTCAmount)
+	// Payments whose block was orphaned no longer count as confirmed
+	reorged, err := pp.verifyPaymentBlocks(ctx, transactionID, paymentStatus)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify payment blocks: %w", err)
+	}
+	if len(reorged) > 0 {
+		if err := pp.handleReorg(ctx, transaction, reorged); err != nil {
+			return nil, err
+		}
+	}
 
-	// Monitor for payment (with timeout)
-	paymentCtx, cancel := context.WithTimeout(ctx, 30*time.Minute) // 30 minute timeout
-	defer cancel()
+	report := pp.Evaluate(paymentStatus)
 
-	ticker := time.NewTicker(30 * time.Second) // Check every 30 seconds
-	defer ticker.Stop()
+	logrus.Infof("Payment status for %s: %s, received: %d sats in %d outputs, expected: %d sats, confirmations: %d/%d",
+		transactionID, report.Outcome, paymentStatus.ReceivedAmount, len(paymentStatus.Outputs), expectedSats,
+		paymentStatus.Confirmations, requiredConfirmations)
 
-	var paymentStatus *crypto.PaymentStatus
-	var err error
+	// The full payment must arrive within the payment window, after that we
+	// keep monitoring until it has enough confirmations
+	expired := time.Now().After(paymentDeadline(transaction))
 
-	for {
-		select {
-		case <-paymentCtx.Done():
-			// Timeout reached, mark as expired
+	if report.Outcome == PaymentOutcomeWaiting {
+		if expired {
 			logrus.Warnf("Payment timeout for transaction: %s", transactionID)
-			pp.updateTransactionStatus(ctx, transactionID, models.StatusExpired)
-			return fmt.Errorf("payment timeout")
+			return report, pp.updateTransactionStatus(ctx, transactionID, models.StatusExpired)
+		}
+		return report, nil
+	}
+
+	// Keep every output's confirmation count up to date as blocks arrive
+	if err := pp.storePaymentInfo(ctx, transactionID, paymentStatus); err != nil {
+		logrus.Errorf("Failed to store payment info: %v", err)
+	}
+
+	switch report.Outcome {
+	case PaymentOutcomeUnderpaid:
+		if expired {
+			// Stop watching, the recorded payments are left for manual refund
+			logrus.Warnf("Payment timeout for underpaid transaction %s, short by %d sats", transactionID, report.ShortfallSats)
+			return report, pp.updateTransactionStatus(ctx, transactionID, models.StatusExpired)
+		}
+		// Wait for the user to top up the payment
+		if transaction.Status != models.StatusUnderpaid {
+			return report, pp.updateTransactionStatus(ctx, transactionID, models.StatusUnderpaid)
+		}
+		return report, nil
+
+	case PaymentOutcomeOverpaid:
+		// Hold the transaction until the surplus has been reviewed
+		logrus.Warnf("Transaction %s overpaid by %d sats", transactionID, report.SurplusSats)
+		return report, pp.updateTransactionStatus(ctx, transactionID, models.StatusOverpaid)
+	}
+
+	if transaction.Status != models.StatusWaiting {
+		if err := pp.updateTransactionStatus(ctx, transactionID, models.StatusWaiting); err != nil {
+			return report, err
+		}
+		transaction.Status = models.StatusWaiting
+	}
+
+	if paymentStatus.Status != "confirmed" || paymentStatus.Confirmations < requiredConfirmations {
+		// Payment received but not confirmed deeply enough yet
+		return report, nil
+	}
+
+	// Payment confirmed, process the exchange
+	logrus.Infof("Payment confirmed for transaction: %s", transactionID)
+	if err := pp.updateTransactionStatus(ctx, transactionID, models.StatusProcessing); err != nil {
+		return report, err
+	}
+	transaction.Status = models.StatusProcessing
+
+	return report, pp.completeExchange(ctx, transaction)
+}
+
+// checkStoredPayments re-verifies the payments of a transaction whose payout
+// is under way or done. The payout spends the deposit, and backends that only
+// report unspent outputs no longer see it at the address, so the payments are
+// looked up by txid instead. Processing transactions then continue their
+// payout; settled ones are finalized once all their payments are final.
+func (pp *PaymentProcessor) checkStoredPayments(ctx context.Context, transaction *models.Transaction, tipHeight int64) error {
+	reorged, confirmations, err := pp.verifyStoredPayments(ctx, transaction.ID, tipHeight)
+	if err != nil {
+		return fmt.Errorf("failed to verify payments: %w", err)
+	}
+	if len(reorged) > 0 {
+		if err := pp.handleReorg(ctx, transaction, reorged); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case transaction.Status == models.StatusProcessing:
+		return pp.completeExchange(ctx, transaction)
+	case !isSettled(transaction.Status):
+		// Reverted by the reorg, the payment is waited for again
+		return nil
+	case confirmations >= FinalityDepth:
+		return pp.finalizeTransaction(ctx, transaction.ID)
+	case confirmations < 0 && transaction.PaidOutAt != nil:
+		// Every payment is gone after the payout, which has been alerted
+		// on and cannot be undone, so there is nothing left to verify
+		return pp.finalizeTransaction(ctx, transaction.ID)
+	}
+	return nil
+}
 
-		case <-ticker.C:
-			// Check for payment
-			paymentStatus, err = pp.paymentMonitor.MonitorPayment(paymentCtx, transaction.PaymentAddress, expectedSats)
+// verifyStoredPayments looks up the transactions of the stored payments of a
+// transaction and updates their blocks and confirmations. Payments that were
+// dropped or moved by a reorg are returned, along with the fewest
+// confirmations of the payments left, or -1 when none are left.
+func (pp *PaymentProcessor) verifyStoredPayments(ctx context.Context, transactionID uuid.UUID, tipHeight int64) ([]models.Payment, int, error) {
+	var payments []models.Payment
+	if err := pp.db.WithContext(ctx).
+		Where("transaction_id = ? AND status <> ?", transactionID, "dropped").
+		Find(&payments).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get payments: %w", err)
+	}
+
+	var reorged []models.Payment
+	confirmations := -1
+	for _, payment := range payments {
+		status, blockHash, blockHeight := "unconfirmed", "", int64(0)
+
+		tx, err := pp.paymentMonitor.GetTransaction(ctx, payment.TXID)
+		switch {
+		case errors.Is(err, crypto.ErrNotFound):
+			// The transaction is gone, e.g. double spent while back in the mempool
+			status = "dropped"
+		case err != nil:
+			return nil, 0, fmt.Errorf("failed to get payment transaction: %w", err)
+		case tx.Status.Confirmed:
+			// The backend may not have caught up with a reorg yet
+			inChain, err := pp.paymentMonitor.IsInActiveChain(ctx, tx.Status.BlockHash, tx.Status.BlockHeight)
 			if err != nil {
-				logrus.Errorf("Failed to check payment for transaction %s: %v", transactionID, err)
-				continue
+				return nil, 0, err
+			}
+			if inChain {
+				status, blockHash, blockHeight = "confirmed", tx.Status.BlockHash, tx.Status.BlockHeight
 			}
+		}
+
+		if payment.BlockHash != "" && blockHash != payment.BlockHash {
+			reorged = append(reorged, payment)
+		}
+		paymentConfirmations := crypto.Confirmations(tipHeight, blockHeight)
+		if status != "dropped" && (confirmations < 0 || paymentConfirmations < confirmations) {
+			confirmations = paymentConfirmations
+		}
+
+		if err := pp.db.WithContext(ctx).Model(&models.Payment{}).
+			Where("id = ?", payment.ID).
+			Updates(map[string]interface{}{
+				"confirmations": paymentConfirmations,
+				"block_hash":    blockHash,
+				"block_height":  blockHeight,
+				"status":        status,
+			}).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to update payment %s:%d: %w", payment.TXID, payment.Vout, err)
+		}
+	}
 
-			logrus.Infof("Payment status for %s: %s, received: %d sats, expected: %d sats", 
-				transactionID, paymentStatus.Status, paymentStatus.TotalReceived, expectedSats)
-
-			switch paymentStatus.Status {
-			case "confirmed":
-				// Payment confirmed, process the exchange
-				logrus.Infof("Payment confirmed for transaction: %s", transactionID)
-				if err := pp.updateTransactionStatus(ctx, transactionID, models.StatusProcessing); err != nil {
-					logrus.Errorf("Failed to update status to processing: %v", err)
-				}
-
-				// Store payment information
-				if err := pp.storePaymentInfo(ctx, transactionID, paymentStatus); err != nil {
-					logrus.Errorf("Failed to store payment info: %v", err)
-				}
-
-				// Process the actual exchange
-				if err := pp.processExchange(ctx, transactionID, &transaction); err != nil {
-					logrus.Errorf("Failed to process exchange: %v", err)
-					pp.updateTransactionStatus(ctx, transactionID, models.StatusFailed)
-					return err
-				}
-
-				// Mark as completed
-				if err := pp.updateTransactionStatus(ctx, transactionID, models.StatusCompleted); err != nil {
-					logrus.Errorf("Failed to update status to completed: %v", err)
-				}
-
-				logrus.Infof("Transaction completed successfully: %s", transactionID)
-				return nil
-
-			case "unconfirmed":
-				// Payment received but not confirmed yet
-				if transaction.Status != models.StatusProcessing {
-					logrus.Infof("Unconfirmed payment received for transaction: %s", transactionID)
-					if err := pp.updateTransactionStatus(ctx, transactionID, models.StatusProcessing); err != nil {
-						logrus.Errorf("Failed to update status to processing: %v", err)
-					}
-				}
-				// Continue monitoring for confirmation
-
-			case "pending":
-				// Still waiting for payment
-				continue
+	return reorged, confirmations, nil
+}
+
+// verifyPaymentBlocks re-verifies the blocks of stored payments against the
+// active chain. Payments that were dropped or moved by a reorg are returned,
+// and outputs still reported in an orphaned block are demoted to unconfirmed.
+func (pp *PaymentProcessor) verifyPaymentBlocks(ctx context.Context, transactionID uuid.UUID, paymentStatus *crypto.PaymentStatus) ([]models.Payment, error) {
+	var payments []models.Payment
+	if err := pp.db.WithContext(ctx).
+		Where("transaction_id = ? AND block_hash <> ''", transactionID).
+		Find(&payments).Error; err != nil {
+		return nil, fmt.Errorf("failed to get payments: %w", err)
+	}
+
+	observed := make(map[string]*crypto.ReceivedOutput, len(paymentStatus.Outputs))
+	for i := range paymentStatus.Outputs {
+		output := &paymentStatus.Outputs[i]
+		observed[fmt.Sprintf("%s:%d", output.TXID, output.Vout)] = output
+	}
+
+	var reorged []models.Payment
+	demoted := false
+	for _, payment := range payments {
+		inChain, err := pp.paymentMonitor.IsInActiveChain(ctx, payment.BlockHash, payment.BlockHeight)
+		if err != nil {
+			return nil, err
+		}
+
+		output, seen := observed[fmt.Sprintf("%s:%d", payment.TXID, payment.Vout)]
+		if inChain && seen && output.BlockHash == payment.BlockHash {
+			continue
+		}
+		reorged = append(reorged, payment)
+
+		if !seen {
+			// The transaction is gone, e.g. double spent while back in the mempool
+			err := pp.db.WithContext(ctx).Model(&models.Payment{}).
+				Where("id = ?", payment.ID).
+				Updates(map[string]interface{}{
+					"confirmations": 0,
+					"block_hash":    "",
+					"block_height":  0,
+					"status":        "dropped",
+				}).Error
+			if err != nil {
+				return nil, fmt.Errorf("failed to mark payment %s:%d dropped: %w", payment.TXID, payment.Vout, err)
 			}
+			continue
+		}
+
+		if !inChain && output.BlockHash == payment.BlockHash {
+			// The backend has not caught up with the reorg yet
+			output.Confirmations = 0
+			output.BlockHash = ""
+			output.BlockHeight = 0
+			demoted = true
 		}
 	}
+
+	if demoted {
+		paymentStatus.Summarize()
+	}
+	return reorged, nil
 }
 
-// processExchange processes the actual cryptocurrency exchange
-func (pp *PaymentProcessor) processExchange(ctx context.Context, transactionID uuid.UUID, transaction *models.Transaction) error {
-	logrus.Infof("Processing exchange for transaction: %s", transactionID)
+// handleReorg raises an alert for each reorganized payment and, unless the
+// transaction has already been paid out, reverts it to waiting so the
+// payment has to confirm again. The payout of a processing transaction is
+// cancelled first; one that can no longer be cancelled is left to finish.
+func (pp *PaymentProcessor) handleReorg(ctx context.Context, transaction *models.Transaction, reorged []models.Payment) error {
+	for _, payment := range reorged {
+		pp.alerts.Notify(ctx, Alert{
+			Kind:          AlertPaymentReorged,
+			TransactionID: transaction.ID,
+			Message: fmt.Sprintf("Payment %s:%d left block %s at height %d",
+				payment.TXID, payment.Vout, payment.BlockHash, payment.BlockHeight),
+			Fields: map[string]interface{}{
+				"txid":               payment.TXID,
+				"vout":               payment.Vout,
+				"amount_sats":        payment.AmountSats,
+				"block_hash":         payment.BlockHash,
+				"block_height":       payment.BlockHeight,
+				"transaction_status": transaction.Status,
+				"paid_out":           transaction.PaidOutAt != nil,
+			},
+		})
+	}
 
-	// In a real implementation, this would:
-	// 1. Calculate the exact output amounts based on current prices
-	// 2. Deduct fees
-	// 3. Send cryptocurrencies to the destination addresses
-	// 4. Record all transactions on respective blockchains
+	if transaction.PaidOutAt != nil {
+		// The payout cannot be taken back, the alert is all we can do
+		logrus.Errorf("Transaction %s was paid out from payments that were reorganized", transaction.ID)
+		return nil
+	}
 
-	// For now, we'll simulate the process with proper calculations
-	outputAmount, err := pp.calculateFinalOutput(ctx, transaction)
-	if err != nil {
-		return fmt.Errorf("failed to calculate final output: %w", err)
+	switch transaction.Status {
+	case models.StatusProcessing:
+		halted, err := pp.payoutService.Halt(ctx, transaction.ID)
+		if err != nil {
+			return fmt.Errorf("failed to halt payout: %w", err)
+		}
+		if !halted {
+			logrus.Errorf("Payout of transaction %s is already signed, it proceeds although its payment was reorganized", transaction.ID)
+			return nil
+		}
+		fallthrough
+	case models.StatusOverpaid:
+		logrus.Warnf("Reverting transaction %s from %s to %s after a reorg", transaction.ID, transaction.Status, models.StatusWaiting)
+		if err := pp.updateTransactionStatus(ctx, transaction.ID, models.StatusWaiting); err != nil {
+			return err
+		}
+		transaction.Status = models.StatusWaiting
 	}
 
-	// Store the final output amount
+	return nil
+}
+
+// finalizeTransaction stops reorg verification of a settled transaction
+func (pp *PaymentProcessor) finalizeTransaction(ctx context.Context, transactionID uuid.UUID) error {
 	if err := pp.db.WithContext(ctx).Model(&models.Transaction{}).
 		Where("id = ?", transactionID).
-		Update("final_output", outputAmount).Error; err != nil {
-		logrus.Errorf("Failed to store final output: %v", err)
+		Update("finalized_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to finalize transaction: %w", err)
+	}
+
+	logrus.Infof("Payments of transaction %s are final", transactionID)
+	return nil
+}
+
+// completeExchange pays out the exchange of a confirmed payment and marks
+// the transaction completed once the payout has confirmed. Until then the
+// transaction stays processing and is checked again on every poll; only
+// payouts that cannot succeed fail it.
+func (pp *PaymentProcessor) completeExchange(ctx context.Context, transaction *models.Transaction) error {
+	done, err := pp.processExchange(ctx, transaction.ID, transaction)
+	if err != nil {
+		if errors.Is(err, ErrPayoutFailed) {
+			logrus.Errorf("Failed to process exchange: %v", err)
+			pp.updateTransactionStatus(ctx, transaction.ID, models.StatusFailed)
+		}
+		return err
+	}
+	if !done {
+		return nil
 	}
 
-	// In a production system, here you would:
-	// 1. Send the calculated amounts to each output address
-	// 2. Record the transaction hashes
-	// 3. Monitor for confirmations
-	
-	logrus.Infof("Exchange processed: %f %s sent to %d addresses", 
-		outputAmount, transaction.OutputCurrency, len(transaction.OutputAddresses))
+	// Mark as completed
+	if err := pp.updateTransactionStatus(ctx, transaction.ID, models.StatusCompleted); err != nil {
+		return err
+	}
 
+	logrus.Infof("Transaction completed successfully: %s", transaction.ID)
 	return nil
 }
 
-// calculateFinalOutput calculates the final output amount after fees and current rates
-func (pp *PaymentProcessor) calculateFinalOutput(ctx context.Context, transaction *models.Transaction) (float64, error) {
-	// Get current exchange rate
-	outputAmount, err := pp.priceService.CalculateExchangeRate(ctx, "BTC", transaction.OutputCurrency, transaction.BTCAmount)
+// TipHeight gets the height of the best block from the chain backend
+func (pp *PaymentProcessor) TipHeight(ctx context.Context) (int64, error) {
+	return pp.paymentMonitor.TipHeight(ctx)
+}
+
+// PaymentMonitor returns the monitor used to detect payments
+func (pp *PaymentProcessor) PaymentMonitor() *crypto.PaymentMonitor {
+	return pp.paymentMonitor
+}
+
+// paymentDeadline returns when the payment window of a transaction closes
+func paymentDeadline(transaction *models.Transaction) time.Time {
+	if transaction.ExpiresAt != nil {
+		return *transaction.ExpiresAt
+	}
+	return transaction.CreatedAt.Add(PaymentWindow)
+}
+
+// processExchange sends the output of an exchange and reports whether it
+// has been delivered. BTC outputs are paid in a single transaction spending
+// the deposit, other currencies through their payout rail, one leg per
+// output address. Either is delivered once every transaction has confirmed.
+func (pp *PaymentProcessor) processExchange(ctx context.Context, transactionID uuid.UUID, transaction *models.Transaction) (bool, error) {
+	payout, err := pp.payoutService.Current(ctx, transactionID)
 	if err != nil {
-		return 0, fmt.Errorf("failed to calculate exchange rate: %w", err)
+		return false, err
+	}
+
+	if payout == nil {
+		logrus.Infof("Processing exchange for transaction: %s", transactionID)
+
+		outputAmount, err := pp.calculateFinalOutput(ctx, transaction)
+		if err != nil {
+			return false, fmt.Errorf("failed to calculate final output: %w", err)
+		}
+
+		if transaction.OutputCurrency == "BTC" {
+			outputSats, ok := outputAmount.Int64()
+			if !ok {
+				return false, fmt.Errorf("%w: output of %s sats is out of range", ErrPayoutFailed, outputAmount)
+			}
+			payout, err = pp.payoutService.SendBTC(ctx, transaction, outputSats)
+		} else {
+			payout, err = pp.payoutService.SendRail(ctx, transaction, outputAmount)
+		}
+		if err != nil {
+			return false, err
+		}
+
+		// The final output is what the recipients receive, for BTC after the network fee
+		if err := pp.storeFinalOutput(ctx, transactionID, payout.Amount); err != nil {
+			logrus.Errorf("Failed to store final output: %v", err)
+		}
+	}
+
+	return pp.payoutService.CheckPayout(ctx, payout)
+}
+
+// CanPayOut reports whether exchange outputs in a currency can be delivered
+func (pp *PaymentProcessor) CanPayOut(currency string) bool {
+	return pp.payoutService.Supports(currency)
+}
+
+// storeFinalOutput records the amount delivered by an exchange
+func (pp *PaymentProcessor) storeFinalOutput(ctx context.Context, transactionID uuid.UUID, outputAmount money.Amount) error {
+	if err := pp.db.WithContext(ctx).Model(&models.Transaction{}).
+		Where("id = ?", transactionID).
+		Update("final_output_units", outputAmount).Error; err != nil {
+		return fmt.Errorf("failed to store final output: %w", err)
+	}
+	return nil
+}
+
+// calculateFinalOutput calculates the final output amount after the quoted
+// fees at the current rate, or at the locked rate of a quoted transaction.
+// A BTC payout deducts its own network fee when
+// it is built, so only the other fees are taken here.
+func (pp *PaymentProcessor) calculateFinalOutput(ctx context.Context, transaction *models.Transaction) (money.Amount, error) {
+	feeBreakdown := transaction.FeeBreakdown
+	if feeBreakdown == nil {
+		return pp.calculateLegacyFinalOutput(ctx, transaction)
 	}
 
-	// Calculate and deduct fees
-	feeRate := 0.005 // 0.5%
 	if transaction.OutputCurrency == "BTC" {
-		feeRate = 0.002 // 0.2% for BTC
+		return money.NewAmount(transaction.BTCAmountSats - feeBreakdown.ServiceFeeSats - feeBreakdown.SweepFeeSats - feeBreakdown.SpreadSats), nil
+	}
+
+	netAmount := money.NewAmount(transaction.BTCAmountSats - feeBreakdown.TotalSats)
+	if transaction.QuoteID != nil {
+		return pp.calculateQuotedFinalOutput(ctx, transaction, netAmount)
+	}
+
+	outputAmount, err := pp.priceService.ConvertAmount(ctx, "BTC", transaction.OutputCurrency, netAmount)
+	if err != nil {
+		return money.Amount{}, fmt.Errorf("failed to calculate exchange rate: %w", err)
+	}
+	return outputAmount, nil
+}
+
+// calculateQuotedFinalOutput honors the locked rate of a quoted transaction,
+// unless its payment arrived after the quote expired and netAmount has to be
+// converted at the current rate. Payments repriced beyond the quote's
+// slippage allowance are not paid out but raised to operators.
+func (pp *PaymentProcessor) calculateQuotedFinalOutput(ctx context.Context, transaction *models.Transaction, netAmount money.Amount) (money.Amount, error) {
+	var quote models.Quote
+	if err := pp.db.WithContext(ctx).Where("id = ?", *transaction.QuoteID).First(&quote).Error; err != nil {
+		return money.Amount{}, fmt.Errorf("failed to get quote: %w", err)
+	}
+
+	// The payment arrived with the last of its outputs
+	var paidAt sql.NullTime
+	if err := pp.db.WithContext(ctx).Model(&models.Payment{}).
+		Select("max(detected_at)").
+		Where("transaction_id = ? AND status <> ?", transaction.ID, "dropped").
+		Scan(&paidAt).Error; err != nil {
+		return money.Amount{}, fmt.Errorf("failed to get payment time: %w", err)
+	}
+	if !paidAt.Valid {
+		return money.Amount{}, fmt.Errorf("no payment recorded for transaction %s", transaction.ID)
+	}
+
+	if !paidAt.Time.After(quote.ExpiresAt) {
+		return quote.OutputAmount, nil
 	}
 
-	finalAmount := outputAmount * (1 - feeRate)
+	current, err := pp.priceService.ConvertAmount(ctx, "BTC", transaction.OutputCurrency, netAmount)
+	if err != nil {
+		return money.Amount{}, fmt.Errorf("failed to calculate exchange rate: %w", err)
+	}
+
+	outputAmount, err := QuotedOutput(&quote, paidAt.Time, current)
+	if errors.Is(err, ErrQuoteSlippage) {
+		pp.alerts.Notify(ctx, Alert{
+			Kind:          AlertQuoteSlippage,
+			TransactionID: transaction.ID,
+			Message:       fmt.Sprintf("Payment arrived after quote %s expired and the rate moved beyond its slippage allowance", quote.ID),
+			Fields: map[string]interface{}{
+				"quote_id":       quote.ID,
+				"expires_at":     quote.ExpiresAt,
+				"paid_at":        paidAt.Time,
+				"quoted_output":  quote.OutputAmount.String(),
+				"current_output": current.String(),
+				"slippage_bps":   quote.SlippageBps,
+			},
+		})
+		return money.Amount{}, fmt.Errorf("%w: %v", ErrPayoutFailed, err)
+	}
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	logrus.Warnf("Payment for transaction %s arrived after quote %s expired, repriced to %s", transaction.ID, quote.ID, outputAmount)
+	return outputAmount, nil
+}
+
+// calculateLegacyFinalOutput applies the flat fee of transactions created
+// before fees were quoted
+func (pp *PaymentProcessor) calculateLegacyFinalOutput(ctx context.Context, transaction *models.Transaction) (money.Amount, error) {
+	// Get current exchange rate
+	outputAmount, err := pp.priceService.ConvertAmount(ctx, "BTC", transaction.OutputCurrency, money.NewAmount(transaction.BTCAmountSats))
+	if err != nil {
+		return money.Amount{}, fmt.Errorf("failed to calculate exchange rate: %w", err)
+	}
+
+	finalAmount := outputAmount.MulDiv(10000-serviceFeeBps(transaction.OutputCurrency), 10000)
 	return finalAmount, nil
 }
 
-// storePaymentInfo stores payment information in the database
+// storePaymentInfo upserts one payment record per received output, keeping
+// confirmation counts up to date as new blocks arrive
 func (pp *PaymentProcessor) storePaymentInfo(ctx context.Context, transactionID uuid.UUID, paymentStatus *crypto.PaymentStatus) error {
-	// Create a payment record
-	payment := models.Payment{
-		ID:            uuid.New(),
-		TransactionID: transactionID,
-		Address:       paymentStatus.Address,
-		AmountSats:    paymentStatus.TotalReceived,
-		AmountBTC:     crypto.SatoshisToBTC(paymentStatus.TotalReceived),
-		TXID:          paymentStatus.PaymentTXID,
-		Confirmations: paymentStatus.Confirmations,
-		Status:        paymentStatus.Status,
-		DetectedAt:    time.Now(),
-	}
+	for _, output := range paymentStatus.Outputs {
+		status := "unconfirmed"
+		if output.Confirmations > 0 {
+			status = "confirmed"
+		}
 
-	if err := pp.db.WithContext(ctx).Create(&payment).Error; err != nil {
-		return fmt.Errorf("failed to create payment record: %w", err)
+		payment := models.Payment{
+			ID:            uuid.New(),
+			TransactionID: transactionID,
+			Address:       paymentStatus.Address,
+			AmountSats:    output.Value,
+			TXID:          output.TXID,
+			Vout:          output.Vout,
+			Confirmations: output.Confirmations,
+			BlockHash:     output.BlockHash,
+			BlockHeight:   output.BlockHeight,
+			Status:        status,
+			DetectedAt:    time.Now(),
+		}
+
+		err := pp.db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "txid"}, {Name: "vout"}},
+			DoUpdates: clause.AssignmentColumns([]string{"confirmations", "block_hash", "block_height", "status", "updated_at"}),
+		}).Create(&payment).Error
+		if err != nil {
+			return fmt.Errorf("failed to store payment %s:%d: %w", output.TXID, output.Vout, err)
+		}
 	}
 
-	logrus.Infof("Stored payment info for transaction %s: %s", transactionID, paymentStatus.PaymentTXID)
 	return nil
 }
 
@@ -220,18 +645,15 @@ func (pp *PaymentProcessor) updateTransactionStatus(ctx context.Context, transac
 	return nil
 }
 
-// StartPaymentMonitoring starts monitoring for a transaction
-func (pp *PaymentProcessor) StartPaymentMonitoring(transactionID uuid.UUID) {
-	go func() {
-		ctx := context.Background()
-		if err := pp.ProcessTransaction(ctx, transactionID); err != nil {
-			logrus.Errorf("Payment processing failed for transaction %s: %v", transactionID, err)
-		}
-	}()
+// Evaluate explains a payment status against the processor's tolerance and
+// the confirmations its expected amount requires
+func (pp *PaymentProcessor) Evaluate(paymentStatus *crypto.PaymentStatus) *PaymentReport {
+	return evaluatePayment(paymentStatus, pp.toleranceBps, pp.confirmationPolicy.Required(paymentStatus.ExpectedAmount))
 }
 
-// GetPaymentStatus gets the current payment status for a transaction
-func (pp *PaymentProcessor) GetPaymentStatus(ctx context.Context, transactionID uuid.UUID) (*crypto.PaymentStatus, error) {
+// GetPaymentStatus gets the current payment status for a transaction,
+// explaining any shortfall or surplus
+func (pp *PaymentProcessor) GetPaymentStatus(ctx context.Context, transactionID uuid.UUID) (*PaymentReport, error) {
 	// Get transaction from database
 	var transaction models.Transaction
 	if err := pp.db.WithContext(ctx).Where("id = ?", transactionID).First(&transaction).Error; err != nil {
@@ -239,6 +661,13 @@ func (pp *PaymentProcessor) GetPaymentStatus(ctx context.Context, transactionID
 	}
 
 	// Check current payment status
-	expectedSats := crypto.BTCToSatoshis(transaction.BTCAmount)
-	return pp.paymentMonitor.MonitorPayment(ctx, transaction.PaymentAddress, expectedSats)
+	expectedSats := transaction.BTCAmountSats
+	paymentStatus, err := pp.paymentMonitor.MonitorPayment(ctx, transaction.PaymentAddress, expectedSats)
+	if err != nil {
+		return nil, err
+	}
+
+	report := pp.Evaluate(paymentStatus)
+	report.TransactionStatus = transaction.Status
+	return report, nil
 }
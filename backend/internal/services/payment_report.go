@@ -0,0 +1,66 @@
+package services
+
+import (
+	"fmt"
+
+	"hellomix-backend/pkg/crypto"
+)
+
+// Payment outcomes, comparing what was received with what was expected
+const (
+	PaymentOutcomeWaiting   = "waiting"
+	PaymentOutcomeUnderpaid = "underpaid"
+	PaymentOutcomePaid      = "paid"
+	PaymentOutcomeOverpaid  = "overpaid"
+)
+
+// PaymentReport is the payment status of a transaction, explaining any
+// shortfall or surplus against the expected amount
+type PaymentReport struct {
+	*crypto.PaymentStatus
+	TransactionStatus     string `json:"transaction_status"`
+	Outcome               string `json:"outcome"`
+	RequiredConfirmations int    `json:"required_confirmations"`
+	ShortfallSats         int64  `json:"shortfall_sats,omitempty"`
+	SurplusSats           int64  `json:"surplus_sats,omitempty"`
+	Message               string `json:"message"`
+}
+
+// evaluatePayment compares the received amount with the expected amount,
+// accepting deviations up to toleranceBps basis points either way
+func evaluatePayment(status *crypto.PaymentStatus, toleranceBps int64, requiredConfirmations int) *PaymentReport {
+	report := &PaymentReport{
+		PaymentStatus:         status,
+		RequiredConfirmations: requiredConfirmations,
+	}
+
+	expected := status.ExpectedAmount
+	received := status.ReceivedAmount
+	tolerance := expected * toleranceBps / 10000
+
+	switch {
+	case len(status.Outputs) == 0:
+		report.Outcome = PaymentOutcomeWaiting
+		report.Message = fmt.Sprintf("Waiting for a payment of %.8f BTC", crypto.SatoshisToBTC(expected))
+	case received < expected-tolerance:
+		report.Outcome = PaymentOutcomeUnderpaid
+		report.ShortfallSats = expected - received
+		report.Message = fmt.Sprintf("Received %.8f BTC, which is %.8f BTC short of the expected %.8f BTC. Send the remaining amount to the same address.",
+			crypto.SatoshisToBTC(received), crypto.SatoshisToBTC(report.ShortfallSats), crypto.SatoshisToBTC(expected))
+	case received > expected+tolerance:
+		report.Outcome = PaymentOutcomeOverpaid
+		report.SurplusSats = received - expected
+		report.Message = fmt.Sprintf("Received %.8f BTC, which is %.8f BTC more than the expected %.8f BTC. The transaction is on hold while the surplus is reviewed for refund.",
+			crypto.SatoshisToBTC(received), crypto.SatoshisToBTC(report.SurplusSats), crypto.SatoshisToBTC(expected))
+	default:
+		report.Outcome = PaymentOutcomePaid
+		if status.Confirmations >= requiredConfirmations {
+			report.Message = "Payment received and confirmed"
+		} else {
+			report.Message = fmt.Sprintf("Payment received, waiting for confirmations (%d/%d)",
+				status.Confirmations, requiredConfirmations)
+		}
+	}
+
+	return report
+}
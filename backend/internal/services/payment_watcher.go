@@ -0,0 +1,453 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/crypto"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// watchedStatuses are the transaction statuses that still need monitoring
+var watchedStatuses = []string{
+	models.StatusPending,
+	models.StatusWaiting,
+	models.StatusProcessing,
+	models.StatusUnderpaid,
+}
+
+// monitoredCondition selects open transactions, and settled ones whose
+// payments are not yet final and may still be reorganized. Its columns are
+// qualified so that it also applies to queries joining other tables.
+const monitoredCondition = "(transactions.status IN ? OR (transactions.status IN ? AND transactions.finalized_at IS NULL))"
+
+const (
+	// paymentWatcherBatchSize is the maximum number of transactions claimed per poll
+	paymentWatcherBatchSize = 50
+	// maxPollBackoff caps how long polling pauses while the backend throttles us
+	maxPollBackoff = 10 * time.Minute
+	// notifierRetryDelay is the pause before resubscribing to a failed notifier
+	notifierRetryDelay = 5 * time.Second
+	// defaultLeaseDuration is the lease duration when none is configured
+	defaultLeaseDuration = 2 * time.Minute
+)
+
+// PaymentWatcherOptions configures a PaymentWatcher
+type PaymentWatcherOptions struct {
+	// PollInterval is how often an address without recent activity is checked
+	PollInterval time.Duration
+	// FastPollInterval is how often an address with unconfirmed payments is
+	// checked, and how often the watcher looks for due transactions
+	FastPollInterval time.Duration
+	// LeaseDuration is how long a claimed transaction is reserved for this
+	// replica. Leases are renewed while a batch is being checked, so it only
+	// needs to outlast a renewal interval of a third of it.
+	LeaseDuration time.Duration
+	// Concurrency is the number of transactions checked in parallel
+	Concurrency int
+	// Notifier enables push mode when set: transactions paying a watched
+	// address and new blocks trigger checks right away, with polling kept
+	// as a safety net for missed notifications
+	Notifier crypto.ChainNotifier
+}
+
+// PaymentWatcher drives open transactions through the payment processor.
+// The work queue is the transactions table itself, so monitoring resumes
+// after a restart. Each replica leases the rows it is checking, letting
+// several replicas share the work without processing a transaction twice.
+//
+// A single scheduler claims the transactions that are due, checks them with
+// a bounded number of workers and backs off when the chain backend answers
+// with 429 or 5xx responses. In push mode chain notifications make the
+// affected transactions due immediately.
+type PaymentWatcher struct {
+	db        *gorm.DB
+	processor *PaymentProcessor
+	owner     string
+	opts      PaymentWatcherOptions
+	wake      chan struct{}
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	// backoff is the current pause between polls while throttled
+	backoff time.Duration
+}
+
+// NewPaymentWatcher creates a new payment watcher
+func NewPaymentWatcher(db *gorm.DB, processor *PaymentProcessor, opts PaymentWatcherOptions) *PaymentWatcher {
+	if opts.FastPollInterval <= 0 || opts.FastPollInterval > opts.PollInterval {
+		opts.FastPollInterval = opts.PollInterval
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.LeaseDuration <= 0 {
+		opts.LeaseDuration = defaultLeaseDuration
+	}
+
+	hostname, _ := os.Hostname()
+	return &PaymentWatcher{
+		db:        db,
+		processor: processor,
+		owner:     fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8]),
+		opts:      opts,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Start starts polling for open transactions in the background
+func (pw *PaymentWatcher) Start(ctx context.Context) {
+	ctx, pw.cancel = context.WithCancel(ctx)
+
+	pw.wg.Add(1)
+	go func() {
+		defer pw.wg.Done()
+		pw.run(ctx)
+	}()
+
+	if pw.opts.Notifier != nil {
+		pw.wg.Add(1)
+		go func() {
+			defer pw.wg.Done()
+			pw.listen(ctx)
+		}()
+	}
+
+	logrus.Infof("Payment watcher %s started", pw.owner)
+}
+
+// Stop stops polling, waits for in-flight checks and releases held leases
+// so other replicas can take over immediately
+func (pw *PaymentWatcher) Stop(ctx context.Context) error {
+	if pw.cancel != nil {
+		pw.cancel()
+	}
+	pw.wg.Wait()
+
+	err := pw.db.WithContext(ctx).Model(&models.Transaction{}).
+		Where("lease_owner = ?", pw.owner).
+		Updates(map[string]interface{}{
+			"lease_owner":      "",
+			"lease_expires_at": nil,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to release transaction leases: %w", err)
+	}
+
+	logrus.Infof("Payment watcher %s stopped", pw.owner)
+	return nil
+}
+
+// Wake asks the watcher to poll now instead of waiting for the next tick,
+// e.g. right after a transaction has been created
+func (pw *PaymentWatcher) Wake() {
+	select {
+	case pw.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run polls until the context is cancelled
+func (pw *PaymentWatcher) run(ctx context.Context) {
+	for {
+		delay := pw.poll(ctx)
+
+		// Wake-ups are ignored while backing off, the backend asked us to wait
+		wake := pw.wake
+		if pw.backoff > 0 {
+			wake = nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-wake:
+			timer.Stop()
+		}
+	}
+}
+
+// listen handles chain notifications until the context is cancelled,
+// resubscribing whenever the notifier fails
+func (pw *PaymentWatcher) listen(ctx context.Context) {
+	monitor := pw.processor.PaymentMonitor()
+	pw.refreshWatchedAddresses(ctx)
+
+	for {
+		err := monitor.Listen(ctx, pw.opts.Notifier, func(event crypto.PushEvent) {
+			pw.handlePushEvent(ctx, event)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.Errorf("Chain notifier failed, retrying in %s: %v", notifierRetryDelay, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(notifierRetryDelay):
+		}
+	}
+}
+
+// handlePushEvent makes the transactions affected by a chain event due now
+func (pw *PaymentWatcher) handlePushEvent(ctx context.Context, event crypto.PushEvent) {
+	query := pw.db.WithContext(ctx).Model(&models.Transaction{}).
+		Where(monitoredCondition, watchedStatuses, settledStatuses)
+
+	if event.BlockHash != "" {
+		// A block changes the confirmations of every payment already seen
+		query = query.Where("EXISTS (SELECT 1 FROM payments WHERE payments.transaction_id = transactions.id)")
+	} else {
+		query = query.Where("payment_address IN ?", event.Addresses)
+	}
+
+	result := query.Update("next_check_at", time.Now())
+	if result.Error != nil {
+		logrus.Errorf("Failed to schedule payment checks: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		pw.Wake()
+	}
+}
+
+// refreshWatchedAddresses loads the deposit addresses of open transactions
+// into the payment monitor for push mode matching
+func (pw *PaymentWatcher) refreshWatchedAddresses(ctx context.Context) {
+	var addresses []string
+	err := pw.db.WithContext(ctx).Model(&models.Wallet{}).
+		Joins("JOIN transactions ON transactions.id = wallets.transaction_id").
+		Where("wallets.is_active = ?", true).
+		Where(monitoredCondition, watchedStatuses, settledStatuses).
+		Pluck("wallets.address", &addresses).Error
+	if err != nil {
+		if ctx.Err() == nil {
+			logrus.Errorf("Failed to load watched addresses: %v", err)
+		}
+		return
+	}
+
+	pw.processor.PaymentMonitor().SetWatchedAddresses(addresses)
+}
+
+// checkResult is the outcome of checking one claimed transaction
+type checkResult struct {
+	retryAfter time.Duration
+	throttled  bool
+}
+
+// poll claims the transactions that are due, checks them and returns how
+// long to wait before polling again
+func (pw *PaymentWatcher) poll(ctx context.Context) time.Duration {
+	if pw.opts.Notifier != nil {
+		pw.refreshWatchedAddresses(ctx)
+	}
+
+	transactions, err := pw.claim(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logrus.Errorf("Failed to claim transactions: %v", err)
+		}
+		return pw.opts.FastPollInterval
+	}
+	if len(transactions) == 0 {
+		return pw.opts.FastPollInterval
+	}
+
+	// One tip height lookup serves the whole batch
+	tipHeight, err := pw.processor.TipHeight(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to get tip height: %v", err)
+		for i := range transactions {
+			pw.release(ctx, transactions[i].ID, nil)
+		}
+		retryAfter, throttled := crypto.IsThrottled(err)
+		return pw.nextDelay(checkResult{retryAfter: retryAfter, throttled: throttled})
+	}
+
+	// Transactions waiting their turn behind slow checks keep their leases
+	renewCtx, stopRenewing := context.WithCancel(ctx)
+	defer stopRenewing()
+	go pw.renewLeases(renewCtx)
+
+	jobs := make(chan *models.Transaction)
+	results := make(chan checkResult, len(transactions))
+
+	// Once the backend throttles us the rest of the batch is handed back
+	// unchecked, keeping its place in the queue
+	var throttledMu sync.Mutex
+	throttled := false
+
+	var workers sync.WaitGroup
+	for i := 0; i < pw.opts.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for transaction := range jobs {
+				throttledMu.Lock()
+				skip := throttled
+				throttledMu.Unlock()
+				if skip || ctx.Err() != nil {
+					pw.release(ctx, transaction.ID, nil)
+					continue
+				}
+
+				result := pw.check(ctx, transaction, tipHeight)
+				if result.throttled {
+					throttledMu.Lock()
+					throttled = true
+					throttledMu.Unlock()
+				}
+				results <- result
+			}
+		}()
+	}
+
+	for i := range transactions {
+		jobs <- &transactions[i]
+	}
+	close(jobs)
+	workers.Wait()
+	close(results)
+
+	var batch checkResult
+	for result := range results {
+		if result.throttled {
+			batch.throttled = true
+			if result.retryAfter > batch.retryAfter {
+				batch.retryAfter = result.retryAfter
+			}
+		}
+	}
+
+	return pw.nextDelay(batch)
+}
+
+// check checks one transaction, schedules its next check and releases its lease
+func (pw *PaymentWatcher) check(ctx context.Context, transaction *models.Transaction, tipHeight int64) checkResult {
+	report, err := pw.processor.CheckTransaction(ctx, transaction, tipHeight)
+	if err != nil {
+		if retryAfter, throttled := crypto.IsThrottled(err); throttled {
+			logrus.Warnf("Chain backend throttled payment check for transaction %s: %v", transaction.ID, err)
+			pw.release(ctx, transaction.ID, nil)
+			return checkResult{retryAfter: retryAfter, throttled: true}
+		}
+		logrus.Errorf("Payment check failed for transaction %s: %v", transaction.ID, err)
+	}
+
+	// Addresses with payments in the mempool are likely to change soon
+	interval := pw.opts.PollInterval
+	if report != nil && report.PaymentStatus.Status == "unconfirmed" {
+		interval = pw.opts.FastPollInterval
+	}
+
+	nextCheckAt := time.Now().Add(interval)
+	pw.release(ctx, transaction.ID, &nextCheckAt)
+	return checkResult{}
+}
+
+// nextDelay adapts the polling delay to how the backend answered the last batch
+func (pw *PaymentWatcher) nextDelay(result checkResult) time.Duration {
+	if !result.throttled {
+		if pw.backoff > 0 {
+			logrus.Info("Chain backend recovered, resuming normal payment polling")
+		}
+		pw.backoff = 0
+		return pw.opts.FastPollInterval
+	}
+
+	if pw.backoff == 0 {
+		pw.backoff = pw.opts.FastPollInterval
+	} else {
+		pw.backoff *= 2
+	}
+	if pw.backoff > maxPollBackoff {
+		pw.backoff = maxPollBackoff
+	}
+	if result.retryAfter > pw.backoff {
+		pw.backoff = result.retryAfter
+	}
+
+	logrus.Warnf("Chain backend is throttling requests, pausing payment polling for %s", pw.backoff)
+	return pw.backoff
+}
+
+// claim leases up to a batch of due open transactions that no other replica
+// currently holds. Rows locked by a concurrent claim are skipped rather than
+// waited for.
+func (pw *PaymentWatcher) claim(ctx context.Context) ([]models.Transaction, error) {
+	now := time.Now()
+
+	var transactions []models.Transaction
+	err := pw.db.WithContext(ctx).Raw(`
+		UPDATE transactions SET lease_owner = ?, lease_expires_at = ?
+		WHERE id IN (
+			SELECT id FROM transactions
+			WHERE `+monitoredCondition+`
+				AND (lease_expires_at IS NULL OR lease_expires_at < ?)
+				AND (next_check_at IS NULL OR next_check_at <= ?)
+			ORDER BY next_check_at NULLS FIRST, updated_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		pw.owner, now.Add(pw.opts.LeaseDuration), watchedStatuses, settledStatuses, now, now, paymentWatcherBatchSize,
+	).Scan(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// renewLeases extends the leases this replica holds every third of the lease
+// duration until ctx is done, so that another replica never claims a
+// transaction that is still queued or being checked here
+func (pw *PaymentWatcher) renewLeases(ctx context.Context) {
+	ticker := time.NewTicker(pw.opts.LeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := pw.db.WithContext(ctx).Model(&models.Transaction{}).
+				Where("lease_owner = ?", pw.owner).
+				Update("lease_expires_at", time.Now().Add(pw.opts.LeaseDuration)).Error
+			if err != nil && ctx.Err() == nil {
+				logrus.Errorf("Failed to renew transaction leases: %v", err)
+			}
+		}
+	}
+}
+
+// release gives up the lease on a transaction, scheduling its next check at
+// nextCheckAt. A nil nextCheckAt leaves the transaction due immediately.
+func (pw *PaymentWatcher) release(ctx context.Context, transactionID uuid.UUID, nextCheckAt *time.Time) {
+	updates := map[string]interface{}{
+		"lease_owner":      "",
+		"lease_expires_at": nil,
+	}
+	if nextCheckAt != nil {
+		updates["next_check_at"] = *nextCheckAt
+	}
+
+	err := pw.db.WithContext(ctx).Model(&models.Transaction{}).
+		Where("id = ? AND lease_owner = ?", transactionID, pw.owner).
+		Updates(updates).Error
+	if err != nil && ctx.Err() == nil {
+		logrus.Errorf("Failed to release lease on transaction %s: %v", transactionID, err)
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"hellomix-backend/pkg/money"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// PayoutRequest asks a payout rail to send one leg of a payout
+type PayoutRequest struct {
+	// IdempotencyKey identifies the leg. A rail must not pay twice when a
+	// leg is sent again with the same key, e.g. after a restart.
+	IdempotencyKey string
+	TransactionID  uuid.UUID
+	Currency       string
+	Address        string
+	// Memo is the memo the leg has to carry, if any; it has been validated
+	// against the rules of the rail's chain
+	Memo string
+	// Amount is in base units of Currency
+	Amount money.Amount
+}
+
+// PayoutReceipt is what a rail returns for a sent leg
+type PayoutReceipt struct {
+	TxHash string
+}
+
+// PayoutRailStatus is the on-chain state of a sent leg
+type PayoutRailStatus struct {
+	Confirmations int
+	// Confirmed is set once the rail considers the leg final
+	Confirmed bool
+}
+
+// PayoutRail delivers exchange outputs in one currency, such as an EVM or
+// Solana signer. Errors wrapping ErrPayoutFailed mark a leg that cannot be
+// sent; any other error is retried on the next check.
+type PayoutRail interface {
+	// Currency returns the currency symbol the rail sends
+	Currency() string
+	// Send sends a leg and returns its transaction hash
+	Send(ctx context.Context, req PayoutRequest) (*PayoutReceipt, error)
+	// Status looks up a sent leg by transaction hash
+	Status(ctx context.Context, txHash string) (*PayoutRailStatus, error)
+	// EstimateFee projects the network fee, in base units of the rail's
+	// currency, of sending a leg. It is quoted to the user before the leg
+	// is sent.
+	EstimateFee(ctx context.Context, req PayoutRequest) (money.Amount, error)
+}
+
+// PayoutRailRegistry holds the payout rails by currency code. Each network
+// of a token has a rail of its own, e.g. USDT-ERC20 and USDT-TRC20.
+type PayoutRailRegistry struct {
+	mu    sync.RWMutex
+	rails map[string]PayoutRail
+}
+
+// NewPayoutRailRegistry creates an empty payout rail registry
+func NewPayoutRailRegistry() *PayoutRailRegistry {
+	return &PayoutRailRegistry{
+		rails: make(map[string]PayoutRail),
+	}
+}
+
+// Register adds a rail, replacing any rail of the same currency
+func (r *PayoutRailRegistry) Register(rail PayoutRail) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rails[railKey(rail.Currency())] = rail
+}
+
+// Get returns the rail of a currency
+func (r *PayoutRailRegistry) Get(currency string) (PayoutRail, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rail, ok := r.rails[railKey(currency)]
+	return rail, ok
+}
+
+// railKey is the registry key of a currency, its code in the currency
+// registry, so a rail registered for USDT serves USDT-ERC20
+func railKey(currency string) string {
+	if entry, err := money.LookupCurrency(currency); err == nil {
+		return entry.Code
+	}
+	return strings.ToUpper(currency)
+}
+
+// Currencies returns the currencies with a registered rail, sorted
+func (r *PayoutRailRegistry) Currencies() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	currencies := make([]string, 0, len(r.rails))
+	for currency := range r.rails {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// DryRunRail pretends to send payouts so the payout orchestration can be
+// exercised without a chain. Its transaction hashes are derived from the
+// leg, so sending a leg again yields the same hash, and every leg it sent is
+// reported confirmed.
+type DryRunRail struct {
+	currency string
+}
+
+// NewDryRunRail creates a dry-run rail for a currency
+func NewDryRunRail(currency string) *DryRunRail {
+	return &DryRunRail{currency: strings.ToUpper(currency)}
+}
+
+// Currency returns the currency symbol the rail sends
+func (dr *DryRunRail) Currency() string {
+	return dr.currency
+}
+
+// Send returns a deterministic transaction hash without sending anything
+func (dr *DryRunRail) Send(ctx context.Context, req PayoutRequest) (*PayoutReceipt, error) {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s", req.IdempotencyKey, dr.currency, req.Address, req.Amount)))
+	txHash := "0x" + hex.EncodeToString(hash[:])
+
+	destination := req.Address
+	if req.Memo != "" {
+		destination += fmt.Sprintf(" (memo %q)", req.Memo)
+	}
+	logrus.Infof("Dry run: %s base units of %s to %s for transaction %s as %s", req.Amount, dr.currency, destination, req.TransactionID, txHash)
+	return &PayoutReceipt{TxHash: txHash}, nil
+}
+
+// Status reports every leg as confirmed
+func (dr *DryRunRail) Status(ctx context.Context, txHash string) (*PayoutRailStatus, error) {
+	return &PayoutRailStatus{Confirmations: 1, Confirmed: true}, nil
+}
+
+// EstimateFee returns zero, a dry run costs nothing
+func (dr *DryRunRail) EstimateFee(ctx context.Context, req PayoutRequest) (money.Amount, error) {
+	return money.Amount{}, nil
+}
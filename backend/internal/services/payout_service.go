@@ -0,0 +1,677 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/crypto"
+	"hellomix-backend/pkg/money"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ErrPayoutFailed marks payout errors that retrying will not fix, such as a
+// deposit too small to pay out or a PSBT the signer cancelled
+var ErrPayoutFailed = errors.New("payout failed")
+
+// PayoutOptions configures a PayoutService
+type PayoutOptions struct {
+	// FeeRate is the fee rate in sat/vB, deducted from the payout. Zero uses
+	// the fee service's estimate.
+	FeeRate int64
+	// MinConfirmations is how many confirmations a payout needs to be done
+	MinConfirmations int
+	// OfflineSigning exports every payout as a PSBT instead of signing it here
+	OfflineSigning bool
+	// HotSignLimit is the largest payout, in satoshis, signed with hot keys.
+	// Larger payouts are exported as PSBTs; zero means no limit.
+	HotSignLimit int64
+}
+
+// PayoutService sends the output of exchanges. A BTC payout spends the
+// deposit of its transaction to every output address in one transaction,
+// with the network fee deducted from the output and the service fee sent to
+// a fresh change address of the deposit keychain, where the next sweep picks
+// it up. The signed transaction is stored before it is broadcast, so a payout
+// interrupted by a restart is rebroadcast instead of being paid twice.
+// Other currencies are sent leg by leg through the rail registered for them.
+type PayoutService struct {
+	db            *gorm.DB
+	walletService *WalletService
+	psbtService   *PSBTService
+	rails         *PayoutRailRegistry
+	feeService    *FeeService
+	backend       crypto.ChainBackend
+	netParams     *chaincfg.Params
+	opts          PayoutOptions
+}
+
+// NewPayoutService creates a new payout service
+func NewPayoutService(db *gorm.DB, walletService *WalletService, psbtService *PSBTService, rails *PayoutRailRegistry, feeService *FeeService, backend crypto.ChainBackend, netParams *chaincfg.Params, opts PayoutOptions) (*PayoutService, error) {
+	if opts.FeeRate < 0 {
+		return nil, fmt.Errorf("payout fee rate must not be negative")
+	}
+	if opts.FeeRate == 0 && feeService == nil {
+		return nil, fmt.Errorf("payout fee rate is required without a fee service")
+	}
+	if opts.MinConfirmations < 1 {
+		opts.MinConfirmations = 1
+	}
+
+	ps := &PayoutService{
+		db:            db,
+		walletService: walletService,
+		psbtService:   psbtService,
+		rails:         rails,
+		feeService:    feeService,
+		backend:       backend,
+		netParams:     netParams,
+		opts:          opts,
+	}
+	psbtService.RegisterBroadcastHandler(models.PSBTKindPayout, ps.completePayoutPSBT)
+	return ps, nil
+}
+
+// Supports reports whether outputs in a currency can be paid out
+func (ps *PayoutService) Supports(currency string) bool {
+	if currency == "BTC" {
+		return true
+	}
+	_, ok := ps.rails.Get(currency)
+	return ok
+}
+
+// feeRate returns the configured payout fee rate, or the estimated one
+func (ps *PayoutService) feeRate(ctx context.Context) int64 {
+	if ps.opts.FeeRate > 0 {
+		return ps.opts.FeeRate
+	}
+	return ps.feeService.FeeRate(ctx)
+}
+
+// Current returns the latest payout of a transaction that was not
+// cancelled, or nil if there is none
+func (ps *PayoutService) Current(ctx context.Context, transactionID uuid.UUID) (*models.Payout, error) {
+	var payout models.Payout
+	err := ps.db.WithContext(ctx).
+		Where("transaction_id = ? AND status <> ?", transactionID, models.PayoutStatusCancelled).
+		Order("created_at DESC").
+		First(&payout).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payout: %w", err)
+	}
+
+	return &payout, nil
+}
+
+// Halt cancels the payout of a transaction whose payment is no longer
+// confirmed, provided nothing of it can have been sent yet: rail payouts
+// with every leg pending and payouts waiting for an offline signature. It
+// reports whether the transaction is left without a payout. Signed BTC
+// payouts are kept, they spend the deposit itself and cannot confirm
+// without it.
+func (ps *PayoutService) Halt(ctx context.Context, transactionID uuid.UUID) (bool, error) {
+	payout, err := ps.Current(ctx, transactionID)
+	if err != nil {
+		return false, err
+	}
+	if payout == nil {
+		return true, nil
+	}
+
+	switch payout.Status {
+	case models.PayoutStatusPending:
+		for _, leg := range payout.Legs {
+			if leg.Status != models.PayoutLegPending {
+				return false, nil
+			}
+		}
+
+	case models.PayoutStatusAwaitingSignature:
+		if payout.PSBTID != nil {
+			if err := ps.psbtService.Cancel(ctx, *payout.PSBTID); err != nil {
+				// The signed PSBT may already have been submitted
+				return false, err
+			}
+		}
+
+	default:
+		return false, nil
+	}
+
+	if err := ps.updatePayout(ctx, payout.ID, map[string]interface{}{"status": models.PayoutStatusCancelled}); err != nil {
+		return false, err
+	}
+	logrus.Warnf("Cancelled payout %s of transaction %s before anything was sent", payout.ID, transactionID)
+	return true, nil
+}
+
+// SendBTC pays amountSats, less the network fee, from the deposit of a
+// transaction to its output addresses split by percentage. Payouts that must
+// not be signed with hot keys are exported as PSBTs and sent once the signed
+// PSBT is submitted.
+func (ps *PayoutService) SendBTC(ctx context.Context, transaction *models.Transaction, amountSats int64) (*models.Payout, error) {
+	var wallet models.Wallet
+	if err := ps.db.WithContext(ctx).Where("address = ?", transaction.PaymentAddress).First(&wallet).Error; err != nil {
+		return nil, fmt.Errorf("failed to get deposit wallet: %w", err)
+	}
+
+	inputs, err := ps.depositInputs(ctx, &wallet)
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("%w: no confirmed deposit at %s", ErrPayoutFailed, wallet.Address)
+	}
+
+	recipients := make([]crypto.PayoutRecipient, len(transaction.OutputAddresses))
+	for i, output := range transaction.OutputAddresses {
+		address, err := btcutil.DecodeAddress(output.Address, ps.netParams)
+		if err != nil || !address.IsForNet(ps.netParams) {
+			return nil, fmt.Errorf("%w: invalid output address %s", ErrPayoutFailed, output.Address)
+		}
+		recipients[i] = crypto.PayoutRecipient{Address: address, Percentage: output.Percentage}
+	}
+
+	feeRate := ps.feeRate(ctx)
+
+	// The change wallet and any PSBT are stored in the transaction storing
+	// the payout, so a payout that fails burns no derivation index and locks
+	// no deposit
+	var payout *models.Payout
+	var signed *wire.MsgTx
+	err = ps.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		payout, signed, err = ps.withTx(tx).createBTCPayout(ctx, transaction, &wallet, inputs, recipients, amountSats, feeRate)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if signed != nil {
+		if err := ps.broadcast(ctx, payout, signed); err != nil {
+			// The stored transaction is broadcast again on the next check
+			logrus.Errorf("Failed to broadcast payout %s of transaction %s: %v", payout.TXID, transaction.ID, err)
+		}
+	}
+
+	return payout, nil
+}
+
+// withTx returns a copy of the payout service that runs its queries inside tx
+func (ps *PayoutService) withTx(tx *gorm.DB) *PayoutService {
+	copied := *ps
+	copied.db = tx
+	copied.walletService = ps.walletService.WithTx(tx)
+	copied.psbtService = ps.psbtService.WithTx(tx)
+	return &copied
+}
+
+// createBTCPayout builds the payout of a transaction's deposit and stores it
+// with its change wallet, signed or exported as a PSBT. The signed
+// transaction is returned for broadcasting once the payout is committed.
+func (ps *PayoutService) createBTCPayout(ctx context.Context, transaction *models.Transaction, wallet *models.Wallet, inputs []crypto.SweepInput, recipients []crypto.PayoutRecipient, amountSats, feeRate int64) (*models.Payout, *wire.MsgTx, error) {
+	changeWallet, err := ps.walletService.DeriveChangeWallet(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive change address: %w", err)
+	}
+	change, err := btcutil.DecodeAddress(changeWallet.Address, ps.netParams)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid change address %s: %w", changeWallet.Address, err)
+	}
+
+	tx, values, fee, err := crypto.BuildPayoutTx(inputs, recipients, amountSats, change, feeRate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to build payout: %v", ErrPayoutFailed, err)
+	}
+
+	payout := &models.Payout{
+		TransactionID: transaction.ID,
+		Currency:      "BTC",
+		FeeSats:       fee,
+	}
+	for i, output := range transaction.OutputAddresses {
+		payout.Legs = append(payout.Legs, models.PayoutLeg{
+			Address:    output.Address,
+			Percentage: output.Percentage,
+			Amount:     money.NewAmount(values[i]),
+			AmountSats: values[i],
+		})
+		payout.AmountSats += values[i]
+	}
+	payout.Amount = money.NewAmount(payout.AmountSats)
+	if len(tx.TxOut) > len(values) {
+		payout.ChangeAddress = changeWallet.Address
+		payout.ChangeSats = tx.TxOut[len(values)].Value
+		if err := ps.db.WithContext(ctx).Create(changeWallet).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to store change wallet: %w", err)
+		}
+	}
+
+	offline := ps.opts.OfflineSigning || ps.walletService.IsWatchOnly() ||
+		(ps.opts.HotSignLimit > 0 && payout.AmountSats > ps.opts.HotSignLimit)
+	if offline {
+		exported, err := ps.exportPayout(ctx, payout, tx, inputs)
+		return exported, nil, err
+	}
+
+	keys := make([]*btcec.PrivateKey, len(inputs))
+	key, err := ps.walletService.GetPrivateKey(ctx, wallet.Address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get key for %s: %w", wallet.Address, err)
+	}
+	for i := range inputs {
+		keys[i] = key
+	}
+	if err := crypto.SignSweepTx(tx, inputs, keys); err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to sign payout: %v", ErrPayoutFailed, err)
+	}
+
+	var raw bytes.Buffer
+	if err := tx.Serialize(&raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to serialize payout: %w", err)
+	}
+	payout.TXID = tx.TxHash().String()
+	payout.RawTx = hex.EncodeToString(raw.Bytes())
+	payout.Status = models.PayoutStatusSigned
+	if err := ps.db.WithContext(ctx).Create(payout).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to store payout: %w", err)
+	}
+
+	return payout, tx, nil
+}
+
+// SendRail records a payout of amount, in base units of the output currency,
+// split by percentage across the output addresses of a transaction. Its legs
+// are sent through the currency's payout rail by CheckPayout.
+func (ps *PayoutService) SendRail(ctx context.Context, transaction *models.Transaction, amount money.Amount) (*models.Payout, error) {
+	if _, ok := ps.rails.Get(transaction.OutputCurrency); !ok {
+		return nil, fmt.Errorf("%w: no payout rail for %s", ErrPayoutFailed, transaction.OutputCurrency)
+	}
+
+	payout := &models.Payout{
+		TransactionID: transaction.ID,
+		Currency:      transaction.OutputCurrency,
+		Amount:        amount,
+		Status:        models.PayoutStatusPending,
+	}
+	legAmounts := splitAmount(amount, transaction.OutputAddresses)
+	for i, output := range transaction.OutputAddresses {
+		payout.Legs = append(payout.Legs, models.PayoutLeg{
+			Address:    output.Address,
+			Memo:       output.Memo,
+			Percentage: output.Percentage,
+			Amount:     legAmounts[i],
+			Status:     models.PayoutLegPending,
+		})
+	}
+
+	if err := ps.db.WithContext(ctx).Create(payout).Error; err != nil {
+		return nil, fmt.Errorf("failed to store payout: %w", err)
+	}
+
+	logrus.Infof("Paying out %s base units of %s to %d addresses for transaction %s", amount, payout.Currency, len(payout.Legs), transaction.ID)
+	return payout, nil
+}
+
+// checkRailPayout sends the pending legs of a rail payout and looks up the
+// sent ones, persisting every leg as soon as it changes. It reports whether
+// every leg has confirmed.
+func (ps *PayoutService) checkRailPayout(ctx context.Context, payout *models.Payout) (bool, error) {
+	rail, ok := ps.rails.Get(payout.Currency)
+	if !ok {
+		return false, fmt.Errorf("%w: no payout rail for %s", ErrPayoutFailed, payout.Currency)
+	}
+
+	confirmed := true
+	for i := range payout.Legs {
+		leg := &payout.Legs[i]
+
+		switch leg.Status {
+		case models.PayoutLegPending:
+			confirmed = false
+			receipt, err := rail.Send(ctx, PayoutRequest{
+				IdempotencyKey: fmt.Sprintf("%s:%d", payout.ID, i),
+				TransactionID:  payout.TransactionID,
+				Currency:       payout.Currency,
+				Address:        leg.Address,
+				Memo:           leg.Memo,
+				Amount:         leg.Amount,
+			})
+			if err != nil {
+				if !errors.Is(err, ErrPayoutFailed) {
+					return false, fmt.Errorf("failed to send leg %d of payout %s: %w", i, payout.ID, err)
+				}
+				leg.Status = models.PayoutLegFailed
+				leg.Error = err.Error()
+				if updateErr := ps.updatePayout(ctx, payout.ID, map[string]interface{}{
+					"legs":   payout.Legs,
+					"status": models.PayoutStatusFailed,
+				}); updateErr != nil {
+					logrus.Errorf("Failed to record failed leg of payout %s: %v", payout.ID, updateErr)
+				}
+				payout.Status = models.PayoutStatusFailed
+				return false, fmt.Errorf("leg %d of payout %s to %s: %w", i, payout.ID, leg.Address, err)
+			}
+
+			leg.TxHash = receipt.TxHash
+			leg.Status = models.PayoutLegSent
+			if err := ps.updatePayout(ctx, payout.ID, map[string]interface{}{"legs": payout.Legs}); err != nil {
+				return false, err
+			}
+			if payout.BroadcastAt == nil {
+				// Funds have left, the payout can no longer be taken back
+				if err := ps.markPaidOut(ctx, payout.TransactionID); err != nil {
+					return false, err
+				}
+			}
+
+		case models.PayoutLegSent:
+			status, err := rail.Status(ctx, leg.TxHash)
+			if err != nil {
+				return false, fmt.Errorf("failed to get status of leg %d of payout %s: %w", i, payout.ID, err)
+			}
+			if !status.Confirmed {
+				confirmed = false
+				continue
+			}
+			leg.Status = models.PayoutLegConfirmed
+			if err := ps.updatePayout(ctx, payout.ID, map[string]interface{}{"legs": payout.Legs}); err != nil {
+				return false, err
+			}
+
+		case models.PayoutLegFailed:
+			return false, fmt.Errorf("%w: leg %d of payout %s failed: %s", ErrPayoutFailed, i, payout.ID, leg.Error)
+		}
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{}
+	if payout.BroadcastAt == nil {
+		updates["status"] = models.PayoutStatusBroadcast
+		updates["broadcast_at"] = now
+		payout.Status = models.PayoutStatusBroadcast
+		payout.BroadcastAt = &now
+	}
+	if confirmed {
+		updates["status"] = models.PayoutStatusConfirmed
+		updates["confirmed_at"] = now
+		payout.Status = models.PayoutStatusConfirmed
+		payout.ConfirmedAt = &now
+	}
+	if len(updates) > 0 {
+		if err := ps.updatePayout(ctx, payout.ID, updates); err != nil {
+			return false, err
+		}
+	}
+
+	if confirmed {
+		logrus.Infof("Payout %s of %s base units of %s for transaction %s confirmed", payout.ID, payout.Amount, payout.Currency, payout.TransactionID)
+	}
+	return confirmed, nil
+}
+
+// exportPayout stores a payout as a PSBT waiting for an offline signature
+func (ps *PayoutService) exportPayout(ctx context.Context, payout *models.Payout, tx *wire.MsgTx, inputs []crypto.SweepInput) (*models.Payout, error) {
+	packet, err := crypto.NewSweepPSBT(tx, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.PSBT{
+		Kind:           models.PSBTKindPayout,
+		TransactionIDs: models.UUIDList{payout.TransactionID},
+		AmountSats:     payout.AmountSats,
+		FeeSats:        payout.FeeSats,
+	}
+	if len(payout.Legs) == 1 {
+		record.Destination = payout.Legs[0].Address
+	}
+	if err := ps.psbtService.Create(ctx, record, packet); err != nil {
+		return nil, err
+	}
+
+	payout.PSBTID = &record.ID
+	payout.Status = models.PayoutStatusAwaitingSignature
+	if err := ps.db.WithContext(ctx).Create(payout).Error; err != nil {
+		return nil, fmt.Errorf("failed to store payout: %w", err)
+	}
+
+	logrus.Infof("Payout of transaction %s exported as PSBT %s for offline signing", payout.TransactionID, record.ID)
+	return payout, nil
+}
+
+// CheckPayout moves a payout towards confirmation: signed payouts are
+// (re)broadcast, and broadcast ones are looked up on chain. Payouts are only
+// sent again when the backend answers that it does not know them. It reports
+// whether the payout has MinConfirmations confirmations.
+func (ps *PayoutService) CheckPayout(ctx context.Context, payout *models.Payout) (bool, error) {
+	if payout.Currency != "BTC" {
+		return ps.checkRailPayout(ctx, payout)
+	}
+
+	switch payout.Status {
+	case models.PayoutStatusConfirmed:
+		return true, nil
+
+	case models.PayoutStatusAwaitingSignature:
+		if payout.PSBTID == nil {
+			return false, fmt.Errorf("payout %s has no PSBT", payout.ID)
+		}
+		record, err := ps.psbtService.Get(ctx, *payout.PSBTID)
+		if err != nil {
+			return false, err
+		}
+		if record.Status == models.PSBTStatusCancelled {
+			if err := ps.updatePayout(ctx, payout.ID, map[string]interface{}{"status": models.PayoutStatusCancelled}); err != nil {
+				return false, err
+			}
+			payout.Status = models.PayoutStatusCancelled
+			return false, fmt.Errorf("%w: PSBT %s of payout %s was cancelled", ErrPayoutFailed, record.ID, payout.ID)
+		}
+		// Still waiting for the signer
+		return false, nil
+
+	case models.PayoutStatusSigned:
+		if _, err := ps.backend.GetTransaction(ctx, payout.TXID); err != nil {
+			if !errors.Is(err, crypto.ErrNotFound) {
+				return false, fmt.Errorf("failed to look up payout transaction %s: %w", payout.TXID, err)
+			}
+			tx, err := decodeRawTx(payout.RawTx)
+			if err != nil {
+				return false, err
+			}
+			if err := ps.broadcast(ctx, payout, tx); err != nil {
+				return false, err
+			}
+			return false, nil
+		}
+		// An earlier broadcast went through before it could be recorded
+		if err := ps.markBroadcast(ctx, payout); err != nil {
+			return false, err
+		}
+	}
+
+	tx, err := ps.backend.GetTransaction(ctx, payout.TXID)
+	if err != nil {
+		if payout.RawTx == "" || !errors.Is(err, crypto.ErrNotFound) {
+			return false, fmt.Errorf("failed to get payout transaction %s: %w", payout.TXID, err)
+		}
+		// Dropped from the mempool, send it again
+		logrus.Warnf("Payout %s not found, rebroadcasting: %v", payout.TXID, err)
+		rawTx, decodeErr := decodeRawTx(payout.RawTx)
+		if decodeErr != nil {
+			return false, decodeErr
+		}
+		if _, err := ps.backend.BroadcastTransaction(ctx, rawTx); err != nil {
+			return false, fmt.Errorf("failed to rebroadcast payout %s: %w", payout.TXID, err)
+		}
+		return false, nil
+	}
+	if !tx.Status.Confirmed {
+		return false, nil
+	}
+
+	tipHeight, err := ps.backend.GetTipHeight(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get tip height: %w", err)
+	}
+	confirmations := crypto.Confirmations(tipHeight, tx.Status.BlockHeight)
+	updates := map[string]interface{}{
+		"confirmations": confirmations,
+		"block_hash":    tx.Status.BlockHash,
+		"block_height":  tx.Status.BlockHeight,
+	}
+	confirmed := confirmations >= ps.opts.MinConfirmations
+	if confirmed {
+		now := time.Now()
+		updates["status"] = models.PayoutStatusConfirmed
+		updates["confirmed_at"] = now
+		payout.Status = models.PayoutStatusConfirmed
+		payout.ConfirmedAt = &now
+	}
+	if err := ps.updatePayout(ctx, payout.ID, updates); err != nil {
+		return false, err
+	}
+	payout.Confirmations = confirmations
+	payout.BlockHash = tx.Status.BlockHash
+	payout.BlockHeight = tx.Status.BlockHeight
+
+	if confirmed {
+		logrus.Infof("Payout %s of transaction %s confirmed with %d confirmations", payout.TXID, payout.TransactionID, confirmations)
+	}
+	return confirmed, nil
+}
+
+// broadcast sends a signed payout and records that it left
+func (ps *PayoutService) broadcast(ctx context.Context, payout *models.Payout, tx *wire.MsgTx) error {
+	txid, err := ps.backend.BroadcastTransaction(ctx, tx)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Broadcast payout %s of %d sats to %d addresses for transaction %s",
+		txid, payout.AmountSats, len(payout.Legs), payout.TransactionID)
+
+	return ps.markBroadcast(ctx, payout)
+}
+
+// markBroadcast records a payout as broadcast and its transaction as paid out
+func (ps *PayoutService) markBroadcast(ctx context.Context, payout *models.Payout) error {
+	now := time.Now()
+	if err := ps.updatePayout(ctx, payout.ID, map[string]interface{}{
+		"status":       models.PayoutStatusBroadcast,
+		"broadcast_at": now,
+	}); err != nil {
+		return err
+	}
+	payout.Status = models.PayoutStatusBroadcast
+	payout.BroadcastAt = &now
+
+	return ps.markPaidOut(ctx, payout.TransactionID)
+}
+
+// markPaidOut records that funds have been sent for a transaction
+func (ps *PayoutService) markPaidOut(ctx context.Context, transactionID uuid.UUID) error {
+	if err := ps.db.WithContext(ctx).Model(&models.Transaction{}).
+		Where("id = ? AND paid_out_at IS NULL", transactionID).
+		Update("paid_out_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to record payout of transaction %s: %w", transactionID, err)
+	}
+	return nil
+}
+
+// completePayoutPSBT records a payout that was signed offline as broadcast
+func (ps *PayoutService) completePayoutPSBT(ctx context.Context, record *models.PSBT, txid string) error {
+	var payout models.Payout
+	if err := ps.db.WithContext(ctx).Where("psbt_id = ?", record.ID).First(&payout).Error; err != nil {
+		return fmt.Errorf("failed to get payout of PSBT %s: %w", record.ID, err)
+	}
+
+	updates := map[string]interface{}{"txid": txid}
+	if packet, err := crypto.DecodePSBT([]byte(record.SignedPSBT)); err == nil {
+		// Keep the signed transaction for rebroadcasts
+		if tx, err := psbt.Extract(packet); err == nil {
+			var raw bytes.Buffer
+			if err := tx.Serialize(&raw); err == nil {
+				updates["raw_tx"] = hex.EncodeToString(raw.Bytes())
+			}
+		}
+	}
+	if err := ps.updatePayout(ctx, payout.ID, updates); err != nil {
+		return err
+	}
+	payout.TXID = txid
+
+	return ps.markBroadcast(ctx, &payout)
+}
+
+// depositInputs returns the confirmed outputs received by a deposit wallet
+// that no pending PSBT spends
+func (ps *PayoutService) depositInputs(ctx context.Context, wallet *models.Wallet) ([]crypto.SweepInput, error) {
+	utxos, err := ps.backend.GetAddressUTXOs(ctx, wallet.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get UTXOs of %s: %w", wallet.Address, err)
+	}
+
+	locked, err := ps.psbtService.LockedOutpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	origin, err := ps.walletService.KeyOrigin(wallet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key origin of %s: %w", wallet.Address, err)
+	}
+
+	var inputs []crypto.SweepInput
+	for _, utxo := range utxos {
+		if !utxo.Status.Confirmed || locked[fmt.Sprintf("%s:%d", utxo.TXID, utxo.Vout)] {
+			continue
+		}
+		input, err := crypto.NewSweepInput(utxo, wallet.Address, crypto.AddressType(wallet.AddressType), ps.netParams)
+		if err != nil {
+			return nil, err
+		}
+		input.Origin = origin
+		inputs = append(inputs, input)
+	}
+
+	return inputs, nil
+}
+
+// updatePayout updates columns of a payout
+func (ps *PayoutService) updatePayout(ctx context.Context, id uuid.UUID, updates map[string]interface{}) error {
+	if err := ps.db.WithContext(ctx).Model(&models.Payout{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update payout %s: %w", id, err)
+	}
+	return nil
+}
+
+// decodeRawTx decodes a hex encoded transaction
+func decodeRawTx(rawTx string) (*wire.MsgTx, error) {
+	raw, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw transaction: %w", err)
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("invalid raw transaction: %w", err)
+	}
+	return tx, nil
+}
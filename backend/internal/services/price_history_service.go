@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/money"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Candle intervals
+const (
+	CandleMinute = "1m"
+	CandleHour   = "1h"
+	CandleDay    = "1d"
+)
+
+// candleIntervals are the candle intervals in the order they are built,
+// each from the candles of the one before; minute candles from the ticks
+var candleIntervals = []struct {
+	name     string
+	duration time.Duration
+}{
+	{CandleMinute, time.Minute},
+	{CandleHour, time.Hour},
+	{CandleDay, 24 * time.Hour},
+}
+
+// CandleDuration returns the duration of a candle interval
+func CandleDuration(interval string) (time.Duration, error) {
+	for _, candleInterval := range candleIntervals {
+		if candleInterval.name == interval {
+			return candleInterval.duration, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown candle interval: %q", interval)
+}
+
+// MaxCandles is the largest number of candles returned at once
+const MaxCandles = 1000
+
+// PriceHistoryOptions configures a PriceHistoryService
+type PriceHistoryOptions struct {
+	// TickRetention is how long price ticks are kept
+	TickRetention time.Duration
+	// MinuteCandleRetention is how long minute candles are kept. Hour and
+	// day candles are kept forever.
+	MinuteCandleRetention time.Duration
+	// Interval is how often ticks are downsampled and pruned
+	Interval time.Duration
+}
+
+// PriceHistoryService keeps the price history: the ticks appended by every
+// price refresh, downsampled into minute, hour and day OHLC candles. A
+// background job rebuilds the candles from the latest one of each interval
+// onwards, so a partial candle is completed by later runs, and prunes ticks
+// and minute candles past retention.
+type PriceHistoryService struct {
+	db     *gorm.DB
+	opts   PriceHistoryOptions
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPriceHistoryService creates a new price history service
+func NewPriceHistoryService(db *gorm.DB, opts PriceHistoryOptions) (*PriceHistoryService, error) {
+	if opts.TickRetention <= 0 {
+		opts.TickRetention = 7 * 24 * time.Hour
+	}
+	if opts.MinuteCandleRetention <= 0 {
+		opts.MinuteCandleRetention = 30 * 24 * time.Hour
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	// Candles are built from the ticks and minute candles still retained
+	if opts.TickRetention < time.Hour || opts.MinuteCandleRetention < 24*time.Hour {
+		return nil, fmt.Errorf("price history retention too short to build hour and day candles")
+	}
+
+	return &PriceHistoryService{
+		db:   db,
+		opts: opts,
+	}, nil
+}
+
+// Start starts downsampling and pruning the price history in the background
+func (phs *PriceHistoryService) Start(ctx context.Context) {
+	ctx, phs.cancel = context.WithCancel(ctx)
+
+	phs.wg.Add(1)
+	go func() {
+		defer phs.wg.Done()
+		phs.run(ctx)
+	}()
+
+	logrus.Infof("Price history maintenance started, running every %s", phs.opts.Interval)
+}
+
+// Stop stops the background job and waits for a running pass to finish
+func (phs *PriceHistoryService) Stop(ctx context.Context) error {
+	if phs.cancel != nil {
+		phs.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		phs.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logrus.Info("Price history maintenance stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to stop price history maintenance: %w", ctx.Err())
+	}
+}
+
+// run downsamples and prunes until the context is cancelled
+func (phs *PriceHistoryService) run(ctx context.Context) {
+	ticker := time.NewTicker(phs.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := phs.Downsample(ctx); err != nil && ctx.Err() == nil {
+			logrus.Errorf("Failed to downsample price history: %v", err)
+			continue
+		}
+		if err := phs.Prune(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			logrus.Errorf("Failed to prune price history: %v", err)
+		}
+	}
+}
+
+// Downsample builds the candles of every interval from the latest stored
+// candle of that interval onwards
+func (phs *PriceHistoryService) Downsample(ctx context.Context) error {
+	for i, candleInterval := range candleIntervals {
+		var latest sql.NullTime
+		if err := phs.db.WithContext(ctx).Model(&models.PriceCandle{}).
+			Where(map[string]interface{}{"interval": candleInterval.name}).
+			Select("max(open_time)").
+			Scan(&latest).Error; err != nil {
+			return fmt.Errorf("failed to find latest %s candle: %w", candleInterval.name, err)
+		}
+
+		var points []models.PriceCandle
+		if i == 0 {
+			var ticks []models.PriceTick
+			query := phs.db.WithContext(ctx).Order("fetched_at")
+			if latest.Valid {
+				query = query.Where("fetched_at >= ?", latest.Time)
+			}
+			if err := query.Find(&ticks).Error; err != nil {
+				return fmt.Errorf("failed to load price ticks: %w", err)
+			}
+			points = TickCandles(ticks)
+		} else {
+			query := phs.db.WithContext(ctx).
+				Where(map[string]interface{}{"interval": candleIntervals[i-1].name}).
+				Order("open_time")
+			if latest.Valid {
+				query = query.Where("open_time >= ?", latest.Time)
+			}
+			if err := query.Find(&points).Error; err != nil {
+				return fmt.Errorf("failed to load %s candles: %w", candleIntervals[i-1].name, err)
+			}
+		}
+
+		candles := BuildCandles(points, candleInterval.name, candleInterval.duration)
+		if len(candles) == 0 {
+			continue
+		}
+		if err := phs.db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "currency"}, {Name: "interval"}, {Name: "open_time"}},
+			DoUpdates: clause.AssignmentColumns([]string{"open", "high", "low", "close", "tick_count", "updated_at"}),
+		}).CreateInBatches(&candles, 500).Error; err != nil {
+			return fmt.Errorf("failed to store %s candles: %w", candleInterval.name, err)
+		}
+	}
+	return nil
+}
+
+// Prune deletes ticks and minute candles past retention
+func (phs *PriceHistoryService) Prune(ctx context.Context, now time.Time) error {
+	ticks := phs.db.WithContext(ctx).
+		Where("fetched_at < ?", now.Add(-phs.opts.TickRetention)).
+		Delete(&models.PriceTick{})
+	if ticks.Error != nil {
+		return fmt.Errorf("failed to prune price ticks: %w", ticks.Error)
+	}
+
+	candles := phs.db.WithContext(ctx).
+		Where(map[string]interface{}{"interval": CandleMinute}).
+		Where("open_time < ?", now.Add(-phs.opts.MinuteCandleRetention)).
+		Delete(&models.PriceCandle{})
+	if candles.Error != nil {
+		return fmt.Errorf("failed to prune minute candles: %w", candles.Error)
+	}
+
+	if ticks.RowsAffected > 0 || candles.RowsAffected > 0 {
+		logrus.Infof("Pruned %d price ticks and %d minute candles", ticks.RowsAffected, candles.RowsAffected)
+	}
+	return nil
+}
+
+// Candles returns the candles of a currency and interval opening in
+// [from, to), oldest first. Currencies share the candles of their asset.
+func (phs *PriceHistoryService) Candles(ctx context.Context, currency, interval string, from, to time.Time) ([]models.PriceCandle, error) {
+	entry, err := money.LookupCurrency(currency)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := CandleDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+	if to.Sub(from)/duration > MaxCandles {
+		return nil, fmt.Errorf("range spans more than %d %s candles", MaxCandles, interval)
+	}
+
+	var candles []models.PriceCandle
+	if err := phs.db.WithContext(ctx).
+		Where(map[string]interface{}{"currency": entry.Asset, "interval": interval}).
+		Where("open_time >= ? AND open_time < ?", from, to).
+		Order("open_time").
+		Find(&candles).Error; err != nil {
+		return nil, fmt.Errorf("failed to get candles: %w", err)
+	}
+	return candles, nil
+}
+
+// TickCandles turns price ticks into single-tick candles, the input of
+// minute candles
+func TickCandles(ticks []models.PriceTick) []models.PriceCandle {
+	candles := make([]models.PriceCandle, len(ticks))
+	for i, tick := range ticks {
+		candles[i] = models.PriceCandle{
+			Currency:  tick.Currency,
+			OpenTime:  tick.FetchedAt,
+			Open:      tick.PriceUSD,
+			High:      tick.PriceUSD,
+			Low:       tick.PriceUSD,
+			Close:     tick.PriceUSD,
+			TickCount: 1,
+		}
+	}
+	return candles
+}
+
+// BuildCandles downsamples candles of a shorter interval into candles of
+// interval, buckets of duration aligned to UTC. The result is sorted by
+// currency and open time.
+func BuildCandles(points []models.PriceCandle, interval string, duration time.Duration) []models.PriceCandle {
+	sorted := append([]models.PriceCandle(nil), points...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Currency != sorted[j].Currency {
+			return sorted[i].Currency < sorted[j].Currency
+		}
+		return sorted[i].OpenTime.Before(sorted[j].OpenTime)
+	})
+
+	var candles []models.PriceCandle
+	for _, point := range sorted {
+		openTime := point.OpenTime.UTC().Truncate(duration)
+
+		last := len(candles) - 1
+		if last < 0 || candles[last].Currency != point.Currency || !candles[last].OpenTime.Equal(openTime) {
+			candles = append(candles, models.PriceCandle{
+				Currency: point.Currency,
+				Interval: interval,
+				OpenTime: openTime,
+				Open:     point.Open,
+				High:     point.High,
+				Low:      point.Low,
+			})
+			last++
+		}
+
+		candle := &candles[last]
+		if point.High > candle.High {
+			candle.High = point.High
+		}
+		if point.Low < candle.Low {
+			candle.Low = point.Low
+		}
+		candle.Close = point.Close
+		candle.TickCount += point.TickCount
+	}
+	return candles
+}
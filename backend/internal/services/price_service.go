@@ -3,175 +3,397 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"strings"
+	"math"
+	"sort"
+	"sync"
 	"time"
 
 	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/money"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
-// PriceService handles cryptocurrency price operations
+// priceSymbols are the assets prices are fetched for, those of the
+// currency registry
+var priceSymbols = money.Assets()
+
+// ErrStalePrices is returned when the latest price snapshot is older than
+// the staleness bound, or there is none at all
+var ErrStalePrices = errors.New("prices are stale")
+
+// priceSnapshotKey is the Redis key of the latest price snapshot
+const priceSnapshotKey = "prices:snapshot"
+
+// PriceOptions configures a PriceService
+type PriceOptions struct {
+	// Sources are the upstreams prices are fetched from
+	Sources []PriceSource
+	// MaxDeviationBps is how far, in basis points, a source's price may be
+	// from the median of all sources before it is dropped as an outlier
+	MaxDeviationBps int64
+	// MinSources is the number of sources that have to agree on a price for
+	// it to be used
+	MinSources int
+	// RefreshInterval is how often the background refresher fetches prices
+	RefreshInterval time.Duration
+	// MaxAge is how old a price snapshot may be before reads are refused
+	MaxAge time.Duration
+}
+
+// PriceService handles cryptocurrency price operations. Prices are fetched
+// from every source at once and aggregated per currency: the median of the
+// sources' prices, after dropping those too far from it. A currency quoted
+// by fewer agreeing sources than required gets no price at all.
+//
+// Fetching happens in the background: a refresher keeps the latest snapshot
+// in memory, Redis and the database, and reads serve that snapshot without
+// touching the upstreams. Snapshots older than MaxAge are refused.
 type PriceService struct {
-	db           *gorm.DB
-	redis        *redis.Client
-	httpClient   *http.Client
-	apiKey       string
-	cacheExpiry  time.Duration
+	db     *gorm.DB
+	redis  *redis.Client
+	opts   PriceOptions
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.RWMutex
+	snapshot *PriceSnapshot
 }
 
 // NewPriceService creates a new price service
-func NewPriceService(db *gorm.DB, redisClient *redis.Client, apiKey string) *PriceService {
+func NewPriceService(db *gorm.DB, redisClient *redis.Client, opts PriceOptions) (*PriceService, error) {
+	if len(opts.Sources) == 0 {
+		return nil, fmt.Errorf("at least one price source is required")
+	}
+	if opts.MinSources < 1 {
+		opts.MinSources = 1
+	}
+	if opts.MinSources > len(opts.Sources) {
+		return nil, fmt.Errorf("%d agreeing price sources required but only %d configured", opts.MinSources, len(opts.Sources))
+	}
+	if opts.MaxDeviationBps <= 0 {
+		opts.MaxDeviationBps = 200
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = 30 * time.Second
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = 5 * time.Minute
+	}
+	if opts.MaxAge < opts.RefreshInterval {
+		return nil, fmt.Errorf("price staleness bound %s is shorter than the refresh interval %s", opts.MaxAge, opts.RefreshInterval)
+	}
+
 	return &PriceService{
-		db:          db,
-		redis:       redisClient,
-		httpClient:  &http.Client{Timeout: 30 * time.Second},
-		apiKey:      apiKey,
-		cacheExpiry: 5 * time.Minute, // Cache prices for 5 minutes
+		db:    db,
+		redis: redisClient,
+		opts:  opts,
+	}, nil
+}
+
+// AggregatedPrice is the USD price of a currency agreed on by Sources
+type AggregatedPrice struct {
+	PriceUSD float64
+	Sources  []string
+}
+
+// PriceSnapshot is a set of USD prices fetched together. Snapshots are
+// never modified once taken.
+type PriceSnapshot struct {
+	Prices map[string]float64 `json:"prices"`
+	// Sources lists the price sources that agreed on each price
+	Sources   map[string][]string `json:"sources,omitempty"`
+	FetchedAt time.Time           `json:"fetched_at"`
+}
+
+// Age returns how long ago the snapshot was taken
+func (s *PriceSnapshot) Age() time.Duration {
+	return time.Since(s.FetchedAt)
+}
+
+// Start starts refreshing prices in the background, right away and then
+// every refresh interval
+func (ps *PriceService) Start(ctx context.Context) {
+	ctx, ps.cancel = context.WithCancel(ctx)
+
+	ps.wg.Add(1)
+	go func() {
+		defer ps.wg.Done()
+		ps.run(ctx)
+	}()
+
+	logrus.Infof("Price refresher started, refreshing every %s", ps.opts.RefreshInterval)
+}
+
+// Stop stops the refresher and waits for an in-flight refresh to finish
+func (ps *PriceService) Stop(ctx context.Context) error {
+	if ps.cancel != nil {
+		ps.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ps.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logrus.Info("Price refresher stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to stop price refresher: %w", ctx.Err())
 	}
 }
 
-// CoinGeckoResponse represents the response from CoinGecko API
-type CoinGeckoResponse map[string]map[string]float64
+// run refreshes prices until the context is cancelled
+func (ps *PriceService) run(ctx context.Context) {
+	ticker := time.NewTicker(ps.opts.RefreshInterval)
+	defer ticker.Stop()
 
-// GetPrices fetches current prices for supported cryptocurrencies
-func (ps *PriceService) GetPrices(ctx context.Context) (map[string]float64, error) {
-	// First, try to get prices from Redis cache
-	cachedPrices, err := ps.getPricesFromCache(ctx)
-	if err == nil && len(cachedPrices) > 0 {
-		logrus.Debug("Returning prices from cache")
-		return cachedPrices, nil
+	for {
+		if err := ps.Refresh(ctx); err != nil && ctx.Err() == nil {
+			logrus.Errorf("Failed to refresh prices: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
+}
 
-	// If cache miss, fetch from API
-	logrus.Info("Fetching prices from CoinGecko API")
-	prices, err := ps.fetchPricesFromAPI(ctx)
+// Refresh fetches prices from the sources and publishes them as the latest
+// snapshot, in memory, Redis and the database
+func (ps *PriceService) Refresh(ctx context.Context) error {
+	aggregated, err := ps.FetchPrices(ctx)
 	if err != nil {
-		logrus.Errorf("Failed to fetch prices from API: %v", err)
-		// Try to get from database as fallback
-		return ps.getPricesFromDB(ctx)
+		return err
+	}
+
+	snapshot := &PriceSnapshot{
+		Prices:    make(map[string]float64, len(aggregated)),
+		Sources:   make(map[string][]string, len(aggregated)),
+		FetchedAt: time.Now(),
+	}
+	for symbol, price := range aggregated {
+		snapshot.Prices[symbol] = price.PriceUSD
+		snapshot.Sources[symbol] = price.Sources
 	}
+	ps.publish(snapshot)
 
 	// Cache the prices
-	if err := ps.cachePrices(ctx, prices); err != nil {
+	if err := ps.cacheSnapshot(ctx, snapshot); err != nil {
 		logrus.Warnf("Failed to cache prices: %v", err)
 	}
 
 	// Store in database
-	if err := ps.storePricesInDB(ctx, prices); err != nil {
+	if err := ps.storePricesInDB(ctx, aggregated, snapshot.FetchedAt); err != nil {
 		logrus.Warnf("Failed to store prices in database: %v", err)
 	}
 
-	return prices, nil
+	logrus.Debugf("Refreshed prices of %d currencies", len(snapshot.Prices))
+	return nil
 }
 
-// fetchPricesFromAPI fetches prices from CoinGecko API
-func (ps *PriceService) fetchPricesFromAPI(ctx context.Context) (map[string]float64, error) {
-	currencies := []string{"bitcoin", "ethereum", "tether", "usd-coin", "cardano", "solana", "polygon"}
-	url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/price?ids=%s&vs_currencies=usd", 
-		strings.Join(currencies, ","))
+// publish makes snapshot the latest one unless a newer one is known
+func (ps *PriceService) publish(snapshot *PriceSnapshot) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.snapshot == nil || snapshot.FetchedAt.After(ps.snapshot.FetchedAt) {
+		ps.snapshot = snapshot
+	}
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// Snapshot returns the latest price snapshot. The one in memory is used
+// while it is fresh; otherwise the one cached by any replica in Redis, and
+// finally the prices stored in the database. ErrStalePrices is returned
+// when the latest snapshot is older than the staleness bound.
+func (ps *PriceService) Snapshot(ctx context.Context) (*PriceSnapshot, error) {
+	ps.mu.RLock()
+	snapshot := ps.snapshot
+	ps.mu.RUnlock()
+
+	if snapshot == nil || snapshot.Age() > ps.opts.RefreshInterval {
+		if cached, err := ps.getSnapshotFromCache(ctx); err == nil {
+			ps.publish(cached)
+		} else if stored, err := ps.getSnapshotFromDB(ctx); err == nil {
+			ps.publish(stored)
+		}
+
+		ps.mu.RLock()
+		snapshot = ps.snapshot
+		ps.mu.RUnlock()
 	}
 
-	if ps.apiKey != "" {
-		req.Header.Set("X-CG-Demo-API-Key", ps.apiKey)
+	if snapshot == nil {
+		return nil, fmt.Errorf("%w: no prices have been fetched", ErrStalePrices)
 	}
+	if age := snapshot.Age(); age > ps.opts.MaxAge {
+		return nil, fmt.Errorf("%w: last fetched %s ago", ErrStalePrices, age.Round(time.Second))
+	}
+	return snapshot, nil
+}
 
-	resp, err := ps.httpClient.Do(req)
+// GetPrices returns the USD prices of the latest snapshot
+func (ps *PriceService) GetPrices(ctx context.Context) (map[string]float64, error) {
+	snapshot, err := ps.Snapshot(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status: %d", resp.StatusCode)
+	prices := make(map[string]float64, len(snapshot.Prices))
+	for symbol, price := range snapshot.Prices {
+		prices[symbol] = price
 	}
+	return prices, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+// FetchPrices fetches prices from all sources concurrently and aggregates
+// them. Sources that fail are skipped; it is an error when no currency is
+// agreed on by enough sources.
+func (ps *PriceService) FetchPrices(ctx context.Context) (map[string]AggregatedPrice, error) {
+	type sourcePrices struct {
+		name   string
+		prices map[string]float64
+		err    error
 	}
 
-	var response CoinGeckoResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	results := make(chan sourcePrices, len(ps.opts.Sources))
+	for _, source := range ps.opts.Sources {
+		go func(source PriceSource) {
+			prices, err := source.FetchPrices(ctx, priceSymbols)
+			results <- sourcePrices{name: source.Name(), prices: prices, err: err}
+		}(source)
 	}
 
-	// Convert to our format
-	prices := make(map[string]float64)
-	mapping := map[string]string{
-		"bitcoin":  "BTC",
-		"ethereum": "ETH",
-		"tether":   "USDT",
-		"usd-coin": "USDC",
-		"cardano":  "ADA",
-		"solana":   "SOL",
-		"polygon":  "MATIC",
+	quotes := make(map[string]map[string]float64)
+	for range ps.opts.Sources {
+		result := <-results
+		if result.err != nil {
+			logrus.Warnf("Failed to fetch prices from %s: %v", result.name, result.err)
+			continue
+		}
+		for symbol, price := range result.prices {
+			if quotes[symbol] == nil {
+				quotes[symbol] = make(map[string]float64)
+			}
+			quotes[symbol][result.name] = price
+		}
 	}
 
-	for apiName, symbol := range mapping {
-		if priceData, exists := response[apiName]; exists {
-			if usdPrice, exists := priceData["usd"]; exists {
-				prices[symbol] = usdPrice
-			}
+	aggregated := make(map[string]AggregatedPrice)
+	for _, symbol := range priceSymbols {
+		price, err := AggregatePrice(quotes[symbol], ps.opts.MaxDeviationBps, ps.opts.MinSources)
+		if err != nil {
+			logrus.Warnf("No price for %s: %v", symbol, err)
+			continue
 		}
+		aggregated[symbol] = price
 	}
 
-	return prices, nil
+	if len(aggregated) == 0 {
+		return nil, fmt.Errorf("no price agreed on by %d sources", ps.opts.MinSources)
+	}
+	return aggregated, nil
 }
 
-// getPricesFromCache retrieves prices from Redis cache
-func (ps *PriceService) getPricesFromCache(ctx context.Context) (map[string]float64, error) {
-	prices := make(map[string]float64)
-	currencies := []string{"BTC", "ETH", "USDT", "USDC", "ADA", "SOL", "MATIC"}
+// AggregatePrice aggregates the prices of one currency by source: sources
+// more than maxDeviationBps basis points from the median are dropped, and
+// the median of the rest is the price if at least minSources remain
+func AggregatePrice(quotes map[string]float64, maxDeviationBps int64, minSources int) (AggregatedPrice, error) {
+	prices := make([]float64, 0, len(quotes))
+	for _, price := range quotes {
+		prices = append(prices, price)
+	}
+	if len(prices) == 0 {
+		return AggregatedPrice{}, fmt.Errorf("no source quotes it")
+	}
+	center := median(prices)
 
-	for _, currency := range currencies {
-		key := fmt.Sprintf("price:%s", currency)
-		priceStr, err := ps.redis.Get(ctx, key).Result()
-		if err != nil {
-			return nil, err
-		}
-		
-		var price float64
-		if err := json.Unmarshal([]byte(priceStr), &price); err != nil {
+	var agreed []float64
+	var sources []string
+	for name, price := range quotes {
+		if math.Abs(price-center)*10000 > center*float64(maxDeviationBps) {
+			logrus.Warnf("Dropping price %v from %s, more than %d bps from the median %v", price, name, maxDeviationBps, center)
 			continue
 		}
-		
-		prices[currency] = price
+		agreed = append(agreed, price)
+		sources = append(sources, name)
 	}
 
-	return prices, nil
+	if len(agreed) < minSources {
+		return AggregatedPrice{}, fmt.Errorf("only %d of %d sources agree, %d required", len(agreed), len(quotes), minSources)
+	}
+
+	sort.Strings(sources)
+	return AggregatedPrice{PriceUSD: median(agreed), Sources: sources}, nil
 }
 
-// cachePrices stores prices in Redis cache
-func (ps *PriceService) cachePrices(ctx context.Context, prices map[string]float64) error {
-	pipe := ps.redis.Pipeline()
-	
-	for currency, price := range prices {
-		key := fmt.Sprintf("price:%s", currency)
-		priceBytes, _ := json.Marshal(price)
-		pipe.Set(ctx, key, priceBytes, ps.cacheExpiry)
+// median returns the median of a non-empty list of values
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
 	}
-	
-	_, err := pipe.Exec(ctx)
-	return err
+	return sorted[middle]
 }
 
-// storePricesInDB stores prices in the database
-func (ps *PriceService) storePricesInDB(ctx context.Context, prices map[string]float64) error {
+// getSnapshotFromCache retrieves the latest snapshot from Redis cache
+func (ps *PriceService) getSnapshotFromCache(ctx context.Context) (*PriceSnapshot, error) {
+	if ps.redis == nil {
+		return nil, fmt.Errorf("no price cache")
+	}
+
+	data, err := ps.redis.Get(ctx, priceSnapshotKey).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot PriceSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cached prices: %w", err)
+	}
+	if len(snapshot.Prices) == 0 {
+		return nil, fmt.Errorf("cached snapshot has no prices")
+	}
+	return &snapshot, nil
+}
+
+// cacheSnapshot stores a snapshot in Redis cache until it goes stale
+func (ps *PriceService) cacheSnapshot(ctx context.Context, snapshot *PriceSnapshot) error {
+	if ps.redis == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal prices: %w", err)
+	}
+	return ps.redis.Set(ctx, priceSnapshotKey, data, ps.opts.MaxAge).Err()
+}
+
+// storePricesInDB stores prices in the database along with the sources
+// that agreed on them, as the latest prices and as ticks of the history
+func (ps *PriceService) storePricesInDB(ctx context.Context, prices map[string]AggregatedPrice, fetchedAt time.Time) error {
+	if ps.db == nil {
+		return nil
+	}
+
 	for currency, price := range prices {
 		priceCache := models.PriceCache{
 			Currency:    currency,
-			PriceUSD:    price,
-			LastUpdated: time.Now(),
+			PriceUSD:    price.PriceUSD,
+			Sources:     models.StringList(price.Sources),
+			LastUpdated: fetchedAt,
 		}
 		
 		// Use UPSERT to update existing or create new
@@ -179,60 +401,106 @@ func (ps *PriceService) storePricesInDB(ctx context.Context, prices map[string]f
 			logrus.Errorf("Failed to save price for %s: %v", currency, err)
 		}
 	}
-	
+
+	// Keep every price as a tick of the price history
+	ticks := make([]models.PriceTick, 0, len(prices))
+	for currency, price := range prices {
+		ticks = append(ticks, models.PriceTick{
+			Currency:  currency,
+			PriceUSD:  price.PriceUSD,
+			Sources:   models.StringList(price.Sources),
+			FetchedAt: fetchedAt,
+		})
+	}
+	if err := ps.db.WithContext(ctx).Create(&ticks).Error; err != nil {
+		return fmt.Errorf("failed to store price ticks: %w", err)
+	}
+
 	return nil
 }
 
-// getPricesFromDB retrieves prices from database (fallback)
-func (ps *PriceService) getPricesFromDB(ctx context.Context) (map[string]float64, error) {
+// getSnapshotFromDB rebuilds the latest snapshot from the prices stored in
+// the database (fallback). Every refresh stores its prices with the same
+// time, prices no longer refreshed are left out.
+func (ps *PriceService) getSnapshotFromDB(ctx context.Context) (*PriceSnapshot, error) {
+	if ps.db == nil {
+		return nil, fmt.Errorf("no price database")
+	}
+
 	var priceCaches []models.PriceCache
-	if err := ps.db.WithContext(ctx).Find(&priceCaches).Error; err != nil {
+	latest := ps.db.Model(&models.PriceCache{}).Select("max(last_updated)")
+	if err := ps.db.WithContext(ctx).Where("last_updated = (?)", latest).Find(&priceCaches).Error; err != nil {
 		return nil, fmt.Errorf("failed to fetch prices from database: %w", err)
 	}
+	if len(priceCaches) == 0 {
+		return nil, fmt.Errorf("no prices stored")
+	}
 
-	prices := make(map[string]float64)
+	snapshot := &PriceSnapshot{
+		Prices:    make(map[string]float64, len(priceCaches)),
+		Sources:   make(map[string][]string, len(priceCaches)),
+		FetchedAt: priceCaches[0].LastUpdated,
+	}
 	for _, pc := range priceCaches {
-		prices[pc.Currency] = pc.PriceUSD
+		snapshot.Prices[pc.Currency] = pc.PriceUSD
+		snapshot.Sources[pc.Currency] = pc.Sources
 	}
 
-	return prices, nil
+	return snapshot, nil
 }
 
-// GetPrice gets the price for a specific currency
+// GetPrice gets the price for a specific currency, the price of its asset
 func (ps *PriceService) GetPrice(ctx context.Context, currency string) (float64, error) {
 	prices, err := ps.GetPrices(ctx)
 	if err != nil {
 		return 0, err
 	}
 	
-	price, exists := prices[currency]
+	return assetPrice(prices, currency)
+}
+
+// assetPrice looks up the price of a currency's asset in prices. Currencies
+// of one asset on different networks share its price.
+func assetPrice(prices map[string]float64, currency string) (float64, error) {
+	asset := currency
+	if entry, err := money.LookupCurrency(currency); err == nil {
+		asset = entry.Asset
+	}
+
+	price, exists := prices[asset]
 	if !exists {
 		return 0, fmt.Errorf("price not found for currency: %s", currency)
 	}
-	
 	return price, nil
 }
 
-// CalculateExchangeRate calculates the exchange rate between two currencies
-func (ps *PriceService) CalculateExchangeRate(ctx context.Context, fromCurrency, toCurrency string, amount float64) (float64, error) {
+// ConvertAmount converts an amount in base units of one currency to base
+// units of another at their current USD prices, rounding down
+func (ps *PriceService) ConvertAmount(ctx context.Context, fromCurrency, toCurrency string, amount money.Amount) (money.Amount, error) {
 	prices, err := ps.GetPrices(ctx)
 	if err != nil {
-		return 0, err
+		return money.Amount{}, err
 	}
-	
-	fromPrice, exists := prices[fromCurrency]
-	if !exists {
-		return 0, fmt.Errorf("price not found for currency: %s", fromCurrency)
+
+	fromPrice, err := assetPrice(prices, fromCurrency)
+	if err != nil {
+		return money.Amount{}, err
 	}
-	
-	toPrice, exists := prices[toCurrency]
-	if !exists {
-		return 0, fmt.Errorf("price not found for currency: %s", toCurrency)
+
+	toPrice, err := assetPrice(prices, toCurrency)
+	if err != nil {
+		return money.Amount{}, err
 	}
-	
+
+	fromDecimals, err := money.Decimals(fromCurrency)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	toDecimals, err := money.Decimals(toCurrency)
+	if err != nil {
+		return money.Amount{}, err
+	}
+
 	// Convert amount from fromCurrency to USD, then to toCurrency
-	usdValue := amount * fromPrice
-	result := usdValue / toPrice
-	
-	return result, nil
+	return money.Convert(amount, fromDecimals, toDecimals, fromPrice, toPrice)
 }
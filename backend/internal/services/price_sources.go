@@ -0,0 +1,381 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PriceSource fetches USD prices of currencies from one upstream
+type PriceSource interface {
+	// Name identifies the source in logs and in the recorded sources of
+	// aggregated prices
+	Name() string
+	// FetchPrices returns the USD prices of the symbols the source quotes.
+	// Symbols it does not quote are left out of the result.
+	FetchPrices(ctx context.Context, symbols []string) (map[string]float64, error)
+}
+
+// Price source names
+const (
+	PriceSourceCoinGecko = "coingecko"
+	PriceSourceKraken    = "kraken"
+	PriceSourceBinance   = "binance"
+	PriceSourceCoinbase  = "coinbase"
+)
+
+// PriceSourceFixtures selects the fixture-backed fakes of every price source
+// instead of live upstreams
+const PriceSourceFixtures = "fixtures"
+
+// NewPriceSources creates price sources by name. The CoinGecko API key is
+// optional. "fixtures" adds the fixture-backed fake of every source, which
+// serves fixed prices without network access.
+func NewPriceSources(names []string, coinGeckoAPIKey string) ([]PriceSource, error) {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	var sources []PriceSource
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+			continue
+		case PriceSourceCoinGecko:
+			sources = append(sources, NewCoinGeckoSource(httpClient, coinGeckoAPIKey))
+		case PriceSourceKraken:
+			sources = append(sources, NewKrakenSource(httpClient))
+		case PriceSourceBinance:
+			sources = append(sources, NewBinanceSource(httpClient))
+		case PriceSourceCoinbase:
+			sources = append(sources, NewCoinbaseSource(httpClient))
+		case PriceSourceFixtures:
+			fixtures, err := FixturePriceSources()
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, fixtures...)
+		default:
+			return nil, fmt.Errorf("unknown price source: %s", name)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("at least one price source is required")
+	}
+	return sources, nil
+}
+
+// getJSON fetches a URL and decodes its JSON response into v
+func getJSON(ctx context.Context, httpClient *http.Client, url string, header http.Header, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned status: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
+
+// parsePositive parses a decimal price string, which has to be positive
+func parsePositive(s string) (float64, bool) {
+	value, err := strconv.ParseFloat(s, 64)
+	return value, err == nil && value > 0
+}
+
+// CoinGeckoSource quotes USD prices from CoinGecko's simple price API
+type CoinGeckoSource struct {
+	httpClient *http.Client
+	baseURL    string
+	apiKey     string
+}
+
+// coinGeckoIDs maps symbols to CoinGecko coin IDs
+var coinGeckoIDs = map[string]string{
+	"BTC":   "bitcoin",
+	"ETH":   "ethereum",
+	"USDT":  "tether",
+	"USDC":  "usd-coin",
+	"ADA":   "cardano",
+	"SOL":   "solana",
+	"MATIC": "matic-network",
+}
+
+// NewCoinGeckoSource creates a CoinGecko price source, authenticated with a
+// demo API key when one is given
+func NewCoinGeckoSource(httpClient *http.Client, apiKey string) *CoinGeckoSource {
+	return &CoinGeckoSource{
+		httpClient: httpClient,
+		baseURL:    "https://api.coingecko.com/api/v3",
+		apiKey:     apiKey,
+	}
+}
+
+// Name returns the source name
+func (s *CoinGeckoSource) Name() string {
+	return PriceSourceCoinGecko
+}
+
+// FetchPrices fetches the prices of all symbols in one request
+func (s *CoinGeckoSource) FetchPrices(ctx context.Context, symbols []string) (map[string]float64, error) {
+	var ids []string
+	for _, symbol := range symbols {
+		if id, ok := coinGeckoIDs[symbol]; ok {
+			ids = append(ids, id)
+		}
+	}
+
+	header := http.Header{}
+	if s.apiKey != "" {
+		header.Set("X-CG-Demo-API-Key", s.apiKey)
+	}
+
+	var response map[string]map[string]float64
+	url := fmt.Sprintf("%s/simple/price?ids=%s&vs_currencies=usd", s.baseURL, strings.Join(ids, ","))
+	if err := getJSON(ctx, s.httpClient, url, header, &response); err != nil {
+		return nil, err
+	}
+
+	prices := make(map[string]float64)
+	for _, symbol := range symbols {
+		if price := response[coinGeckoIDs[symbol]]["usd"]; price > 0 {
+			prices[symbol] = price
+		}
+	}
+	return prices, nil
+}
+
+// KrakenSource quotes USD prices from the last trades of Kraken's public
+// ticker
+type KrakenSource struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// krakenPairs maps symbols to Kraken's names of their USD pairs
+var krakenPairs = map[string]string{
+	"BTC":   "XXBTZUSD",
+	"ETH":   "XETHZUSD",
+	"USDT":  "USDTZUSD",
+	"USDC":  "USDCUSD",
+	"ADA":   "ADAUSD",
+	"SOL":   "SOLUSD",
+	"MATIC": "MATICUSD",
+}
+
+// NewKrakenSource creates a Kraken price source
+func NewKrakenSource(httpClient *http.Client) *KrakenSource {
+	return &KrakenSource{
+		httpClient: httpClient,
+		baseURL:    "https://api.kraken.com/0/public",
+	}
+}
+
+// Name returns the source name
+func (s *KrakenSource) Name() string {
+	return PriceSourceKraken
+}
+
+// FetchPrices fetches the ticker of every pair, as a request for a pair
+// Kraken no longer lists would fail as a whole
+func (s *KrakenSource) FetchPrices(ctx context.Context, symbols []string) (map[string]float64, error) {
+	var response struct {
+		Error  []string `json:"error"`
+		Result map[string]struct {
+			// LastTrade is the price and volume of the last trade
+			LastTrade []string `json:"c"`
+		} `json:"result"`
+	}
+	if err := getJSON(ctx, s.httpClient, s.baseURL+"/Ticker", nil, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Error) > 0 {
+		return nil, fmt.Errorf("kraken returned errors: %s", strings.Join(response.Error, ", "))
+	}
+
+	prices := make(map[string]float64)
+	for _, symbol := range symbols {
+		ticker, ok := response.Result[krakenPairs[symbol]]
+		if !ok || len(ticker.LastTrade) == 0 {
+			continue
+		}
+		if price, ok := parsePositive(ticker.LastTrade[0]); ok {
+			prices[symbol] = price
+		}
+	}
+	return prices, nil
+}
+
+// BinanceSource quotes prices from Binance's public ticker. Binance has no
+// USD markets, USDT pairs stand in for USD, so USDT itself is not quoted.
+type BinanceSource struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewBinanceSource creates a Binance price source
+func NewBinanceSource(httpClient *http.Client) *BinanceSource {
+	return &BinanceSource{
+		httpClient: httpClient,
+		baseURL:    "https://api.binance.com/api/v3",
+	}
+}
+
+// Name returns the source name
+func (s *BinanceSource) Name() string {
+	return PriceSourceBinance
+}
+
+// FetchPrices fetches the ticker of every market, as a request for a market
+// Binance no longer lists would fail as a whole
+func (s *BinanceSource) FetchPrices(ctx context.Context, symbols []string) (map[string]float64, error) {
+	var response []struct {
+		Symbol string `json:"symbol"`
+		Price  string `json:"price"`
+	}
+	if err := getJSON(ctx, s.httpClient, s.baseURL+"/ticker/price", nil, &response); err != nil {
+		return nil, err
+	}
+
+	tickers := make(map[string]string, len(response))
+	for _, ticker := range response {
+		tickers[ticker.Symbol] = ticker.Price
+	}
+
+	prices := make(map[string]float64)
+	for _, symbol := range symbols {
+		if symbol == "USDT" {
+			continue
+		}
+		if price, ok := parsePositive(tickers[symbol+"USDT"]); ok {
+			prices[symbol] = price
+		}
+	}
+	return prices, nil
+}
+
+// CoinbaseSource quotes USD prices from Coinbase's exchange rates, which
+// give the amount of each currency one USD buys
+type CoinbaseSource struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewCoinbaseSource creates a Coinbase price source
+func NewCoinbaseSource(httpClient *http.Client) *CoinbaseSource {
+	return &CoinbaseSource{
+		httpClient: httpClient,
+		baseURL:    "https://api.coinbase.com/v2",
+	}
+}
+
+// Name returns the source name
+func (s *CoinbaseSource) Name() string {
+	return PriceSourceCoinbase
+}
+
+// FetchPrices fetches the USD exchange rates and inverts them into prices
+func (s *CoinbaseSource) FetchPrices(ctx context.Context, symbols []string) (map[string]float64, error) {
+	var response struct {
+		Data struct {
+			Currency string            `json:"currency"`
+			Rates    map[string]string `json:"rates"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, s.httpClient, s.baseURL+"/exchange-rates?currency=USD", nil, &response); err != nil {
+		return nil, err
+	}
+	if response.Data.Currency != "USD" {
+		return nil, fmt.Errorf("coinbase returned rates for %q", response.Data.Currency)
+	}
+
+	prices := make(map[string]float64)
+	for _, symbol := range symbols {
+		if rate, ok := parsePositive(response.Data.Rates[symbol]); ok {
+			prices[symbol] = 1 / rate
+		}
+	}
+	return prices, nil
+}
+
+//go:embed fixtures/prices/*.json
+var priceFixtures embed.FS
+
+// FixturePriceSources returns the fixture-backed fake of every price source,
+// serving the recorded responses in fixtures/prices
+func FixturePriceSources() ([]PriceSource, error) {
+	var sources []PriceSource
+	for _, name := range []string{PriceSourceCoinGecko, PriceSourceKraken, PriceSourceBinance, PriceSourceCoinbase} {
+		fixture, err := priceFixtures.ReadFile("fixtures/prices/" + name + ".json")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s fixture: %w", name, err)
+		}
+		source, err := NewFixturePriceSource(name, fixture)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// NewFixturePriceSource creates the named price source on top of an HTTP
+// client that answers every request with fixture, a response body in the
+// upstream's format, so the source's own parsing is exercised
+func NewFixturePriceSource(name string, fixture []byte) (PriceSource, error) {
+	httpClient := &http.Client{Transport: fixtureTransport{body: fixture}}
+
+	switch name {
+	case PriceSourceCoinGecko:
+		return NewCoinGeckoSource(httpClient, ""), nil
+	case PriceSourceKraken:
+		return NewKrakenSource(httpClient), nil
+	case PriceSourceBinance:
+		return NewBinanceSource(httpClient), nil
+	case PriceSourceCoinbase:
+		return NewCoinbaseSource(httpClient), nil
+	default:
+		return nil, fmt.Errorf("unknown price source: %s", name)
+	}
+}
+
+// fixtureTransport answers every HTTP request with a fixed JSON body
+type fixtureTransport struct {
+	body []byte
+}
+
+// RoundTrip implements http.RoundTripper
+func (t fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(t.body)),
+		Request:    req,
+	}, nil
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/crypto"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrPSBTNotFound is returned when no PSBT has the requested id
+var ErrPSBTNotFound = errors.New("psbt not found")
+
+// PSBTBroadcastHandler completes the bookkeeping of a PSBT once its signed
+// transaction has been broadcast
+type PSBTBroadcastHandler func(ctx context.Context, record *models.PSBT, txid string) error
+
+// PSBTService tracks transactions exported for offline signing. Unsigned
+// PSBTs are handed out to signers, and signed ones are finalized, verified
+// and broadcast, after which the handler registered for their kind runs.
+type PSBTService struct {
+	db       *gorm.DB
+	backend  crypto.ChainBackend
+	handlers map[string]PSBTBroadcastHandler
+}
+
+// NewPSBTService creates a new PSBT service
+func NewPSBTService(db *gorm.DB, backend crypto.ChainBackend) *PSBTService {
+	return &PSBTService{
+		db:       db,
+		backend:  backend,
+		handlers: make(map[string]PSBTBroadcastHandler),
+	}
+}
+
+// WithTx returns a copy of the PSBT service that runs its queries inside tx
+func (ps *PSBTService) WithTx(tx *gorm.DB) *PSBTService {
+	return &PSBTService{
+		db:       tx,
+		backend:  ps.backend,
+		handlers: ps.handlers,
+	}
+}
+
+// RegisterBroadcastHandler sets the handler run after a PSBT of kind is broadcast
+func (ps *PSBTService) RegisterBroadcastHandler(kind string, handler PSBTBroadcastHandler) {
+	ps.handlers[kind] = handler
+}
+
+// Create stores an unsigned PSBT, locking the outputs it spends
+func (ps *PSBTService) Create(ctx context.Context, record *models.PSBT, packet *psbt.Packet) error {
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode PSBT: %w", err)
+	}
+
+	record.Status = models.PSBTStatusUnsigned
+	record.UnsignedPSBT = encoded
+	record.Outpoints = nil
+	for _, txIn := range packet.UnsignedTx.TxIn {
+		record.Outpoints = append(record.Outpoints, txIn.PreviousOutPoint.String())
+	}
+
+	if err := ps.db.WithContext(ctx).Create(record).Error; err != nil {
+		return fmt.Errorf("failed to store PSBT: %w", err)
+	}
+
+	logrus.Infof("Exported %s PSBT %s spending %d outputs for offline signing", record.Kind, record.ID, len(record.Outpoints))
+	return nil
+}
+
+// Get gets a PSBT by id
+func (ps *PSBTService) Get(ctx context.Context, id uuid.UUID) (*models.PSBT, error) {
+	var record models.PSBT
+	if err := ps.db.WithContext(ctx).Where("id = ?", id).First(&record).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrPSBTNotFound
+		}
+		return nil, fmt.Errorf("failed to get PSBT: %w", err)
+	}
+
+	return &record, nil
+}
+
+// List lists PSBTs, newest first, optionally filtered by status
+func (ps *PSBTService) List(ctx context.Context, status string) ([]models.PSBT, error) {
+	query := ps.db.WithContext(ctx).Order("created_at DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var records []models.PSBT
+	if err := query.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to list PSBTs: %w", err)
+	}
+
+	return records, nil
+}
+
+// LockedOutpoints returns the outputs spent by PSBTs still waiting for a
+// signature, as txid:vout strings
+func (ps *PSBTService) LockedOutpoints(ctx context.Context) (map[string]bool, error) {
+	records, err := ps.List(ctx, models.PSBTStatusUnsigned)
+	if err != nil {
+		return nil, err
+	}
+
+	locked := make(map[string]bool)
+	for _, record := range records {
+		for _, outpoint := range record.Outpoints {
+			locked[outpoint] = true
+		}
+	}
+	return locked, nil
+}
+
+// Cancel abandons an unsigned PSBT and releases the outputs it spends
+func (ps *PSBTService) Cancel(ctx context.Context, id uuid.UUID) error {
+	result := ps.db.WithContext(ctx).Model(&models.PSBT{}).
+		Where("id = ? AND status = ?", id, models.PSBTStatusUnsigned).
+		Update("status", models.PSBTStatusCancelled)
+	if result.Error != nil {
+		return fmt.Errorf("failed to cancel PSBT: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		if _, err := ps.Get(ctx, id); err != nil {
+			return err
+		}
+		return fmt.Errorf("PSBT %s is no longer waiting for a signature", id)
+	}
+
+	logrus.Infof("Cancelled PSBT %s", id)
+	return nil
+}
+
+// Submit accepts a signed copy of an exported PSBT, in binary or base64
+// encoding. The PSBT must spend and pay exactly what was exported; it is
+// finalized, verified and broadcast through the chain backend.
+func (ps *PSBTService) Submit(ctx context.Context, id uuid.UUID, signed []byte) (*models.PSBT, error) {
+	record, err := ps.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != models.PSBTStatusUnsigned {
+		return nil, fmt.Errorf("PSBT %s is %s, not waiting for a signature", id, record.Status)
+	}
+
+	unsignedPacket, err := crypto.DecodePSBT([]byte(record.UnsignedPSBT))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode stored PSBT: %w", err)
+	}
+	signedPacket, err := crypto.DecodePSBT(signed)
+	if err != nil {
+		return nil, err
+	}
+	if signedPacket.UnsignedTx.TxHash() != unsignedPacket.UnsignedTx.TxHash() {
+		return nil, fmt.Errorf("signed PSBT does not match PSBT %s", id)
+	}
+
+	signedTx, err := crypto.FinalizePSBT(signedPacket)
+	if err != nil {
+		return nil, err
+	}
+	signedEncoded, err := signedPacket.B64Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode PSBT: %w", err)
+	}
+
+	// The row stays locked while broadcasting, so a cancellation either wins
+	// and stops the broadcast or waits for it and finds the PSBT broadcast
+	var txid string
+	alreadyBroadcast := false
+	now := time.Now()
+	err = ps.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked models.PSBT
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", id).
+			First(&locked).Error; err != nil {
+			return fmt.Errorf("failed to lock PSBT %s: %w", id, err)
+		}
+		switch locked.Status {
+		case models.PSBTStatusUnsigned:
+		case models.PSBTStatusBroadcast:
+			// A concurrent submission got there first and ran the handler
+			alreadyBroadcast = true
+			return nil
+		default:
+			return fmt.Errorf("PSBT %s is %s, not waiting for a signature", id, locked.Status)
+		}
+
+		var err error
+		txid, err = ps.backend.BroadcastTransaction(ctx, signedTx)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Broadcast %s PSBT %s as transaction %s", record.Kind, id, txid)
+
+		if err := tx.Model(&models.PSBT{}).
+			Where("id = ?", id).
+			Updates(map[string]interface{}{
+				"status":       models.PSBTStatusBroadcast,
+				"signed_psbt":  signedEncoded,
+				"txid":         txid,
+				"broadcast_at": now,
+			}).Error; err != nil {
+			return fmt.Errorf("failed to record broadcast of PSBT %s: %w", id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if alreadyBroadcast {
+		return ps.Get(ctx, id)
+	}
+
+	record.Status = models.PSBTStatusBroadcast
+	record.SignedPSBT = signedEncoded
+	record.TXID = txid
+	record.BroadcastAt = &now
+
+	if handler, ok := ps.handlers[record.Kind]; ok {
+		if err := handler(ctx, record, txid); err != nil {
+			logrus.Errorf("Failed to complete %s PSBT %s: %v", record.Kind, id, err)
+		}
+	}
+
+	return record, nil
+}
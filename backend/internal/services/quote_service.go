@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/money"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrQuoteSlippage marks a payment that arrived after its quote expired, when
+// the rate had moved against the quote by more than its slippage allowance
+var ErrQuoteSlippage = errors.New("rate moved beyond the quote's slippage allowance")
+
+// QuoteOptions configures a QuoteService
+type QuoteOptions struct {
+	// TTL is how long a quoted rate is locked
+	TTL time.Duration
+	// MaxSlippageBps is how far, in basis points, the output of a payment
+	// arriving after its quote expired may fall below the quoted output
+	MaxSlippageBps int64
+	// SigningKey authenticates quote IDs handed out to clients
+	SigningKey []byte
+}
+
+// QuoteService issues exchange quotes with a locked rate. Clients receive a
+// signed quote ID, the quote's UUID followed by an HMAC over its terms, so
+// forged or altered IDs are refused before they are looked up.
+type QuoteService struct {
+	db           *gorm.DB
+	priceService *PriceService
+	feeService   *FeeService
+	opts         QuoteOptions
+}
+
+// NewQuoteService creates a new quote service
+func NewQuoteService(db *gorm.DB, priceService *PriceService, feeService *FeeService, opts QuoteOptions) (*QuoteService, error) {
+	if len(opts.SigningKey) == 0 {
+		return nil, fmt.Errorf("quote signing key is required")
+	}
+	if opts.MaxSlippageBps < 0 || opts.MaxSlippageBps >= 10000 {
+		return nil, fmt.Errorf("invalid quote slippage: %d bps", opts.MaxSlippageBps)
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 10 * time.Minute
+	}
+
+	return &QuoteService{
+		db:           db,
+		priceService: priceService,
+		feeService:   feeService,
+		opts:         opts,
+	}, nil
+}
+
+// DeriveQuoteSigningKey derives a quote signing key from the wallet master
+// key, for deployments that do not configure one
+func DeriveQuoteSigningKey(masterKey string) []byte {
+	mac := hmac.New(sha256.New, []byte(masterKey))
+	mac.Write([]byte("quote-signing"))
+	return mac.Sum(nil)
+}
+
+// Issue quotes the exchange of btcAmountSats into currency for the given
+// output addresses, locking the current rate, and returns the quote with
+// its signed ID. Inputs are expected to be validated by the caller.
+func (qs *QuoteService) Issue(ctx context.Context, btcAmountSats int64, currency string, outputs []models.OutputAddress) (*models.Quote, string, error) {
+	feeBreakdown, err := qs.feeService.Quote(ctx, btcAmountSats, currency, outputs)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to quote fees: %w", err)
+	}
+
+	netSats := btcAmountSats - feeBreakdown.TotalSats
+	if netSats <= 0 {
+		return nil, "", fmt.Errorf("amount of %d sats does not cover the %d sats fee", btcAmountSats, feeBreakdown.TotalSats)
+	}
+
+	// The rate and the output are taken from one price snapshot
+	rate := money.NewAmount(100000000)
+	outputAmount := money.NewAmount(netSats)
+	if currency != "BTC" {
+		prices, err := qs.priceService.GetPrices(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get prices: %w", err)
+		}
+		decimals, err := money.Decimals(currency)
+		if err != nil {
+			return nil, "", err
+		}
+		btcPrice, err := assetPrice(prices, "BTC")
+		if err != nil {
+			return nil, "", err
+		}
+		outputPrice, err := assetPrice(prices, currency)
+		if err != nil {
+			return nil, "", err
+		}
+		if rate, err = money.Convert(rate, 8, decimals, btcPrice, outputPrice); err != nil {
+			return nil, "", fmt.Errorf("failed to calculate exchange rate: %w", err)
+		}
+		if outputAmount, err = money.Convert(outputAmount, 8, decimals, btcPrice, outputPrice); err != nil {
+			return nil, "", fmt.Errorf("failed to calculate exchange rate: %w", err)
+		}
+	}
+	if err := checkOutputLimits(currency, outputAmount); err != nil {
+		return nil, "", err
+	}
+
+	quote := &models.Quote{
+		ID:              uuid.New(),
+		BTCAmountSats:   btcAmountSats,
+		OutputCurrency:  currency,
+		OutputAddresses: models.OutputAddresses(outputs),
+		FeeBreakdown:    feeBreakdown,
+		Rate:            rate,
+		OutputAmount:    outputAmount,
+		SlippageBps:     qs.opts.MaxSlippageBps,
+		ExpiresAt:       time.Now().Add(qs.opts.TTL).Truncate(time.Second),
+	}
+	if err := qs.db.WithContext(ctx).Create(quote).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to store quote: %w", err)
+	}
+
+	return quote, SignQuote(qs.opts.SigningKey, quote), nil
+}
+
+// Claim looks up the quote of a signed quote ID for a new transaction. The
+// quote must be unexpired and unused; tx is the database transaction the
+// exchange transaction is created in, whose unique quote ID makes sure a
+// quote is only ever used once.
+func (qs *QuoteService) Claim(ctx context.Context, tx *gorm.DB, signedID string) (*models.Quote, error) {
+	id, err := parseQuoteID(signedID)
+	if err != nil {
+		return nil, err
+	}
+
+	var quote models.Quote
+	if err := tx.WithContext(ctx).Where("id = ?", id).First(&quote).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("quote not found")
+		}
+		return nil, fmt.Errorf("failed to get quote: %w", err)
+	}
+
+	if !hmac.Equal([]byte(SignQuote(qs.opts.SigningKey, &quote)), []byte(signedID)) {
+		return nil, fmt.Errorf("invalid quote signature")
+	}
+	if time.Now().After(quote.ExpiresAt) {
+		return nil, fmt.Errorf("quote expired at %s", quote.ExpiresAt.Format(time.RFC3339))
+	}
+
+	var used int64
+	if err := tx.WithContext(ctx).Model(&models.Transaction{}).Where("quote_id = ?", quote.ID).Count(&used).Error; err != nil {
+		return nil, fmt.Errorf("failed to check quote: %w", err)
+	}
+	if used > 0 {
+		return nil, fmt.Errorf("quote has already been used")
+	}
+
+	return &quote, nil
+}
+
+// SignQuote returns the signed ID of a quote: its UUID and an HMAC-SHA256,
+// keyed with key, over the terms the quote locks
+func SignQuote(key []byte, quote *models.Quote) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s|%d|%s|%s|%s|%d|%d", quote.ID, quote.BTCAmountSats, quote.OutputCurrency,
+		quote.Rate, quote.OutputAmount, quote.SlippageBps, quote.ExpiresAt.Unix())
+	return quote.ID.String() + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseQuoteID extracts the UUID of a signed quote ID
+func parseQuoteID(signedID string) (uuid.UUID, error) {
+	idPart, signature, ok := strings.Cut(signedID, ".")
+	if !ok || len(signature) != sha256.Size*2 {
+		return uuid.Nil, fmt.Errorf("invalid quote ID")
+	}
+	id, err := uuid.Parse(idPart)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid quote ID")
+	}
+	return id, nil
+}
+
+// QuotedOutput applies the slippage policy of a quote to a payment completed
+// at paidAt. Payments arriving before the quote expired receive the quoted
+// output. Later payments receive the output at the current rate, capped at
+// the quoted output; if that falls short of the quoted output by more than
+// the quote's slippage allowance, ErrQuoteSlippage is returned.
+func QuotedOutput(quote *models.Quote, paidAt time.Time, current money.Amount) (money.Amount, error) {
+	if !paidAt.After(quote.ExpiresAt) {
+		return quote.OutputAmount, nil
+	}
+
+	if current.Cmp(quote.OutputAmount) >= 0 {
+		return quote.OutputAmount, nil
+	}
+
+	minimum := quote.OutputAmount.MulDiv(10000-quote.SlippageBps, 10000)
+	if current.Cmp(minimum) < 0 {
+		return money.Amount{}, fmt.Errorf("%w: %s is below the minimum of %s", ErrQuoteSlippage, current, minimum)
+	}
+	return current, nil
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/crypto"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ErrNothingToSweep is returned when no deposit is ready to be swept
+var ErrNothingToSweep = errors.New("no confirmed deposits to sweep")
+
+// sweepableStatuses are the transaction statuses whose deposits are swept
+// once final. Overpaid deposits are held for review and underpaid ones for
+// a refund, both stay at their address until an operator settles them.
+var sweepableStatuses = []string{
+	models.StatusCompleted,
+}
+
+// SweepOptions configures a SweepService
+type SweepOptions struct {
+	// Destination is a cold storage address, or an account-level xpub from
+	// which a fresh address is derived for every sweep
+	Destination string
+	// AddressType is the type of the addresses derived from a destination xpub
+	AddressType crypto.AddressType
+	// FeeRate is the fee rate in sat/vB
+	FeeRate int64
+	// MinConfirmations is how many confirmations a deposit needs to be swept
+	MinConfirmations int
+	// MaxInputs caps the number of inputs of a sweep transaction
+	MaxInputs int
+	// OfflineSigning exports every sweep as a PSBT instead of signing it here
+	OfflineSigning bool
+	// HotSignLimit is the largest sweep, in satoshis, signed with hot keys.
+	// Larger sweeps are exported as PSBTs; zero means no limit.
+	HotSignLimit int64
+}
+
+// SweepService consolidates the deposits of settled transactions into cold
+// storage. Only wallets whose transaction has been finalized are swept, so
+// funds that may still be refunded or reorganized stay where they are.
+// Sweeps that must not be signed with hot keys are exported as PSBTs.
+type SweepService struct {
+	db            *gorm.DB
+	walletService *WalletService
+	psbtService   *PSBTService
+	backend       crypto.ChainBackend
+	netParams     *chaincfg.Params
+	opts          SweepOptions
+
+	// Exactly one of destination and coldKeychain is set
+	destination  btcutil.Address
+	coldKeychain *crypto.HDKeychain
+}
+
+// NewSweepService creates a new sweep service, parsing the destination as an
+// address first and as an extended public key otherwise
+func NewSweepService(db *gorm.DB, walletService *WalletService, psbtService *PSBTService, backend crypto.ChainBackend, netParams *chaincfg.Params, opts SweepOptions) (*SweepService, error) {
+	if opts.Destination == "" {
+		return nil, fmt.Errorf("sweep destination is required")
+	}
+	if opts.FeeRate <= 0 {
+		return nil, fmt.Errorf("sweep fee rate must be positive")
+	}
+	if opts.MinConfirmations < 1 {
+		opts.MinConfirmations = 1
+	}
+	if opts.MaxInputs <= 0 {
+		opts.MaxInputs = 200
+	}
+
+	ss := &SweepService{
+		db:            db,
+		walletService: walletService,
+		psbtService:   psbtService,
+		backend:       backend,
+		netParams:     netParams,
+		opts:          opts,
+	}
+	psbtService.RegisterBroadcastHandler(models.PSBTKindSweep, ss.completeSweepPSBT)
+
+	if address, err := btcutil.DecodeAddress(opts.Destination, netParams); err == nil {
+		if !address.IsForNet(netParams) {
+			return nil, fmt.Errorf("sweep destination is not for network %s", netParams.Name)
+		}
+		ss.destination = address
+		return ss, nil
+	}
+
+	keychain, err := crypto.NewHDKeychain(opts.Destination, opts.AddressType, netParams)
+	if err != nil {
+		return nil, fmt.Errorf("sweep destination is neither an address nor an xpub: %w", err)
+	}
+	ss.coldKeychain = keychain
+	return ss, nil
+}
+
+// SweepResult describes a sweep transaction
+type SweepResult struct {
+	TXID             string   `json:"txid,omitempty"`
+	Destination      string   `json:"destination"`
+	DestinationIndex *uint32  `json:"destination_index,omitempty"`
+	InputCount       int      `json:"input_count"`
+	AmountSats       int64    `json:"amount_sats"`
+	FeeSats          int64    `json:"fee_sats"`
+	Wallets          []string `json:"wallets"`
+	// PSBT is the base64 unsigned transaction of a dry run or an export
+	PSBT string `json:"psbt,omitempty"`
+	// PSBTID identifies a sweep exported for offline signing
+	PSBTID *uuid.UUID `json:"psbt_id,omitempty"`
+}
+
+// sweepCandidate is a wallet together with its sweepable inputs
+type sweepCandidate struct {
+	wallet models.Wallet
+	inputs []crypto.SweepInput
+	// complete is false when the wallet holds deposits too shallow to sweep
+	complete bool
+}
+
+// Sweep moves every sufficiently confirmed deposit of finalized transactions
+// to the cold storage destination in a single transaction, then deactivates
+// the wallets it emptied. A dry run returns the unsigned transaction as a
+// PSBT instead of signing and broadcasting it. Sweeps that need an offline
+// signature are stored as PSBTs and completed when the signed PSBT is
+// submitted.
+func (ss *SweepService) Sweep(ctx context.Context, dryRun bool) (*SweepResult, error) {
+	candidates, err := ss.collectInputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNothingToSweep
+	}
+
+	var inputs []crypto.SweepInput
+	for _, candidate := range candidates {
+		inputs = append(inputs, candidate.inputs...)
+	}
+
+	destination, destinationIndex, err := ss.destinationAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, fee, err := crypto.BuildSweepTx(inputs, destination, ss.opts.FeeRate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build sweep: %w", err)
+	}
+
+	result := &SweepResult{
+		Destination:      destination.EncodeAddress(),
+		DestinationIndex: destinationIndex,
+		InputCount:       len(inputs),
+		AmountSats:       tx.TxOut[0].Value,
+		FeeSats:          fee,
+	}
+	for _, candidate := range candidates {
+		result.Wallets = append(result.Wallets, candidate.wallet.Address)
+	}
+
+	offline := ss.opts.OfflineSigning || ss.walletService.IsWatchOnly() ||
+		(ss.opts.HotSignLimit > 0 && result.AmountSats > ss.opts.HotSignLimit)
+	if dryRun || offline {
+		packet, err := crypto.NewSweepPSBT(tx, inputs)
+		if err != nil {
+			return nil, err
+		}
+		result.PSBT, err = packet.B64Encode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode PSBT: %w", err)
+		}
+		if dryRun {
+			return result, nil
+		}
+
+		record := &models.PSBT{
+			Kind:             models.PSBTKindSweep,
+			Destination:      result.Destination,
+			DestinationIndex: destinationIndex,
+			AmountSats:       result.AmountSats,
+			FeeSats:          fee,
+		}
+		for _, candidate := range candidates {
+			if candidate.wallet.TransactionID != nil {
+				record.TransactionIDs = append(record.TransactionIDs, *candidate.wallet.TransactionID)
+			}
+			if candidate.complete {
+				record.SweptWallets = append(record.SweptWallets, candidate.wallet.Address)
+			}
+		}
+		if err := ss.psbtService.Create(ctx, record, packet); err != nil {
+			return nil, err
+		}
+		result.PSBTID = &record.ID
+		return result, nil
+	}
+
+	keys := make([]*btcec.PrivateKey, len(inputs))
+	walletKeys := make(map[string]*btcec.PrivateKey)
+	for i, input := range inputs {
+		key, ok := walletKeys[input.Address]
+		if !ok {
+			key, err = ss.walletService.GetPrivateKey(ctx, input.Address)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get key for %s: %w", input.Address, err)
+			}
+			walletKeys[input.Address] = key
+		}
+		keys[i] = key
+	}
+
+	if err := crypto.SignSweepTx(tx, inputs, keys); err != nil {
+		return nil, fmt.Errorf("failed to sign sweep: %w", err)
+	}
+
+	txid, err := ss.backend.BroadcastTransaction(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	result.TXID = txid
+	logrus.Infof("Broadcast sweep %s of %d inputs, %d sats to %s", txid, len(inputs), result.AmountSats, result.Destination)
+
+	var swept []string
+	for _, candidate := range candidates {
+		if candidate.complete {
+			swept = append(swept, candidate.wallet.Address)
+		}
+	}
+	ss.recordSweep(ctx, models.Sweep{
+		TXID:             txid,
+		Destination:      result.Destination,
+		DestinationIndex: destinationIndex,
+		InputCount:       result.InputCount,
+		AmountSats:       result.AmountSats,
+		FeeSats:          fee,
+		Status:           "broadcast",
+	}, swept)
+
+	return result, nil
+}
+
+// completeSweepPSBT records a sweep that was signed offline
+func (ss *SweepService) completeSweepPSBT(ctx context.Context, record *models.PSBT, txid string) error {
+	ss.recordSweep(ctx, models.Sweep{
+		TXID:             txid,
+		Destination:      record.Destination,
+		DestinationIndex: record.DestinationIndex,
+		InputCount:       len(record.Outpoints),
+		AmountSats:       record.AmountSats,
+		FeeSats:          record.FeeSats,
+		Status:           "broadcast",
+	}, record.SweptWallets)
+	return nil
+}
+
+// recordSweep stores a broadcast sweep and deactivates the wallets it emptied
+func (ss *SweepService) recordSweep(ctx context.Context, sweep models.Sweep, swept []string) {
+	if err := ss.db.WithContext(ctx).Create(&sweep).Error; err != nil {
+		logrus.Errorf("Failed to record sweep %s: %v", sweep.TXID, err)
+	}
+
+	// Emptied wallets no longer need watching or signing
+	for _, address := range swept {
+		if err := ss.walletService.DeactivateWallet(ctx, address); err != nil {
+			logrus.Errorf("Failed to deactivate swept wallet %s: %v", address, err)
+		}
+	}
+}
+
+// collectInputs gathers the sweepable deposits of active wallets whose
+// transaction completed and has been finalized, and the change of confirmed
+// payouts, up to MaxInputs. Wallets are never split
+// across sweeps unless one alone exceeds the limit, and outputs spent by a
+// PSBT awaiting its signature are left alone.
+func (ss *SweepService) collectInputs(ctx context.Context) ([]sweepCandidate, error) {
+	var wallets []models.Wallet
+	if err := ss.db.WithContext(ctx).
+		Joins("LEFT JOIN transactions ON transactions.id = wallets.transaction_id").
+		Where("wallets.is_active = ?", true).
+		Where(ss.db.
+			Where("wallets.role = ? AND transactions.status IN ? AND transactions.finalized_at IS NOT NULL",
+				models.WalletRoleDeposit, sweepableStatuses).
+			Or("wallets.role = ? AND EXISTS (SELECT 1 FROM payouts WHERE payouts.change_address = wallets.address AND payouts.status = ?)",
+				models.WalletRoleChange, models.PayoutStatusConfirmed)).
+		Order("wallets.created_at").
+		Find(&wallets).Error; err != nil {
+		return nil, fmt.Errorf("failed to list sweepable wallets: %w", err)
+	}
+
+	tipHeight, err := ss.backend.GetTipHeight(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tip height: %w", err)
+	}
+
+	locked, err := ss.psbtService.LockedOutpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []sweepCandidate
+	inputCount := 0
+	for _, wallet := range wallets {
+		utxos, err := ss.backend.GetAddressUTXOs(ctx, wallet.Address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get UTXOs of %s: %w", wallet.Address, err)
+		}
+
+		origin, err := ss.walletService.KeyOrigin(&wallet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get key origin of %s: %w", wallet.Address, err)
+		}
+
+		candidate := sweepCandidate{wallet: wallet, complete: true}
+		for _, utxo := range utxos {
+			if locked[fmt.Sprintf("%s:%d", utxo.TXID, utxo.Vout)] {
+				// Spent by a pending PSBT, which empties the wallet if it can
+				candidate.complete = false
+				continue
+			}
+			if !utxo.Status.Confirmed || crypto.Confirmations(tipHeight, utxo.Status.BlockHeight) < ss.opts.MinConfirmations {
+				candidate.complete = false
+				continue
+			}
+			input, err := crypto.NewSweepInput(utxo, wallet.Address, crypto.AddressType(wallet.AddressType), ss.netParams)
+			if err != nil {
+				return nil, err
+			}
+			input.Origin = origin
+			candidate.inputs = append(candidate.inputs, input)
+		}
+		if len(candidate.inputs) == 0 {
+			continue
+		}
+
+		if inputCount+len(candidate.inputs) > ss.opts.MaxInputs {
+			if inputCount > 0 {
+				// Leave the remaining wallets for the next sweep
+				break
+			}
+			candidate.inputs = candidate.inputs[:ss.opts.MaxInputs]
+			candidate.complete = false
+		}
+
+		candidates = append(candidates, candidate)
+		inputCount += len(candidate.inputs)
+	}
+
+	return candidates, nil
+}
+
+// destinationAddress returns the configured cold address, or the next unused
+// address of the cold xpub together with its derivation index
+func (ss *SweepService) destinationAddress(ctx context.Context) (btcutil.Address, *uint32, error) {
+	if ss.coldKeychain == nil {
+		return ss.destination, nil, nil
+	}
+
+	// Indexes handed to pending PSBTs are taken as well
+	var lastIndex sql.NullInt64
+	if err := ss.db.WithContext(ctx).Raw(`SELECT MAX(destination_index) FROM (
+		SELECT destination_index FROM sweeps
+		UNION ALL
+		SELECT destination_index FROM psbts WHERE kind = ? AND status = ?
+	) AS destination_indexes`, models.PSBTKindSweep, models.PSBTStatusUnsigned).
+		Scan(&lastIndex).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to get last destination index: %w", err)
+	}
+
+	index := uint32(0)
+	if lastIndex.Valid {
+		index = uint32(lastIndex.Int64) + 1
+	}
+
+	pubKey, err := ss.coldKeychain.DerivePublicKey(index)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive cold storage key: %w", err)
+	}
+	address, err := crypto.EncodeAddress(pubKey, ss.coldKeychain.AddressType(), ss.netParams)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create cold storage address: %w", err)
+	}
+
+	return address, &index, nil
+}
@@ -2,10 +2,13 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"hellomix-backend/internal/models"
 	"hellomix-backend/pkg/crypto"
+	"hellomix-backend/pkg/money"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -16,93 +19,217 @@ import (
 type TransactionService struct {
 	db               *gorm.DB
 	priceService     *PriceService
-	bitcoinService   *crypto.BitcoinService
+	walletService    *WalletService
+	feeService       *FeeService
+	quoteService     *QuoteService
 	validator        *crypto.AddressValidator
 	paymentProcessor *PaymentProcessor
+	paymentWatcher   *PaymentWatcher
 }
 
 // NewTransactionService creates a new transaction service
-func NewTransactionService(db *gorm.DB, priceService *PriceService, testnet bool) *TransactionService {
-	ts := &TransactionService{
-		db:             db,
-		priceService:   priceService,
-		bitcoinService: crypto.NewBitcoinService(testnet),
-		validator:      crypto.NewAddressValidator(),
+func NewTransactionService(db *gorm.DB, priceService *PriceService, walletService *WalletService, feeService *FeeService, quoteService *QuoteService, validator *crypto.AddressValidator, paymentProcessor *PaymentProcessor, paymentWatcher *PaymentWatcher) *TransactionService {
+	return &TransactionService{
+		db:               db,
+		priceService:     priceService,
+		walletService:    walletService,
+		feeService:       feeService,
+		quoteService:     quoteService,
+		validator:        validator,
+		paymentProcessor: paymentProcessor,
+		paymentWatcher:   paymentWatcher,
 	}
-	
-	// Create payment processor
-	ts.paymentProcessor = NewPaymentProcessor(db, priceService, testnet)
-	
-	return ts
 }
 
-// CreateTransactionRequest represents a request to create a new transaction
+// CreateQuoteRequest represents a request to quote an exchange
+type CreateQuoteRequest struct {
+	// BTCAmount is a decimal amount of BTC, accepted as a JSON string or
+	// number without losing precision
+	BTCAmount       json.Number            `json:"btc_amount" binding:"required"`
+	OutputCurrency  string                 `json:"output_currency" binding:"required"`
+	OutputAddresses []models.OutputAddress `json:"output_addresses" binding:"required,min=1,max=7"`
+}
+
+// CreateTransactionRequest represents a request to create a new transaction.
+// With a quote ID the exchange is taken from the quote, and the other fields
+// may be left out; if given they have to match the quote.
 type CreateTransactionRequest struct {
-	BTCAmount       float64                 `json:"btc_amount" binding:"required,gt=0"`
-	OutputCurrency  string                  `json:"output_currency" binding:"required"`
-	OutputAddresses []models.OutputAddress  `json:"output_addresses" binding:"required,min=1,max=7"`
+	// BTCAmount is a decimal amount of BTC, accepted as a JSON string or
+	// number without losing precision
+	BTCAmount       json.Number            `json:"btc_amount" binding:"required_without=QuoteID"`
+	OutputCurrency  string                 `json:"output_currency" binding:"required_without=QuoteID"`
+	OutputAddresses []models.OutputAddress `json:"output_addresses" binding:"required_without=QuoteID,max=7"`
+	QuoteID         string                 `json:"quote_id"`
 }
 
-// CreateTransaction creates a new exchange transaction
-func (ts *TransactionService) CreateTransaction(ctx context.Context, req *CreateTransactionRequest) (*models.Transaction, error) {
-	// Validate output currency
-	if !ts.isSupportedCurrency(req.OutputCurrency) {
-		return nil, fmt.Errorf("unsupported output currency: %s", req.OutputCurrency)
+// CreateQuote quotes an exchange, locking the current rate for a while
+func (ts *TransactionService) CreateQuote(ctx context.Context, req *CreateQuoteRequest) (*models.Quote, string, error) {
+	btcAmountSats, currency, err := ts.validateExchange(req.BTCAmount, req.OutputCurrency, req.OutputAddresses)
+	if err != nil {
+		return nil, "", err
 	}
 
-	// Validate output addresses
-	if err := ts.validateOutputAddresses(req.OutputAddresses, req.OutputCurrency); err != nil {
-		return nil, fmt.Errorf("invalid output addresses: %w", err)
+	quote, signedID, err := ts.quoteService.Issue(ctx, btcAmountSats, currency, req.OutputAddresses)
+	if err != nil {
+		return nil, "", err
 	}
 
-	// Validate percentage allocation
-	if err := ts.validatePercentageAllocation(req.OutputAddresses); err != nil {
-		return nil, fmt.Errorf("invalid percentage allocation: %w", err)
+	logrus.Infof("Issued quote %s for %d sats to %s, expiring at %s", quote.ID, btcAmountSats, currency, quote.ExpiresAt)
+	return quote, signedID, nil
+}
+
+// CreateTransaction creates a new exchange transaction
+func (ts *TransactionService) CreateTransaction(ctx context.Context, req *CreateTransactionRequest) (*models.Transaction, error) {
+	if req.QuoteID != "" {
+		return ts.createQuotedTransaction(ctx, req)
+	}
+
+	btcAmountSats, currency, err := ts.validateExchange(req.BTCAmount, req.OutputCurrency, req.OutputAddresses)
+	if err != nil {
+		return nil, err
 	}
 
-	// Generate payment address
-	paymentAddress, err := ts.bitcoinService.GenerateAddress()
+	// Quote the service fee, spread and projected network fees
+	feeBreakdown, err := ts.feeService.Quote(ctx, btcAmountSats, currency, req.OutputAddresses)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate payment address: %w", err)
+		return nil, fmt.Errorf("failed to quote fees: %w", err)
 	}
 
 	// Calculate estimated output
-	estimatedOutput, err := ts.calculateEstimatedOutput(ctx, req.BTCAmount, req.OutputCurrency)
+	estimatedOutput, err := ts.calculateEstimatedOutput(ctx, btcAmountSats, currency, feeBreakdown)
 	if err != nil {
 		return nil, fmt.Errorf("failed to calculate estimated output: %w", err)
 	}
-
-	// Calculate fee
-	fee := ts.calculateFee(req.BTCAmount, req.OutputCurrency)
+	if err := checkOutputLimits(currency, estimatedOutput); err != nil {
+		return nil, err
+	}
 
 	// Create transaction
+	expiresAt := time.Now().Add(PaymentWindow)
 	transaction := &models.Transaction{
 		ID:              uuid.New(),
-		BTCAmount:       req.BTCAmount,
-		OutputCurrency:  req.OutputCurrency,
+		BTCAmountSats:   btcAmountSats,
+		OutputCurrency:  currency,
 		OutputAddresses: models.OutputAddresses(req.OutputAddresses),
-		PaymentAddress:  paymentAddress,
 		Status:          models.StatusPending,
-		Fee:             fee,
+		FeeSats:         feeBreakdown.TotalSats,
+		FeeBreakdown:    feeBreakdown,
 		EstimatedOutput: estimatedOutput,
+		ExpiresAt:       &expiresAt,
 	}
 
-	if err := ts.db.WithContext(ctx).Create(transaction).Error; err != nil {
-		return nil, fmt.Errorf("failed to create transaction: %w", err)
+	if err := ts.storeTransaction(ctx, transaction, nil); err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// createQuotedTransaction creates a transaction from a quote, which pays out
+// at the quote's locked rate
+func (ts *TransactionService) createQuotedTransaction(ctx context.Context, req *CreateTransactionRequest) (*models.Transaction, error) {
+	expiresAt := time.Now().Add(PaymentWindow)
+	transaction := &models.Transaction{
+		ID:        uuid.New(),
+		Status:    models.StatusPending,
+		ExpiresAt: &expiresAt,
+	}
+
+	err := ts.storeTransaction(ctx, transaction, func(tx *gorm.DB) error {
+		quote, err := ts.quoteService.Claim(ctx, tx, req.QuoteID)
+		if err != nil {
+			return err
+		}
+		if err := quoteMatches(quote, req); err != nil {
+			return err
+		}
+
+		transaction.BTCAmountSats = quote.BTCAmountSats
+		transaction.OutputCurrency = quote.OutputCurrency
+		transaction.OutputAddresses = quote.OutputAddresses
+		transaction.FeeSats = quote.FeeBreakdown.TotalSats
+		transaction.FeeBreakdown = quote.FeeBreakdown
+		transaction.EstimatedOutput = quote.OutputAmount
+		transaction.QuoteID = &quote.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// quoteMatches checks the exchange fields given along with a quote ID
+// against the quote
+func quoteMatches(quote *models.Quote, req *CreateTransactionRequest) error {
+	if req.BTCAmount != "" {
+		btcAmount, err := money.ParseCurrency(req.BTCAmount.String(), "BTC")
+		if err != nil {
+			return fmt.Errorf("invalid BTC amount: %w", err)
+		}
+		if btcAmount.Cmp(money.NewAmount(quote.BTCAmountSats)) != 0 {
+			return fmt.Errorf("BTC amount does not match the quote")
+		}
+	}
+	if req.OutputCurrency != "" {
+		currency, err := money.LookupCurrency(req.OutputCurrency)
+		if err != nil || currency.Code != quote.OutputCurrency {
+			return fmt.Errorf("output currency does not match the quote")
+		}
+	}
+	if len(req.OutputAddresses) > 0 {
+		if len(req.OutputAddresses) != len(quote.OutputAddresses) {
+			return fmt.Errorf("output addresses do not match the quote")
+		}
+		for i, output := range req.OutputAddresses {
+			if output != quote.OutputAddresses[i] {
+				return fmt.Errorf("output addresses do not match the quote")
+			}
+		}
+	}
+	return nil
+}
+
+// storeTransaction generates the payment address of a new transaction and
+// persists its key together with the transaction, so a deposit address
+// never exists without its key. prepare, if set, runs first in the same
+// database transaction.
+func (ts *TransactionService) storeTransaction(ctx context.Context, transaction *models.Transaction, prepare func(tx *gorm.DB) error) error {
+	err := ts.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if prepare != nil {
+			if err := prepare(tx); err != nil {
+				return err
+			}
+		}
+
+		wallet, err := ts.walletService.WithTx(tx).GenerateAddress(ctx, &transaction.ID)
+		if err != nil {
+			return fmt.Errorf("failed to generate payment address: %w", err)
+		}
+		transaction.PaymentAddress = wallet.Address
+
+		if err := tx.Create(transaction).Error; err != nil {
+			return fmt.Errorf("failed to create transaction: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	logrus.Infof("Created new transaction: %s", transaction.ID)
 	
-	// Start real Bitcoin payment monitoring
-	ts.paymentProcessor.StartPaymentMonitoring(transaction.ID)
+	// The payment watcher picks up the new pending transaction
+	ts.paymentWatcher.Wake()
 
-	return transaction, nil
+	return nil
 }
 
-// GetTransaction retrieves a transaction by ID
+// GetTransaction retrieves a transaction by ID together with its payouts
 func (ts *TransactionService) GetTransaction(ctx context.Context, id uuid.UUID) (*models.Transaction, error) {
 	var transaction models.Transaction
-	if err := ts.db.WithContext(ctx).Where("id = ?", id).First(&transaction).Error; err != nil {
+	if err := ts.db.WithContext(ctx).Preload("Payouts", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Where("id = ?", id).First(&transaction).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, fmt.Errorf("transaction not found")
 		}
@@ -130,15 +257,54 @@ func (ts *TransactionService) UpdateTransactionStatus(ctx context.Context, id uu
 	return nil
 }
 
-// isSupportedCurrency checks if the currency is supported
-func (ts *TransactionService) isSupportedCurrency(currency string) bool {
-	supportedCurrencies := []string{"BTC", "ETH", "USDT", "USDC", "ADA", "SOL", "MATIC"}
-	for _, supported := range supportedCurrencies {
-		if currency == supported {
-			return true
+// SupportedCurrencies returns the currencies exchanges can be paid out in,
+// sorted by code
+func (ts *TransactionService) SupportedCurrencies() []money.Currency {
+	var supported []money.Currency
+	for _, currency := range money.ListCurrencies() {
+		if ts.supportsOutput(currency) {
+			supported = append(supported, currency)
 		}
 	}
-	return false
+	return supported
+}
+
+// supportsOutput reports whether exchanges can be paid out in a currency:
+// its addresses can be validated and a payout can deliver it
+func (ts *TransactionService) supportsOutput(currency money.Currency) bool {
+	return currency.AddressFormat != "" && ts.paymentProcessor.CanPayOut(currency.Code)
+}
+
+// validateExchange validates the amount, currency and output addresses of
+// an exchange and returns the amount in satoshis and the currency's code in
+// the registry, which resolves aliases such as USDT to USDT-ERC20
+func (ts *TransactionService) validateExchange(amount json.Number, currency string, outputs []models.OutputAddress) (int64, string, error) {
+	btcAmount, err := money.ParseCurrency(amount.String(), "BTC")
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid BTC amount: %w", err)
+	}
+	btcAmountSats, ok := btcAmount.Int64()
+	if !ok || btcAmountSats <= 0 {
+		return 0, "", fmt.Errorf("invalid BTC amount: %s", amount)
+	}
+
+	// Validate output currency
+	entry, err := money.LookupCurrency(currency)
+	if err != nil || !ts.supportsOutput(entry) {
+		return 0, "", fmt.Errorf("unsupported output currency: %s", currency)
+	}
+
+	// Validate output addresses
+	if err := ts.validateOutputAddresses(outputs, entry.Code); err != nil {
+		return 0, "", fmt.Errorf("invalid output addresses: %w", err)
+	}
+
+	// Validate percentage allocation
+	if err := ts.validatePercentageAllocation(outputs); err != nil {
+		return 0, "", fmt.Errorf("invalid percentage allocation: %w", err)
+	}
+
+	return btcAmountSats, entry.Code, nil
 }
 
 // validateOutputAddresses validates the output addresses
@@ -156,8 +322,11 @@ func (ts *TransactionService) validateOutputAddresses(addresses []models.OutputA
 			return fmt.Errorf("address %d is empty", i+1)
 		}
 
-		if !ts.validator.ValidateAddress(addr.Address, currency) {
-			return fmt.Errorf("invalid address %d for currency %s", i+1, currency)
+		if err := ts.validator.Validate(addr.Address, currency); err != nil {
+			return fmt.Errorf("invalid address %d for currency %s: %w", i+1, currency, err)
+		}
+		if err := ts.validator.ValidateMemo(addr.Memo, currency); err != nil {
+			return fmt.Errorf("invalid memo of address %d for currency %s: %w", i+1, currency, err)
 		}
 
 		if addr.Percentage <= 0 || addr.Percentage > 100 {
@@ -182,43 +351,33 @@ func (ts *TransactionService) validatePercentageAllocation(addresses []models.Ou
 	return nil
 }
 
-// calculateEstimatedOutput calculates the estimated output amount
-func (ts *TransactionService) calculateEstimatedOutput(ctx context.Context, btcAmount float64, outputCurrency string) (float64, error) {
-	if outputCurrency == "BTC" {
-		return btcAmount, nil
-	}
-
-	// Get exchange rate
-	outputAmount, err := ts.priceService.CalculateExchangeRate(ctx, "BTC", outputCurrency, btcAmount)
-	if err != nil {
-		return 0, err
+// calculateEstimatedOutput calculates the estimated output amount after
+// the quoted fees
+func (ts *TransactionService) calculateEstimatedOutput(ctx context.Context, btcAmountSats int64, outputCurrency string, feeBreakdown *models.FeeBreakdown) (money.Amount, error) {
+	netSats := btcAmountSats - feeBreakdown.TotalSats
+	if netSats <= 0 {
+		return money.Amount{}, fmt.Errorf("amount of %d sats does not cover the %d sats fee", btcAmountSats, feeBreakdown.TotalSats)
 	}
 
-	// Subtract fee
-	fee := ts.calculateFee(btcAmount, outputCurrency)
-	
-	// Convert fee to output currency
-	feeInOutputCurrency, err := ts.priceService.CalculateExchangeRate(ctx, "BTC", outputCurrency, fee)
-	if err != nil {
-		return 0, err
+	if outputCurrency == "BTC" {
+		return money.NewAmount(netSats), nil
 	}
 
-	return outputAmount - feeInOutputCurrency, nil
+	return ts.priceService.ConvertAmount(ctx, "BTC", outputCurrency, money.NewAmount(netSats))
 }
 
-// calculateFee calculates the transaction fee
-func (ts *TransactionService) calculateFee(btcAmount float64, currency string) float64 {
-	// Fee structure: 0.5% for most currencies, 0.2% for BTC
-	feeRate := 0.005 // 0.5%
-	if currency == "BTC" {
-		feeRate = 0.002 // 0.2%
+// checkOutputLimits checks the output of an exchange against the limits of
+// its currency in the registry
+func checkOutputLimits(currency string, outputAmount money.Amount) error {
+	entry, err := money.LookupCurrency(currency)
+	if err != nil {
+		return err
 	}
-
-	return btcAmount * feeRate
+	return entry.CheckAmount(outputAmount)
 }
 
 // GetPaymentStatus gets the current payment status for a transaction
-func (ts *TransactionService) GetPaymentStatus(ctx context.Context, id uuid.UUID) (*crypto.PaymentStatus, error) {
+func (ts *TransactionService) GetPaymentStatus(ctx context.Context, id uuid.UUID) (*PaymentReport, error) {
 	return ts.paymentProcessor.GetPaymentStatus(ctx, id)
 }
 
@@ -6,11 +6,13 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"fmt"
 	"io"
 
 	"hellomix-backend/internal/models"
+	"hellomix-backend/pkg/crypto"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/google/uuid"
@@ -18,21 +20,129 @@ import (
 	"gorm.io/gorm"
 )
 
+// walletIndexLockKey is the advisory lock serialising derivation index allocation
+const walletIndexLockKey = 0x68656c6c6f6d6978 // "hellomix"
+
 // WalletService handles secure wallet operations
 type WalletService struct {
-	db         *gorm.DB
-	encryptKey []byte
+	db            *gorm.DB
+	encryptKey    []byte
+	walletManager *crypto.WalletManager
 }
 
 // NewWalletService creates a new wallet service
-func NewWalletService(db *gorm.DB, masterKey string) *WalletService {
+func NewWalletService(db *gorm.DB, masterKey string, walletManager *crypto.WalletManager) *WalletService {
 	// Generate encryption key from master key
 	hash := sha256.Sum256([]byte(masterKey))
 	
 	return &WalletService{
-		db:         db,
-		encryptKey: hash[:],
+		db:            db,
+		encryptKey:    hash[:],
+		walletManager: walletManager,
+	}
+}
+
+// WithTx returns a copy of the wallet service that runs its queries inside tx
+func (ws *WalletService) WithTx(tx *gorm.DB) *WalletService {
+	return &WalletService{
+		db:            tx,
+		encryptKey:    ws.encryptKey,
+		walletManager: ws.walletManager,
+	}
+}
+
+// GenerateAddress derives the next deposit address from the HD keychain and
+// records its derivation index and address type
+func (ws *WalletService) GenerateAddress(ctx context.Context, transactionID *uuid.UUID) (*models.Wallet, error) {
+	var wallet *models.Wallet
+	err := ws.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		wallet, err = ws.nextWallet(ctx, tx, models.WalletRoleDeposit)
+		if err != nil {
+			return err
+		}
+		wallet.TransactionID = transactionID
+
+		if err := tx.Create(wallet).Error; err != nil {
+			return fmt.Errorf("failed to store wallet: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+
+	return wallet, nil
+}
+
+// DeriveChangeWallet derives the next address of the HD keychain as the
+// change wallet of a payout, without storing it. It must run in a database
+// transaction (see WithTx), which keeps the index reserved until the caller
+// stores the wallet once its payout turns out to need change.
+func (ws *WalletService) DeriveChangeWallet(ctx context.Context) (*models.Wallet, error) {
+	return ws.nextWallet(ctx, ws.db.WithContext(ctx), models.WalletRoleChange)
+}
+
+// nextWallet locks index allocation for the rest of tx and derives the
+// wallet at the next derivation index of the keychain
+func (ws *WalletService) nextWallet(ctx context.Context, tx *gorm.DB, role string) (*models.Wallet, error) {
+	keychain := ws.walletManager.Keychain()
+	fingerprint := keychain.Fingerprint()
+	addressType := string(ws.walletManager.AddressType())
+
+	// Serialise index allocation across concurrent requests and replicas
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", walletIndexLockKey).Error; err != nil {
+		return nil, fmt.Errorf("failed to lock derivation index: %w", err)
+	}
+
+	var lastIndex sql.NullInt64
+	if err := tx.Model(&models.Wallet{}).
+		Where("keychain_fingerprint = ? AND address_type = ?", fingerprint, addressType).
+		Select("MAX(derivation_index)").
+		Scan(&lastIndex).Error; err != nil {
+		return nil, fmt.Errorf("failed to get last derivation index: %w", err)
+	}
+
+	index := uint32(0)
+	if lastIndex.Valid {
+		index = uint32(lastIndex.Int64) + 1
+	}
+
+	address, err := ws.walletManager.DeriveAddress(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Wallet{
+		ID:                  uuid.New(),
+		Address:             address,
+		AddressType:         addressType,
+		KeychainFingerprint: fingerprint,
+		DerivationIndex:     &index,
+		DerivationPath:      keychain.DerivationPath(index),
+		Role:                role,
+		IsActive:            true,
+	}, nil
+}
+
+// DeriveAddress re-derives the deposit address at a recorded index
+func (ws *WalletService) DeriveAddress(index uint32) (string, error) {
+	return ws.walletManager.DeriveAddress(index)
+}
+
+// IsWatchOnly reports whether deposit keys can only be signed offline
+func (ws *WalletService) IsWatchOnly() bool {
+	return ws.walletManager.Keychain().IsWatchOnly()
+}
+
+// KeyOrigin returns the key origin of an HD wallet for offline signers. It
+// returns nil for legacy wallets and wallets of another keychain.
+func (ws *WalletService) KeyOrigin(wallet *models.Wallet) (*crypto.KeyOrigin, error) {
+	keychain := ws.walletManager.Keychain()
+	if wallet.DerivationIndex == nil || wallet.KeychainFingerprint != keychain.Fingerprint() {
+		return nil, nil
+	}
+	return keychain.KeyOrigin(*wallet.DerivationIndex)
 }
 
 // encrypt encrypts data using AES-GCM
@@ -82,8 +192,9 @@ func (ws *WalletService) decrypt(data []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
-// StorePrivateKey stores an encrypted private key for an address
-func (ws *WalletService) StorePrivateKey(ctx context.Context, address string, privateKey *btcec.PrivateKey, transactionID uuid.UUID) error {
+// StorePrivateKey stores an encrypted private key for an address that is not
+// derived from the HD keychain, e.g. an imported legacy key
+func (ws *WalletService) StorePrivateKey(ctx context.Context, address string, privateKey *btcec.PrivateKey, transactionID *uuid.UUID) error {
 	// Serialize private key
 	privateKeyBytes := privateKey.Serialize()
 	
@@ -97,8 +208,10 @@ func (ws *WalletService) StorePrivateKey(ctx context.Context, address string, pr
 	wallet := models.Wallet{
 		ID:               uuid.New(),
 		Address:          address,
+		AddressType:      string(crypto.AddressTypeP2PKH),
 		EncryptedPrivKey: hex.EncodeToString(encryptedKey),
-		TransactionID:    &transactionID,
+		TransactionID:    transactionID,
+		Role:             models.WalletRoleDeposit,
 		IsActive:         true,
 	}
 
@@ -120,6 +233,14 @@ func (ws *WalletService) GetPrivateKey(ctx context.Context, address string) (*bt
 		return nil, fmt.Errorf("failed to get wallet: %w", err)
 	}
 
+	// HD wallets are re-derived from the keychain
+	if wallet.DerivationIndex != nil {
+		if wallet.KeychainFingerprint != ws.walletManager.Keychain().Fingerprint() {
+			return nil, fmt.Errorf("wallet %s was derived from keychain %s which is not loaded", address, wallet.KeychainFingerprint)
+		}
+		return ws.walletManager.DerivePrivateKey(*wallet.DerivationIndex)
+	}
+
 	// Decode encrypted key
 	encryptedBytes, err := hex.DecodeString(wallet.EncryptedPrivKey)
 	if err != nil {
@@ -0,0 +1,99 @@
+package crypto
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// AddressType identifies a Bitcoin output script template
+type AddressType string
+
+// Address types. Deposit addresses can be any of P2PKH, P2SH-P2WPKH, P2WPKH
+// and P2TR; the remaining types are only reported by the validator.
+const (
+	AddressTypeP2PKH      AddressType = "p2pkh"
+	AddressTypeP2SHP2WPKH AddressType = "p2sh-p2wpkh"
+	AddressTypeP2WPKH     AddressType = "p2wpkh"
+	AddressTypeP2TR       AddressType = "p2tr"
+	AddressTypeP2SH       AddressType = "p2sh"
+	AddressTypeP2WSH      AddressType = "p2wsh"
+	AddressTypeP2PK       AddressType = "p2pk"
+)
+
+// ParseAddressType parses a deposit address type from configuration
+func ParseAddressType(s string) (AddressType, error) {
+	switch t := AddressType(s); t {
+	case AddressTypeP2PKH, AddressTypeP2SHP2WPKH, AddressTypeP2WPKH, AddressTypeP2TR:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unsupported deposit address type: %q", s)
+	}
+}
+
+// Purpose returns the BIP43 purpose used to derive accounts of this type
+func (t AddressType) Purpose() uint32 {
+	switch t {
+	case AddressTypeP2PKH:
+		return 44
+	case AddressTypeP2SHP2WPKH:
+		return 49
+	case AddressTypeP2TR:
+		return 86
+	default:
+		return BIP84Purpose
+	}
+}
+
+// EncodeAddress creates the address of the given type paying to pubKey
+func EncodeAddress(pubKey *btcec.PublicKey, addressType AddressType, netParams *chaincfg.Params) (btcutil.Address, error) {
+	pubKeyHash := btcutil.Hash160(pubKey.SerializeCompressed())
+
+	switch addressType {
+	case AddressTypeP2PKH:
+		return btcutil.NewAddressPubKeyHash(pubKeyHash, netParams)
+	case AddressTypeP2WPKH:
+		return btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, netParams)
+	case AddressTypeP2SHP2WPKH:
+		witnessProgram, err := txscript.NewScriptBuilder().
+			AddOp(txscript.OP_0).
+			AddData(pubKeyHash).
+			Script()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build witness program: %w", err)
+		}
+		return btcutil.NewAddressScriptHash(witnessProgram, netParams)
+	case AddressTypeP2TR:
+		// BIP86 key-path-only output key
+		outputKey := txscript.ComputeTaprootKeyNoScript(pubKey)
+		return btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), netParams)
+	default:
+		return nil, fmt.Errorf("unsupported deposit address type: %q", addressType)
+	}
+}
+
+// DetectAddressType reports the script template of a decoded address. Script
+// hash addresses are reported as p2sh since a wrapped SegWit address cannot be
+// told apart from any other P2SH address.
+func DetectAddressType(address btcutil.Address) AddressType {
+	switch address.(type) {
+	case *btcutil.AddressPubKeyHash:
+		return AddressTypeP2PKH
+	case *btcutil.AddressScriptHash:
+		return AddressTypeP2SH
+	case *btcutil.AddressWitnessPubKeyHash:
+		return AddressTypeP2WPKH
+	case *btcutil.AddressWitnessScriptHash:
+		return AddressTypeP2WSH
+	case *btcutil.AddressTaproot:
+		return AddressTypeP2TR
+	case *btcutil.AddressPubKey:
+		return AddressTypeP2PK
+	default:
+		return ""
+	}
+}
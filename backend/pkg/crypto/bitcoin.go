@@ -1,146 +1,179 @@
 package crypto
 
 import (
+	"errors"
+	"fmt"
+
+	"hellomix-backend/pkg/money"
+
 	"github.com/btcsuite/btcd/btcutil"
-	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/btcutil/bech32"
 )
 
 // BitcoinService handles Bitcoin-related operations
 type BitcoinService struct {
-	testnet       bool
-	walletManager *WalletManager
+	network Network
 }
 
 // NewBitcoinService creates a new Bitcoin service
-func NewBitcoinService(testnet bool) *BitcoinService {
+func NewBitcoinService(network Network) *BitcoinService {
 	return &BitcoinService{
-		testnet:       testnet,
-		walletManager: NewWalletManager(testnet),
+		network: network,
 	}
 }
 
-// GenerateAddress generates a new Bitcoin address with persistent key storage
-func (bs *BitcoinService) GenerateAddress() (string, error) {
-	return bs.walletManager.GenerateAddressWithKey()
-}
-
 // ValidateAddress validates a Bitcoin address using proper Bitcoin validation
 func (bs *BitcoinService) ValidateAddress(address string) bool {
-	// Choose the appropriate network parameters
-	var netParams *chaincfg.Params
-	if bs.testnet {
-		netParams = &chaincfg.TestNet3Params
-	} else {
-		netParams = &chaincfg.MainNetParams
-	}
+	// Use btcutil to validate the address against the configured network
+	decoded, err := btcutil.DecodeAddress(address, bs.network.Params())
+	return err == nil && decoded.IsForNet(bs.network.Params())
+}
+
+// AddressErrorReason identifies why an address failed validation
+type AddressErrorReason string
+
+// Address error reasons
+const (
+	// AddressReasonUnsupportedCurrency is returned for currencies without a
+	// validator
+	AddressReasonUnsupportedCurrency AddressErrorReason = "unsupported_currency"
+	// AddressReasonInvalidFormat is returned for addresses of the wrong
+	// length, prefix or character set
+	AddressReasonInvalidFormat AddressErrorReason = "invalid_format"
+	// AddressReasonInvalidEncoding is returned for addresses whose payload
+	// does not decode to an address of the chain
+	AddressReasonInvalidEncoding AddressErrorReason = "invalid_encoding"
+	// AddressReasonInvalidChecksum is returned for addresses whose checksum
+	// does not match, typically a typo
+	AddressReasonInvalidChecksum AddressErrorReason = "invalid_checksum"
+	// AddressReasonWrongNetwork is returned for addresses of a network the
+	// deployment does not pay out on
+	AddressReasonWrongNetwork AddressErrorReason = "wrong_network"
+	// AddressReasonUnsupportedType is returned for well-formed addresses that
+	// cannot receive payouts, such as Cardano stake addresses
+	AddressReasonUnsupportedType AddressErrorReason = "unsupported_type"
+	// AddressReasonOffCurve is returned for Solana addresses that are not
+	// ed25519 public keys, such as program derived addresses
+	AddressReasonOffCurve AddressErrorReason = "off_curve"
+	// AddressReasonMemoRequired is returned for outputs without the memo
+	// their chain requires
+	AddressReasonMemoRequired AddressErrorReason = "memo_required"
+	// AddressReasonMemoForbidden is returned for outputs with a memo on a
+	// chain that cannot carry one
+	AddressReasonMemoForbidden AddressErrorReason = "memo_forbidden"
+	// AddressReasonInvalidMemo is returned for memos too long or of the
+	// wrong format for their chain
+	AddressReasonInvalidMemo AddressErrorReason = "invalid_memo"
+)
 
-	// Use btcutil to validate the address
-	_, err := btcutil.DecodeAddress(address, netParams)
-	return err == nil
+// AddressError describes why an address failed validation
+type AddressError struct {
+	Reason  AddressErrorReason `json:"reason"`
+	Message string             `json:"message"`
 }
 
-// AddressValidator provides validation for various cryptocurrency addresses
-type AddressValidator struct{}
+// Error implements error
+func (e *AddressError) Error() string {
+	return e.Message
+}
 
-// NewAddressValidator creates a new address validator
-func NewAddressValidator() *AddressValidator {
-	return &AddressValidator{}
+// addressError creates an AddressError with a formatted message
+func addressError(reason AddressErrorReason, format string, args ...interface{}) *AddressError {
+	return &AddressError{Reason: reason, Message: fmt.Sprintf(format, args...)}
 }
 
-// ValidateAddress validates an address for the given cryptocurrency
-func (av *AddressValidator) ValidateAddress(address, currency string) bool {
-	switch currency {
-	case "BTC":
-		return av.validateBitcoinAddress(address)
-	case "ETH", "USDT", "USDC", "MATIC":
-		return av.validateEthereumAddress(address)
-	case "ADA":
-		return av.validateCardanoAddress(address)
-	case "SOL":
-		return av.validateSolanaAddress(address)
-	default:
-		return false
-	}
+// AddressValidator provides validation for various cryptocurrency addresses.
+// Addresses are fully decoded and their checksums verified, since a payout to
+// a mistyped address cannot be reversed, and have to belong to the networks
+// of the validator's profile.
+type AddressValidator struct {
+	profile NetworkProfile
 }
 
-// validateBitcoinAddress validates a Bitcoin address using proper Bitcoin validation
-func (av *AddressValidator) validateBitcoinAddress(address string) bool {
-	// Try to decode as mainnet first
-	_, err := btcutil.DecodeAddress(address, &chaincfg.MainNetParams)
-	if err == nil {
-		return true
+// NewAddressValidator creates a new address validator for the networks of
+// profile
+func NewAddressValidator(profile NetworkProfile) *AddressValidator {
+	return &AddressValidator{
+		profile: profile,
 	}
+}
 
-	// Try to decode as testnet
-	_, err = btcutil.DecodeAddress(address, &chaincfg.TestNet3Params)
-	return err == nil
+// Profile returns the network profile addresses are validated against
+func (av *AddressValidator) Profile() NetworkProfile {
+	return av.profile
 }
 
-// validateEthereumAddress validates an Ethereum-based address
-func (av *AddressValidator) validateEthereumAddress(address string) bool {
-	// Basic Ethereum address validation
-	if len(address) != 42 {
-		return false
+// ValidateAddress validates an address for the given cryptocurrency
+func (av *AddressValidator) ValidateAddress(address, currency string) bool {
+	return av.Validate(address, currency) == nil
+}
+
+// Validate validates an address for the given cryptocurrency, returning an
+// *AddressError describing why an invalid address was refused. The address
+// format and network come from the currency registry.
+func (av *AddressValidator) Validate(address, currency string) error {
+	entry, lookupErr := money.LookupCurrency(currency)
+	if lookupErr != nil {
+		return addressError(AddressReasonUnsupportedCurrency, "unsupported currency: %s", currency)
 	}
-	
-	if address[:2] != "0x" {
-		return false
+
+	var err *AddressError
+	switch entry.AddressFormat {
+	case money.AddressFormatBitcoin:
+		_, err = av.validateBitcoinAddress(address)
+	case money.AddressFormatEVM:
+		err = av.validateEthereumAddress(address, av.profile.EVMChain(entry.Network))
+	case money.AddressFormatCardano:
+		err = av.validateCardanoAddress(address)
+	case money.AddressFormatSolana:
+		err = av.validateSolanaAddress(address)
+	case money.AddressFormatTron:
+		err = av.validateTronAddress(address)
+	default:
+		err = addressError(AddressReasonUnsupportedCurrency, "unsupported currency: %s", currency)
 	}
-	
-	// Check if all characters after 0x are valid hex
-	for _, char := range address[2:] {
-		if !((char >= '0' && char <= '9') || 
-			 (char >= 'a' && char <= 'f') || 
-			 (char >= 'A' && char <= 'F')) {
-			return false
-		}
+	// A nil *AddressError would be a non-nil error
+	if err != nil {
+		return err
 	}
-	
-	return true
+	return nil
 }
 
-// validateCardanoAddress validates a Cardano address
-func (av *AddressValidator) validateCardanoAddress(address string) bool {
-	// Basic Cardano address validation
-	// Cardano addresses are typically 103 characters long and start with 'addr1'
-	if len(address) < 50 || len(address) > 120 {
-		return false
+// BitcoinAddressType returns the script template of a valid Bitcoin address
+func (av *AddressValidator) BitcoinAddressType(address string) (AddressType, bool) {
+	addressType, err := av.validateBitcoinAddress(address)
+	return addressType, err == nil
+}
+
+// validateBitcoinAddress validates a Bitcoin address of the profile's
+// network and reports which address type it saw. The test networks, signet
+// and regtest share base58 version bytes, so their legacy addresses are
+// interchangeable, as are the tb1 addresses of the test networks and signet.
+func (av *AddressValidator) validateBitcoinAddress(address string) (AddressType, *AddressError) {
+	netParams := av.profile.Bitcoin.Params()
+	decoded, err := btcutil.DecodeAddress(address, netParams)
+	if err == nil && decoded.IsForNet(netParams) {
+		return DetectAddressType(decoded), nil
 	}
-	
-	// Check for Shelley era addresses
-	if len(address) >= 4 && address[:4] == "addr" {
-		return true
+
+	// Name the network of an address of another network
+	for _, known := range networks {
+		otherParams := known.info.params
+		if other, otherErr := btcutil.DecodeAddress(address, otherParams); otherErr == nil && other.IsForNet(otherParams) {
+			return "", addressError(AddressReasonWrongNetwork, "%s address, payouts are sent on %s", known.network, av.profile.Name())
+		}
 	}
-	
-	// Check for Byron era addresses (legacy)
-	if len(address) >= 2 && (address[:2] == "Ae" || address[:2] == "Dd") {
-		return true
+	if err == nil {
+		return "", addressError(AddressReasonWrongNetwork, "address is not for %s", av.profile.Name())
 	}
-	
-	return false
+	return "", bitcoinAddressError(err)
 }
 
-// validateSolanaAddress validates a Solana address
-func (av *AddressValidator) validateSolanaAddress(address string) bool {
-	// Solana addresses are base58 encoded and typically 32-44 characters
-	if len(address) < 32 || len(address) > 44 {
-		return false
-	}
-	
-	// Basic base58 character check
-	validChars := "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
-	for _, char := range address {
-		valid := false
-		for _, validChar := range validChars {
-			if char == validChar {
-				valid = true
-				break
-			}
-		}
-		if !valid {
-			return false
-		}
+// bitcoinAddressError classifies an error decoding a Bitcoin address
+func bitcoinAddressError(err error) *AddressError {
+	var bech32Checksum bech32.ErrInvalidChecksum
+	if errors.Is(err, btcutil.ErrChecksumMismatch) || errors.As(err, &bech32Checksum) {
+		return addressError(AddressReasonInvalidChecksum, "invalid Bitcoin address checksum")
 	}
-	
-	return true
+	return addressError(AddressReasonInvalidEncoding, "invalid Bitcoin address: %v", err)
 }
@@ -0,0 +1,552 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BitcoindClient is a ChainBackend backed by a Bitcoin Core node over JSON-RPC.
+// Deposit addresses are imported into the node's wallet as watch-only
+// descriptors and queried with listunspent; addresses that were never
+// imported fall back to a UTXO set scan with scantxoutset. The RPC URL may
+// include a /wallet/<name> path to select a wallet.
+type BitcoindClient struct {
+	httpClient *http.Client
+	rpcURL     string
+	user       string
+	password   string
+	nextID     uint64
+}
+
+// NewBitcoindClient creates a new bitcoind JSON-RPC client
+func NewBitcoindClient(rpcURL, user, password string) *BitcoindClient {
+	return &BitcoindClient{
+		httpClient: &http.Client{Timeout: 2 * time.Minute}, // scantxoutset can be slow
+		rpcURL:     rpcURL,
+		user:       user,
+		password:   password,
+	}
+}
+
+// rpcRequest is a JSON-RPC 1.0 request as accepted by bitcoind
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// rpcResponse is a JSON-RPC response from bitcoind
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// RPCError is an error returned by bitcoind
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("bitcoind RPC error %d: %s", e.Code, e.Message)
+}
+
+// Is matches ErrNotFound to lookups of unknown transactions
+func (e *RPCError) Is(target error) bool {
+	return target == ErrNotFound && e.Code == rpcInvalidAddressOrKey
+}
+
+// call invokes an RPC method and unmarshals its result into result
+func (bc *BitcoindClient) call(ctx context.Context, method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	payload, err := json.Marshal(rpcRequest{
+		JSONRPC: "1.0",
+		ID:      atomic.AddUint64(&bc.nextID, 1),
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", bc.rpcURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bc.user != "" {
+		req.SetBasicAuth(bc.user, bc.password)
+	}
+
+	resp, err := bc.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// bitcoind answers RPC errors with a 4xx/5xx status and a JSON body,
+	// anything else is an HTTP level failure such as a full work queue
+	var rpcResp rpcResponse
+	if err := json.Unmarshal(body, &rpcResp); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("RPC %s failed: %w", method, newHTTPStatusError(resp))
+		}
+		return fmt.Errorf("failed to unmarshal %s response: %w", method, err)
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(rpcResp.Result, result); err != nil {
+		return fmt.Errorf("failed to unmarshal %s result: %w", method, err)
+	}
+	return nil
+}
+
+// WatchAddress imports an address into the node's wallet as a watch-only descriptor
+func (bc *BitcoindClient) WatchAddress(ctx context.Context, address string) error {
+	var info struct {
+		Descriptor string `json:"descriptor"`
+	}
+	if err := bc.call(ctx, "getdescriptorinfo", &info, fmt.Sprintf("addr(%s)", address)); err != nil {
+		return fmt.Errorf("failed to get descriptor info: %w", err)
+	}
+
+	var results []struct {
+		Success bool      `json:"success"`
+		Error   *RPCError `json:"error"`
+	}
+	request := []map[string]interface{}{{
+		"desc":      info.Descriptor,
+		"timestamp": "now",
+		"label":     "hellomix-deposit",
+	}}
+	if err := bc.call(ctx, "importdescriptors", &results, request); err != nil {
+		return fmt.Errorf("failed to import descriptor: %w", err)
+	}
+	if len(results) > 0 && !results[0].Success {
+		if results[0].Error != nil {
+			return fmt.Errorf("failed to import descriptor: %w", results[0].Error)
+		}
+		return fmt.Errorf("failed to import descriptor for %s", address)
+	}
+
+	return nil
+}
+
+// unspentOutput is an output reported by listunspent or scantxoutset
+type unspentOutput struct {
+	TXID          string  `json:"txid"`
+	Vout          int     `json:"vout"`
+	Amount        float64 `json:"amount"`
+	Confirmations int64   `json:"confirmations"`
+	Height        int64   `json:"height"`
+}
+
+// listUnspent lists wallet outputs paying to address, including mempool
+// outputs. It falls back to scanning the UTXO set when the wallet does not
+// know the address, in which case only confirmed outputs are returned.
+func (bc *BitcoindClient) listUnspent(ctx context.Context, address string) ([]unspentOutput, int64, error) {
+	tip, err := bc.GetTipHeight(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var walletInfo struct {
+		IsMine      bool `json:"ismine"`
+		IsWatchOnly bool `json:"iswatchonly"`
+	}
+	err = bc.call(ctx, "getaddressinfo", &walletInfo, address)
+	if err == nil && (walletInfo.IsMine || walletInfo.IsWatchOnly) {
+		var unspent []unspentOutput
+		if err := bc.call(ctx, "listunspent", &unspent, 0, 9999999, []string{address}, true); err != nil {
+			return nil, 0, fmt.Errorf("failed to list unspent outputs: %w", err)
+		}
+		for i := range unspent {
+			if unspent[i].Confirmations > 0 {
+				unspent[i].Height = tip - unspent[i].Confirmations + 1
+			}
+		}
+		return unspent, tip, nil
+	}
+
+	var scan struct {
+		Success  bool            `json:"success"`
+		Height   int64           `json:"height"`
+		Unspents []unspentOutput `json:"unspents"`
+	}
+	if err := bc.call(ctx, "scantxoutset", &scan, "start", []string{fmt.Sprintf("addr(%s)", address)}); err != nil {
+		return nil, 0, fmt.Errorf("failed to scan UTXO set: %w", err)
+	}
+	if !scan.Success {
+		return nil, 0, fmt.Errorf("UTXO set scan for %s did not complete", address)
+	}
+	for i := range scan.Unspents {
+		scan.Unspents[i].Confirmations = scan.Height - scan.Unspents[i].Height + 1
+	}
+	return scan.Unspents, scan.Height, nil
+}
+
+// GetAddressInfo gets information about a Bitcoin address. Bitcoin Core does
+// not index spent outputs per address, so the stats only cover unspent outputs.
+func (bc *BitcoindClient) GetAddressInfo(ctx context.Context, address string) (*AddressInfo, error) {
+	unspent, _, err := bc.listUnspent(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	addressInfo := &AddressInfo{Address: address}
+	txids := make(map[string]bool)
+	for _, utxo := range unspent {
+		amount, err := btcutil.NewAmount(utxo.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount for %s:%d: %w", utxo.TXID, utxo.Vout, err)
+		}
+
+		stats := &addressInfo.MempoolStats
+		if utxo.Confirmations > 0 {
+			stats = &addressInfo.ChainStats
+		}
+		stats.FundedTxoCount++
+		stats.FundedTxoSum += int64(amount)
+		if !txids[utxo.TXID] {
+			txids[utxo.TXID] = true
+			stats.TxCount++
+		}
+	}
+
+	addressInfo.calculateBalances()
+	return addressInfo, nil
+}
+
+// GetAddressTransactions gets the transactions funding a Bitcoin address
+func (bc *BitcoindClient) GetAddressTransactions(ctx context.Context, address string) ([]Transaction, error) {
+	unspent, tip, err := bc.listUnspent(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []Transaction
+	seen := make(map[string]bool)
+	for _, utxo := range unspent {
+		if seen[utxo.TXID] {
+			continue
+		}
+		seen[utxo.TXID] = true
+
+		blockHash := ""
+		if utxo.Confirmations > 0 {
+			height := tip - utxo.Confirmations + 1
+			if err := bc.call(ctx, "getblockhash", &blockHash, height); err != nil {
+				return nil, fmt.Errorf("failed to get block hash at %d: %w", height, err)
+			}
+		}
+
+		tx, err := bc.getRawTransaction(ctx, utxo.TXID, blockHash)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, *tx)
+	}
+
+	return transactions, nil
+}
+
+// GetAddressUTXOs gets the unspent outputs paying to a Bitcoin address
+func (bc *BitcoindClient) GetAddressUTXOs(ctx context.Context, address string) ([]UTXO, error) {
+	unspent, _, err := bc.listUnspent(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	utxos := make([]UTXO, 0, len(unspent))
+	for _, utxo := range unspent {
+		amount, err := btcutil.NewAmount(utxo.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount for %s:%d: %w", utxo.TXID, utxo.Vout, err)
+		}
+
+		output := UTXO{
+			TXID:  utxo.TXID,
+			Vout:  utxo.Vout,
+			Value: int64(amount),
+		}
+		if utxo.Confirmations > 0 {
+			output.Status = Status{Confirmed: true, BlockHeight: utxo.Height}
+		}
+		utxos = append(utxos, output)
+	}
+
+	return utxos, nil
+}
+
+// BroadcastTransaction submits a signed transaction with sendrawtransaction
+func (bc *BitcoindClient) BroadcastTransaction(ctx context.Context, tx *wire.MsgTx) (string, error) {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return "", fmt.Errorf("failed to serialize transaction: %w", err)
+	}
+
+	var txid string
+	if err := bc.call(ctx, "sendrawtransaction", &txid, hex.EncodeToString(buf.Bytes())); err != nil {
+		return "", fmt.Errorf("failed to broadcast transaction: %w", err)
+	}
+	return txid, nil
+}
+
+// GetTipHeight gets the height of the best block
+func (bc *BitcoindClient) GetTipHeight(ctx context.Context) (int64, error) {
+	var height int64
+	if err := bc.call(ctx, "getblockcount", &height); err != nil {
+		return 0, fmt.Errorf("failed to get block count: %w", err)
+	}
+	return height, nil
+}
+
+// EstimateFeeRate estimates a fee rate with estimatesmartfee, which answers
+// in BTC/kvB and reports errors instead of a rate while the node lacks data
+func (bc *BitcoindClient) EstimateFeeRate(ctx context.Context, targetBlocks int) (float64, error) {
+	var result struct {
+		FeeRate float64  `json:"feerate"`
+		Errors  []string `json:"errors"`
+	}
+	if err := bc.call(ctx, "estimatesmartfee", &result, targetBlocks); err != nil {
+		return 0, fmt.Errorf("failed to estimate fee: %w", err)
+	}
+	if result.FeeRate <= 0 {
+		if len(result.Errors) > 0 {
+			return 0, fmt.Errorf("no fee estimate: %s", strings.Join(result.Errors, "; "))
+		}
+		return 0, fmt.Errorf("no fee estimate")
+	}
+
+	// 1 BTC/kvB is 1e8 sat per 1000 vB
+	return result.FeeRate * 1e5, nil
+}
+
+// GetBlockHash gets the hash of the active chain's block at a height
+func (bc *BitcoindClient) GetBlockHash(ctx context.Context, height int64) (string, error) {
+	var blockHash string
+	if err := bc.call(ctx, "getblockhash", &blockHash, height); err != nil {
+		return "", fmt.Errorf("failed to get block hash: %w", err)
+	}
+	return blockHash, nil
+}
+
+// GetTransaction gets a transaction by id. Without -txindex only mempool and
+// wallet transactions can be found.
+func (bc *BitcoindClient) GetTransaction(ctx context.Context, txid string) (*Transaction, error) {
+	tx, err := bc.getRawTransaction(ctx, txid, "")
+	if err == nil {
+		return tx, nil
+	}
+
+	var walletTx struct {
+		BlockHash string `json:"blockhash"`
+	}
+	if werr := bc.call(ctx, "gettransaction", &walletTx, txid, true); werr != nil {
+		if errors.Is(werr, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to get wallet transaction %s: %w", txid, werr)
+	}
+	return bc.getRawTransaction(ctx, txid, walletTx.BlockHash)
+}
+
+// rawTransaction is the verbose getrawtransaction result
+type rawTransaction struct {
+	TXID     string `json:"txid"`
+	Version  int    `json:"version"`
+	Locktime int64  `json:"locktime"`
+	Vin      []struct {
+		TXID string `json:"txid"`
+		Vout int    `json:"vout"`
+	} `json:"vin"`
+	Vout []struct {
+		Value        float64 `json:"value"`
+		N            int     `json:"n"`
+		ScriptPubKey struct {
+			Asm     string `json:"asm"`
+			Hex     string `json:"hex"`
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"scriptPubKey"`
+	} `json:"vout"`
+	BlockHash string `json:"blockhash"`
+	BlockTime int64  `json:"blocktime"`
+}
+
+// getRawTransaction fetches a transaction and converts it to the Esplora shape
+func (bc *BitcoindClient) getRawTransaction(ctx context.Context, txid, blockHash string) (*Transaction, error) {
+	params := []interface{}{txid, true}
+	if blockHash != "" {
+		params = append(params, blockHash)
+	}
+
+	var raw rawTransaction
+	if err := bc.call(ctx, "getrawtransaction", &raw, params...); err != nil {
+		return nil, fmt.Errorf("failed to get transaction %s: %w", txid, err)
+	}
+
+	tx := &Transaction{
+		TXID:     raw.TXID,
+		Version:  raw.Version,
+		Locktime: raw.Locktime,
+	}
+	for _, in := range raw.Vin {
+		tx.Vin = append(tx.Vin, Vin{TXID: in.TXID, Vout: in.Vout})
+	}
+	for _, out := range raw.Vout {
+		amount, err := btcutil.NewAmount(out.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount in %s:%d: %w", txid, out.N, err)
+		}
+		tx.Vout = append(tx.Vout, Vout{
+			ScriptPubKey:        out.ScriptPubKey.Hex,
+			ScriptPubKeyAsm:     out.ScriptPubKey.Asm,
+			ScriptPubKeyType:    strings.ToLower(out.ScriptPubKey.Type),
+			ScriptPubKeyAddress: out.ScriptPubKey.Address,
+			Value:               int64(amount),
+		})
+	}
+
+	if raw.BlockHash != "" {
+		var header struct {
+			Height int64 `json:"height"`
+		}
+		if err := bc.call(ctx, "getblockheader", &header, raw.BlockHash); err != nil {
+			return nil, fmt.Errorf("failed to get block header %s: %w", raw.BlockHash, err)
+		}
+		tx.Status = Status{
+			Confirmed:   true,
+			BlockHeight: header.Height,
+			BlockHash:   raw.BlockHash,
+			BlockTime:   raw.BlockTime,
+		}
+	}
+
+	return tx, nil
+}
+
+// CheckNetwork verifies that the node runs on the given network, so a
+// deployment never watches or broadcasts on another chain
+func (bc *BitcoindClient) CheckNetwork(ctx context.Context, network Network) error {
+	var info struct {
+		Chain string `json:"chain"`
+	}
+	if err := bc.call(ctx, "getblockchaininfo", &info); err != nil {
+		return fmt.Errorf("failed to get blockchain info: %w", err)
+	}
+	if info.Chain != network.BitcoindChain() {
+		return fmt.Errorf("bitcoind runs on chain %q, expected %q for %s", info.Chain, network.BitcoindChain(), network)
+	}
+	return nil
+}
+
+// The methods below use the node's own wallet. They exist to drive exchanges
+// end to end on regtest, where the node mines blocks and funds deposits.
+
+// GetNewAddress gets a new address of the node's wallet
+func (bc *BitcoindClient) GetNewAddress(ctx context.Context) (string, error) {
+	var address string
+	if err := bc.call(ctx, "getnewaddress", &address); err != nil {
+		return "", fmt.Errorf("failed to get new address: %w", err)
+	}
+	return address, nil
+}
+
+// GenerateToAddress mines blocks paying their coinbase to address and
+// returns their hashes. Only regtest nodes can mine on demand.
+func (bc *BitcoindClient) GenerateToAddress(ctx context.Context, blocks int, address string) ([]string, error) {
+	var hashes []string
+	if err := bc.call(ctx, "generatetoaddress", &hashes, blocks, address); err != nil {
+		return nil, fmt.Errorf("failed to generate blocks: %w", err)
+	}
+	return hashes, nil
+}
+
+// GetBalance gets the trusted balance of the node's wallet in satoshis
+func (bc *BitcoindClient) GetBalance(ctx context.Context) (int64, error) {
+	var balance float64
+	if err := bc.call(ctx, "getbalance", &balance); err != nil {
+		return 0, fmt.Errorf("failed to get balance: %w", err)
+	}
+	amount, err := btcutil.NewAmount(balance)
+	if err != nil {
+		return 0, fmt.Errorf("invalid balance: %w", err)
+	}
+	return int64(amount), nil
+}
+
+// SendToAddress pays sats to address from the node's wallet
+func (bc *BitcoindClient) SendToAddress(ctx context.Context, address string, sats int64) (string, error) {
+	var txid string
+	if err := bc.call(ctx, "sendtoaddress", &txid, address, btcutil.Amount(sats).ToBTC()); err != nil {
+		return "", fmt.Errorf("failed to send to %s: %w", address, err)
+	}
+	return txid, nil
+}
+
+// GetReceivedByAddress gets the satoshis received by an address of the
+// node's wallet in transactions with at least minConf confirmations
+func (bc *BitcoindClient) GetReceivedByAddress(ctx context.Context, address string, minConf int) (int64, error) {
+	var received float64
+	if err := bc.call(ctx, "getreceivedbyaddress", &received, address, minConf); err != nil {
+		return 0, fmt.Errorf("failed to get received amount: %w", err)
+	}
+	amount, err := btcutil.NewAmount(received)
+	if err != nil {
+		return 0, fmt.Errorf("invalid received amount: %w", err)
+	}
+	return int64(amount), nil
+}
+
+// LoadWallet loads a descriptor wallet of the node, creating it when it does
+// not exist. Watch-only wallets are created blank with private keys disabled,
+// as deposit descriptors are imported into them.
+func (bc *BitcoindClient) LoadWallet(ctx context.Context, name string, watchOnly bool) error {
+	err := bc.call(ctx, "loadwallet", nil, name)
+	var rpcErr *RPCError
+	if err == nil || errors.As(err, &rpcErr) && rpcErr.Code == rpcWalletAlreadyLoaded {
+		return nil
+	}
+	if !errors.As(err, &rpcErr) || rpcErr.Code != rpcWalletNotFound {
+		return fmt.Errorf("failed to load wallet %s: %w", name, err)
+	}
+
+	if err := bc.call(ctx, "createwallet", nil, name, watchOnly, watchOnly); err != nil {
+		return fmt.Errorf("failed to create wallet %s: %w", name, err)
+	}
+	return nil
+}
+
+// bitcoind RPC error codes
+const (
+	rpcInvalidAddressOrKey = -5
+	rpcWalletNotFound      = -18
+	rpcWalletAlreadyLoaded = -35
+)
@@ -0,0 +1,246 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+)
+
+// Cardano network IDs in the header of Shelley addresses
+const (
+	cardanoTestnetID = 0
+	cardanoMainnetID = 1
+)
+
+// cardanoHRPs maps the human-readable part of Shelley payment addresses to
+// their network ID
+var cardanoHRPs = map[string]byte{
+	"addr":      cardanoMainnetID,
+	"addr_test": cardanoTestnetID,
+}
+
+// cardanoNetworkNames names Cardano networks by network ID
+var cardanoNetworkNames = map[byte]string{
+	cardanoMainnetID: "mainnet",
+	cardanoTestnetID: "testnet",
+}
+
+// validateCardanoAddress validates a Cardano address of the profile's
+// network: a bech32 Shelley payment address, or a base58 Byron address with
+// its CRC32 checksum
+func (av *AddressValidator) validateCardanoAddress(address string) *AddressError {
+	var networkID byte
+	var err *AddressError
+	if lower := strings.ToLower(address); strings.HasPrefix(lower, "addr") || strings.HasPrefix(lower, "stake") {
+		networkID, err = validateShelleyAddress(address)
+	} else {
+		networkID, err = validateByronAddress(address)
+	}
+	if err != nil {
+		return err
+	}
+
+	if networkID != av.profile.CardanoNetworkID {
+		return addressError(AddressReasonWrongNetwork, "Cardano %s address, payouts are sent on %s",
+			cardanoNetworkNames[networkID], cardanoNetworkNames[av.profile.CardanoNetworkID])
+	}
+	return nil
+}
+
+// validateShelleyAddress validates a Shelley address per CIP-19, a header
+// byte with the address type and network ID followed by the payment and
+// stake credentials the type calls for, and returns its network ID
+func validateShelleyAddress(address string) (byte, *AddressError) {
+	hrp, data, version, err := bech32.DecodeNoLimitWithVersion(address)
+	if err != nil {
+		var checksumErr bech32.ErrInvalidChecksum
+		if errors.As(err, &checksumErr) {
+			return 0, addressError(AddressReasonInvalidChecksum, "invalid bech32 checksum")
+		}
+		return 0, addressError(AddressReasonInvalidFormat, "invalid Cardano address: %v", err)
+	}
+	if version != bech32.Version0 {
+		return 0, addressError(AddressReasonInvalidChecksum, "Cardano addresses use bech32, not bech32m checksums")
+	}
+
+	networkID, ok := cardanoHRPs[hrp]
+	if !ok {
+		return 0, addressError(AddressReasonUnsupportedType, "%s addresses cannot receive payouts", hrp)
+	}
+
+	payload, err := bech32.ConvertBits(data, 5, 8, false)
+	if err != nil || len(payload) == 0 {
+		return 0, addressError(AddressReasonInvalidEncoding, "invalid Cardano address payload")
+	}
+
+	header := payload[0]
+	if header&0x0f != networkID {
+		return 0, addressError(AddressReasonInvalidEncoding, "%s address has network ID %d", hrp, header&0x0f)
+	}
+
+	// Credentials are 28-byte key or script hashes
+	body := payload[1:]
+	switch addressType := header >> 4; addressType {
+	case 0, 1, 2, 3:
+		// Payment and stake credentials
+		if len(body) != 56 {
+			return 0, addressError(AddressReasonInvalidEncoding, "base address has a %d-byte payload, expected 56", len(body))
+		}
+	case 4, 5:
+		// Payment credential and a pointer to a stake registration
+		if len(body) < 28 || !isCardanoPointer(body[28:]) {
+			return 0, addressError(AddressReasonInvalidEncoding, "invalid pointer address payload")
+		}
+	case 6, 7:
+		// Payment credential only
+		if len(body) != 28 {
+			return 0, addressError(AddressReasonInvalidEncoding, "enterprise address has a %d-byte payload, expected 28", len(body))
+		}
+	default:
+		return 0, addressError(AddressReasonUnsupportedType, "Cardano address type %d cannot receive payouts", addressType)
+	}
+	return networkID, nil
+}
+
+// isCardanoPointer reports whether b is exactly three variable-length
+// naturals, the slot, transaction index and certificate index of a pointer
+func isCardanoPointer(b []byte) bool {
+	for n := 0; n < 3; n++ {
+		for {
+			if len(b) == 0 {
+				return false
+			}
+			more := b[0]&0x80 != 0
+			b = b[1:]
+			if !more {
+				break
+			}
+		}
+	}
+	return len(b) == 0
+}
+
+// validateByronAddress validates a Byron address, the base58 encoding of a
+// CBOR array holding the tagged, CBOR-encoded address payload and its CRC32,
+// and returns its network ID. Only test network addresses carry a network
+// magic attribute.
+func validateByronAddress(address string) (byte, *AddressError) {
+	// base58.Decode returns nothing for characters outside the alphabet
+	raw := base58.Decode(address)
+	if len(raw) == 0 {
+		return 0, addressError(AddressReasonInvalidFormat, "Cardano addresses are bech32 addr1... or base58 Byron addresses")
+	}
+
+	r := cborReader{data: raw}
+	if major, n, err := r.head(); err != nil || major != cborArray || n != 2 {
+		return 0, addressError(AddressReasonInvalidEncoding, "invalid Byron address encoding")
+	}
+	if major, tag, err := r.head(); err != nil || major != cborTag || tag != 24 {
+		return 0, addressError(AddressReasonInvalidEncoding, "invalid Byron address encoding")
+	}
+	payload, err := r.bytes()
+	if err != nil {
+		return 0, addressError(AddressReasonInvalidEncoding, "invalid Byron address encoding")
+	}
+	major, checksum, err := r.head()
+	if err != nil || major != cborUint || len(r.data) != 0 {
+		return 0, addressError(AddressReasonInvalidEncoding, "invalid Byron address encoding")
+	}
+
+	if uint64(crc32.ChecksumIEEE(payload)) != checksum {
+		return 0, addressError(AddressReasonInvalidChecksum, "invalid Byron address checksum")
+	}
+
+	// The payload is [address root, attributes, address type], the root a
+	// 28-byte hash
+	r = cborReader{data: payload}
+	if major, n, err := r.head(); err != nil || major != cborArray || n != 3 {
+		return 0, addressError(AddressReasonInvalidEncoding, "invalid Byron address payload")
+	}
+	if root, err := r.bytes(); err != nil || len(root) != 28 {
+		return 0, addressError(AddressReasonInvalidEncoding, "invalid Byron address root")
+	}
+	major, attributes, err := r.head()
+	if err != nil || major != cborMap {
+		return 0, addressError(AddressReasonInvalidEncoding, "invalid Byron address attributes")
+	}
+
+	// Attribute values are CBOR-encoded into byte strings
+	for n := uint64(0); n < attributes; n++ {
+		major, key, err := r.head()
+		if err != nil || major != cborUint {
+			return 0, addressError(AddressReasonInvalidEncoding, "invalid Byron address attributes")
+		}
+		if _, err := r.bytes(); err != nil {
+			return 0, addressError(AddressReasonInvalidEncoding, "invalid Byron address attributes")
+		}
+		if key == byronNetworkMagicAttribute {
+			return cardanoTestnetID, nil
+		}
+	}
+	return cardanoMainnetID, nil
+}
+
+// byronNetworkMagicAttribute is the key of the network magic attribute of
+// Byron addresses
+const byronNetworkMagicAttribute = 2
+
+// CBOR major types
+const (
+	cborUint  = 0
+	cborBytes = 2
+	cborArray = 4
+	cborMap   = 5
+	cborTag   = 6
+)
+
+// cborReader reads the few CBOR items Byron addresses are made of
+type cborReader struct {
+	data []byte
+}
+
+// head reads the head of a CBOR item: its major type and argument, which is
+// the value, length or tag number depending on the type
+func (r *cborReader) head() (byte, uint64, error) {
+	if len(r.data) == 0 {
+		return 0, 0, fmt.Errorf("unexpected end of CBOR data")
+	}
+	major, info := r.data[0]>>5, r.data[0]&0x1f
+	r.data = r.data[1:]
+
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+	if info > 27 {
+		return 0, 0, fmt.Errorf("unsupported CBOR argument: %d", info)
+	}
+
+	size := 1 << (info - 24)
+	if len(r.data) < size {
+		return 0, 0, fmt.Errorf("unexpected end of CBOR data")
+	}
+	var arg uint64
+	for _, b := range r.data[:size] {
+		arg = arg<<8 | uint64(b)
+	}
+	r.data = r.data[size:]
+	return major, arg, nil
+}
+
+// bytes reads a CBOR byte string
+func (r *cborReader) bytes() ([]byte, error) {
+	major, n, err := r.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != cborBytes || n > uint64(len(r.data)) {
+		return nil, fmt.Errorf("invalid CBOR byte string")
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
@@ -0,0 +1,196 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ChainBackend is the source of blockchain data used to detect and confirm
+// payments. Implementations exist for Esplora HTTP APIs, a Bitcoin Core node
+// over JSON-RPC and an in-memory fake chain for tests.
+type ChainBackend interface {
+	// GetAddressInfo returns funded and spent totals for an address
+	GetAddressInfo(ctx context.Context, address string) (*AddressInfo, error)
+	// GetAddressTransactions returns the transactions paying to or spending from an address
+	GetAddressTransactions(ctx context.Context, address string) ([]Transaction, error)
+	// GetTipHeight returns the height of the best block
+	GetTipHeight(ctx context.Context) (int64, error)
+	// GetBlockHash returns the hash of the active chain's block at a height
+	GetBlockHash(ctx context.Context, height int64) (string, error)
+	// GetTransaction looks up a single transaction by id. The error wraps
+	// ErrNotFound when the backend definitely does not know the transaction.
+	GetTransaction(ctx context.Context, txid string) (*Transaction, error)
+	// GetAddressUTXOs returns the unspent outputs paying to an address
+	GetAddressUTXOs(ctx context.Context, address string) ([]UTXO, error)
+	// BroadcastTransaction submits a signed transaction and returns its txid
+	BroadcastTransaction(ctx context.Context, tx *wire.MsgTx) (string, error)
+	// EstimateFeeRate returns the fee rate in sat/vB expected to confirm a
+	// transaction within targetBlocks blocks
+	EstimateFeeRate(ctx context.Context, targetBlocks int) (float64, error)
+}
+
+// AddressWatcher is implemented by backends that must be told about an
+// address before they can report on it, e.g. a bitcoind watch-only wallet
+type AddressWatcher interface {
+	WatchAddress(ctx context.Context, address string) error
+}
+
+// ErrNotFound is matched by errors of backends that answered a lookup but
+// do not know what was looked up, as opposed to failing to answer it
+var ErrNotFound = errors.New("not found")
+
+// HTTPStatusError is returned when a backend answers with an unexpected HTTP status
+type HTTPStatusError struct {
+	StatusCode int
+	// RetryAfter is the delay requested by the server, zero if it sent none
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("API returned status: %d", e.StatusCode)
+}
+
+// Is matches ErrNotFound to 404 responses
+func (e *HTTPStatusError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// newHTTPStatusError builds an HTTPStatusError from a response, honouring a
+// Retry-After header given in seconds
+func newHTTPStatusError(resp *http.Response) *HTTPStatusError {
+	statusErr := &HTTPStatusError{StatusCode: resp.StatusCode}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		statusErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return statusErr
+}
+
+// IsThrottled reports whether err means the backend is rate limiting us or
+// is overloaded, together with the delay it asked for, if any
+func IsThrottled(err error) (time.Duration, bool) {
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		return 0, false
+	}
+	if statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500 {
+		return statusErr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// Chain backend kinds
+const (
+	ChainBackendEsplora  = "esplora"
+	ChainBackendBitcoind = "bitcoind"
+	ChainBackendFake     = "fake"
+)
+
+// ChainBackendOptions configures NewChainBackend
+type ChainBackendOptions struct {
+	Kind             string
+	Network          Network
+	EsploraURL       string
+	BitcoindURL      string
+	BitcoindUser     string
+	BitcoindPassword string
+}
+
+// NewChainBackend creates the chain backend selected by opts.Kind
+func NewChainBackend(opts ChainBackendOptions) (ChainBackend, error) {
+	switch strings.ToLower(opts.Kind) {
+	case "", ChainBackendEsplora:
+		baseURL := opts.EsploraURL
+		if baseURL == "" {
+			baseURL = opts.Network.EsploraURL()
+		}
+		if baseURL == "" {
+			return nil, fmt.Errorf("%s has no public Esplora API, set an Esplora URL or use the bitcoind backend", opts.Network)
+		}
+		return NewEsploraClient(baseURL), nil
+	case ChainBackendBitcoind:
+		if opts.BitcoindURL == "" {
+			return nil, fmt.Errorf("bitcoind backend requires an RPC URL")
+		}
+		return NewBitcoindClient(opts.BitcoindURL, opts.BitcoindUser, opts.BitcoindPassword), nil
+	case ChainBackendFake:
+		return NewFakeChain(opts.Network.Params()), nil
+	default:
+		return nil, fmt.Errorf("unknown chain backend: %s", opts.Kind)
+	}
+}
+
+// AddressInfo represents address information from blockchain API
+type AddressInfo struct {
+	Address            string `json:"address"`
+	ChainStats         Stats  `json:"chain_stats"`
+	MempoolStats       Stats  `json:"mempool_stats"`
+	TotalReceived      int64  `json:"-"` // Will be calculated
+	ConfirmedBalance   int64  `json:"-"` // Will be calculated
+	UnconfirmedBalance int64  `json:"-"` // Will be calculated
+}
+
+// Stats represents transaction statistics
+type Stats struct {
+	FundedTxoCount int64 `json:"funded_txo_count"`
+	FundedTxoSum   int64 `json:"funded_txo_sum"`
+	SpentTxoCount  int64 `json:"spent_txo_count"`
+	SpentTxoSum    int64 `json:"spent_txo_sum"`
+	TxCount        int64 `json:"tx_count"`
+}
+
+// Transaction represents a Bitcoin transaction
+type Transaction struct {
+	TXID     string `json:"txid"`
+	Version  int    `json:"version"`
+	Locktime int64  `json:"locktime"`
+	Vin      []Vin  `json:"vin"`
+	Vout     []Vout `json:"vout"`
+	Status   Status `json:"status"`
+	Fee      int64  `json:"fee"`
+}
+
+// Vin represents transaction input
+type Vin struct {
+	TXID    string `json:"txid"`
+	Vout    int    `json:"vout"`
+	Prevout Vout   `json:"prevout"`
+}
+
+// Vout represents transaction output
+type Vout struct {
+	ScriptPubKey        string `json:"scriptpubkey"`
+	ScriptPubKeyAsm     string `json:"scriptpubkey_asm"`
+	ScriptPubKeyType    string `json:"scriptpubkey_type"`
+	ScriptPubKeyAddress string `json:"scriptpubkey_address"`
+	Value               int64  `json:"value"`
+}
+
+// UTXO represents an unspent transaction output
+type UTXO struct {
+	TXID   string `json:"txid"`
+	Vout   int    `json:"vout"`
+	Value  int64  `json:"value"`
+	Status Status `json:"status"`
+}
+
+// Status represents transaction status
+type Status struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int64  `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+	BlockTime   int64  `json:"block_time"`
+}
+
+// calculateBalances derives the balance fields from the chain and mempool stats
+func (ai *AddressInfo) calculateBalances() {
+	ai.TotalReceived = ai.ChainStats.FundedTxoSum + ai.MempoolStats.FundedTxoSum
+	ai.ConfirmedBalance = ai.ChainStats.FundedTxoSum - ai.ChainStats.SpentTxoSum
+	ai.UnconfirmedBalance = ai.MempoolStats.FundedTxoSum - ai.MempoolStats.SpentTxoSum
+}
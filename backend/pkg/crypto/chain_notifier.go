@@ -0,0 +1,22 @@
+package crypto
+
+import (
+	"context"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ChainEvent is a new transaction or block announced by a ChainNotifier.
+// Exactly one of Tx and BlockHash is set.
+type ChainEvent struct {
+	Tx        *wire.MsgTx
+	BlockHash string
+}
+
+// ChainNotifier pushes transactions and blocks as a node sees them, so
+// payments can be detected without polling. Implementations exist for
+// bitcoind's ZMQ interface.
+type ChainNotifier interface {
+	// Run delivers events until ctx is cancelled or the notifier fails
+	Run(ctx context.Context, events chan<- ChainEvent) error
+}
@@ -0,0 +1,265 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// EsploraClient is a ChainBackend backed by an Esplora HTTP API, such as
+// blockstream.info, mempool.space or a self-hosted electrs instance
+type EsploraClient struct {
+	httpClient *http.Client
+	apiURL     string
+}
+
+// NewEsploraClient creates a new Esplora client for the given base URL
+func NewEsploraClient(baseURL string) *EsploraClient {
+	return &EsploraClient{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		apiURL:     strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// get performs a GET request against the API and returns the response body
+func (ec *EsploraClient) get(ctx context.Context, path string) ([]byte, error) {
+	url := ec.apiURL + path
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := ec.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPStatusError(resp)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return body, nil
+}
+
+// post performs a POST request with a plain text body and returns the response body
+func (ec *EsploraClient) post(ctx context.Context, path, body string) ([]byte, error) {
+	url := ec.apiURL + path
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := ec.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// Esplora explains rejected transactions in the body
+		if message := strings.TrimSpace(string(respBody)); message != "" {
+			return nil, fmt.Errorf("%w: %s", newHTTPStatusError(resp), message)
+		}
+		return nil, newHTTPStatusError(resp)
+	}
+
+	return respBody, nil
+}
+
+// GetAddressInfo gets information about a Bitcoin address
+func (ec *EsploraClient) GetAddressInfo(ctx context.Context, address string) (*AddressInfo, error) {
+	body, err := ec.get(ctx, fmt.Sprintf("/address/%s", address))
+	if err != nil {
+		return nil, err
+	}
+
+	var addressInfo AddressInfo
+	if err := json.Unmarshal(body, &addressInfo); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	addressInfo.calculateBalances()
+	return &addressInfo, nil
+}
+
+// esploraChainPageSize is the number of confirmed transactions Esplora
+// returns per page of an address's history
+const esploraChainPageSize = 25
+
+// GetAddressTransactions gets transactions for a Bitcoin address. Esplora
+// returns the mempool transactions and the newest confirmed ones first, the
+// older confirmed ones are paged through after the last one seen.
+func (ec *EsploraClient) GetAddressTransactions(ctx context.Context, address string) ([]Transaction, error) {
+	transactions, err := ec.getTransactions(ctx, fmt.Sprintf("/address/%s/txs", address))
+	if err != nil {
+		return nil, err
+	}
+
+	page := confirmedTransactions(transactions)
+	for len(page) == esploraChainPageSize {
+		lastSeen := page[len(page)-1].TXID
+		page, err = ec.getTransactions(ctx, fmt.Sprintf("/address/%s/txs/chain/%s", address, lastSeen))
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, page...)
+	}
+
+	return transactions, nil
+}
+
+// getTransactions gets a list of transactions
+func (ec *EsploraClient) getTransactions(ctx context.Context, path string) ([]Transaction, error) {
+	body, err := ec.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []Transaction
+	if err := json.Unmarshal(body, &transactions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// confirmedTransactions returns the confirmed transactions of a list
+func confirmedTransactions(transactions []Transaction) []Transaction {
+	var confirmed []Transaction
+	for _, tx := range transactions {
+		if tx.Status.Confirmed {
+			confirmed = append(confirmed, tx)
+		}
+	}
+	return confirmed
+}
+
+// GetTipHeight gets the height of the best block
+func (ec *EsploraClient) GetTipHeight(ctx context.Context) (int64, error) {
+	body, err := ec.get(ctx, "/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+
+	height, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse tip height: %w", err)
+	}
+
+	return height, nil
+}
+
+// EstimateFeeRate reads /fee-estimates, which maps confirmation targets to
+// fee rates in sat/vB. The estimate of the largest target not above
+// targetBlocks is used, or of the smallest target if there is none.
+func (ec *EsploraClient) EstimateFeeRate(ctx context.Context, targetBlocks int) (float64, error) {
+	body, err := ec.get(ctx, "/fee-estimates")
+	if err != nil {
+		return 0, err
+	}
+
+	var estimates map[string]float64
+	if err := json.Unmarshal(body, &estimates); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal fee estimates: %w", err)
+	}
+
+	best, smallest := -1, -1
+	for key := range estimates {
+		target, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		if target <= targetBlocks && target > best {
+			best = target
+		}
+		if smallest < 0 || target < smallest {
+			smallest = target
+		}
+	}
+	if best < 0 {
+		best = smallest
+	}
+	if best < 0 {
+		return 0, fmt.Errorf("no fee estimates available")
+	}
+
+	return estimates[strconv.Itoa(best)], nil
+}
+
+// GetBlockHash gets the hash of the active chain's block at a height
+func (ec *EsploraClient) GetBlockHash(ctx context.Context, height int64) (string, error) {
+	body, err := ec.get(ctx, fmt.Sprintf("/block-height/%d", height))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
+
+// GetTransaction gets a transaction by id
+func (ec *EsploraClient) GetTransaction(ctx context.Context, txid string) (*Transaction, error) {
+	body, err := ec.get(ctx, fmt.Sprintf("/tx/%s", txid))
+	if err != nil {
+		return nil, err
+	}
+
+	var transaction Transaction
+	if err := json.Unmarshal(body, &transaction); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &transaction, nil
+}
+
+// GetAddressUTXOs gets the unspent outputs paying to a Bitcoin address
+func (ec *EsploraClient) GetAddressUTXOs(ctx context.Context, address string) ([]UTXO, error) {
+	body, err := ec.get(ctx, fmt.Sprintf("/address/%s/utxo", address))
+	if err != nil {
+		return nil, err
+	}
+
+	var utxos []UTXO
+	if err := json.Unmarshal(body, &utxos); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return utxos, nil
+}
+
+// BroadcastTransaction submits a signed transaction to the network
+func (ec *EsploraClient) BroadcastTransaction(ctx context.Context, tx *wire.MsgTx) (string, error) {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return "", fmt.Errorf("failed to serialize transaction: %w", err)
+	}
+
+	body, err := ec.post(ctx, "/tx", hex.EncodeToString(buf.Bytes()))
+	if err != nil {
+		return "", fmt.Errorf("failed to broadcast transaction: %w", err)
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// evmChainShortNames maps EIP-3770 short names to chain IDs
+var evmChainShortNames = map[string]int64{
+	"eth":         EthereumMainnetChainID,
+	"sep":         EthereumSepoliaChainID,
+	"holesky":     17000,
+	"matic":       PolygonMainnetChainID,
+	"pol":         PolygonMainnetChainID,
+	"polygonamoy": PolygonAmoyChainID,
+}
+
+// validateEthereumAddress validates an Ethereum-style address, 0x followed
+// by 20 hex-encoded bytes. Mixed-case addresses carry an EIP-55 checksum,
+// which has to match; all-lowercase and all-uppercase addresses carry none.
+// Plain addresses carry no chain, so only EIP-3770 chain-prefixed ones can
+// be checked against chainID, the chain payouts are sent on; they are
+// refused either way, as payout rails expect plain addresses.
+func (av *AddressValidator) validateEthereumAddress(address string, chainID int64) *AddressError {
+	if shortName, _, ok := strings.Cut(address, ":"); ok {
+		if prefixChainID, known := evmChainShortNames[shortName]; known && prefixChainID != chainID {
+			return addressError(AddressReasonWrongNetwork, "%s: address is for chain %d, payouts are sent on chain %d",
+				shortName, prefixChainID, chainID)
+		}
+		return addressError(AddressReasonInvalidFormat, "remove the %s: chain prefix from the address", shortName)
+	}
+
+	if len(address) != 42 || !strings.HasPrefix(address, "0x") {
+		return addressError(AddressReasonInvalidFormat, "Ethereum addresses are 0x followed by 40 hex characters")
+	}
+
+	digits := address[2:]
+	if _, err := hex.DecodeString(digits); err != nil {
+		return addressError(AddressReasonInvalidFormat, "Ethereum address contains non-hex characters")
+	}
+
+	if digits == strings.ToLower(digits) || digits == strings.ToUpper(digits) {
+		return nil
+	}
+	if ChecksumEthereumAddress(address) != address {
+		return addressError(AddressReasonInvalidChecksum, "invalid EIP-55 checksum, expected %s", ChecksumEthereumAddress(address))
+	}
+	return nil
+}
+
+// ChecksumEthereumAddress returns the EIP-55 mixed-case form of a 0x-prefixed
+// hex address: each letter is uppercased when the matching nibble of the
+// Keccak-256 hash of the lowercase address is 8 or more.
+func ChecksumEthereumAddress(address string) string {
+	digits := strings.ToLower(strings.TrimPrefix(address, "0x"))
+
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write([]byte(digits))
+	sum := hash.Sum(nil)
+
+	checksummed := []byte(digits)
+	for i, char := range checksummed {
+		nibble := sum[i/2] >> 4
+		if i%2 == 1 {
+			nibble = sum[i/2] & 0x0f
+		}
+		if char >= 'a' && char <= 'f' && nibble >= 8 {
+			checksummed[i] = char - 'a' + 'A'
+		}
+	}
+	return "0x" + string(checksummed)
+}
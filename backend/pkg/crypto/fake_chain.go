@@ -0,0 +1,361 @@
+package crypto
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// FakeChain is a deterministic in-memory ChainBackend for tests and local
+// development. Payments are added to a mempool with Pay and confirmed with
+// MineBlocks; transaction ids and block hashes are derived from counters, so
+// the same sequence of calls always produces the same chain. Broadcast
+// transactions enter the mempool too and spend the fake outputs they reference.
+type FakeChain struct {
+	mu          sync.Mutex
+	netParams   *chaincfg.Params
+	blockHashes []string
+	mempool     []*Transaction
+	txs         map[string]*Transaction
+	txids       []string
+	txCount     int
+	branch      int
+	genesisTime time.Time
+	feeRate     float64
+}
+
+// NewFakeChain creates a fake chain containing only a genesis block. Output
+// addresses of broadcast transactions are encoded for netParams.
+func NewFakeChain(netParams *chaincfg.Params) *FakeChain {
+	return &FakeChain{
+		netParams:   netParams,
+		blockHashes: []string{fakeHash("block", 0)},
+		txs:         make(map[string]*Transaction),
+		genesisTime: time.Unix(1231006505, 0),
+	}
+}
+
+// fakeHash derives a deterministic 32-byte hex hash
+func fakeHash(kind string, n int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("hellomix-fake-%s-%d", kind, n)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Pay adds a mempool transaction paying amountSats to address and returns its txid
+func (fc *FakeChain) Pay(address string, amountSats int64) string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.txCount++
+	tx := &Transaction{
+		TXID:    fakeHash("tx", fc.txCount),
+		Version: 2,
+		Vin: []Vin{{
+			TXID: fakeHash("funding", fc.txCount),
+		}},
+		Vout: []Vout{{
+			ScriptPubKeyAddress: address,
+			Value:               amountSats,
+		}},
+	}
+
+	fc.mempool = append(fc.mempool, tx)
+	fc.txs[tx.TXID] = tx
+	fc.txids = append(fc.txids, tx.TXID)
+	return tx.TXID
+}
+
+// MineBlocks mines n blocks, confirming every mempool transaction in the first one.
+// Blocks mined after a Reorg get hashes of their own branch.
+func (fc *FakeChain) MineBlocks(n int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		height := int64(len(fc.blockHashes))
+		hash := fakeHash(fc.blockKind(), int(height))
+		fc.blockHashes = append(fc.blockHashes, hash)
+
+		for _, tx := range fc.mempool {
+			tx.Status = Status{
+				Confirmed:   true,
+				BlockHeight: height,
+				BlockHash:   hash,
+				BlockTime:   fc.genesisTime.Add(time.Duration(height) * 10 * time.Minute).Unix(),
+			}
+		}
+		fc.mempool = nil
+	}
+}
+
+// Reorg disconnects the last depth blocks, as if a competing branch were
+// about to overtake them. Their transactions return to the mempool, from
+// where MineBlocks confirms them again on the new branch unless they are
+// dropped with DropTransaction first.
+func (fc *FakeChain) Reorg(depth int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	tip := len(fc.blockHashes) - 1
+	if depth > tip {
+		depth = tip
+	}
+	fork := int64(tip - depth)
+
+	for _, txid := range fc.txids {
+		tx := fc.txs[txid]
+		if tx != nil && tx.Status.Confirmed && tx.Status.BlockHeight > fork {
+			tx.Status = Status{}
+			fc.mempool = append(fc.mempool, tx)
+		}
+	}
+
+	fc.blockHashes = fc.blockHashes[:fork+1]
+	fc.branch++
+}
+
+// DropTransaction removes an unconfirmed transaction, as if it had been
+// double spent
+func (fc *FakeChain) DropTransaction(txid string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	for i, tx := range fc.mempool {
+		if tx.TXID == txid {
+			fc.mempool = append(fc.mempool[:i], fc.mempool[i+1:]...)
+			delete(fc.txs, txid)
+			return
+		}
+	}
+}
+
+// spentBy returns the txid of the transaction spending txid:vout, if any
+func (fc *FakeChain) spentBy(txid string, vout int) string {
+	for _, tx := range fc.txs {
+		for _, vin := range tx.Vin {
+			if vin.TXID == txid && vin.Vout == vout {
+				return tx.TXID
+			}
+		}
+	}
+	return ""
+}
+
+// blockKind names the branch new blocks belong to
+func (fc *FakeChain) blockKind() string {
+	if fc.branch == 0 {
+		return "block"
+	}
+	return fmt.Sprintf("block-branch%d", fc.branch)
+}
+
+// GetAddressInfo gets information about an address
+func (fc *FakeChain) GetAddressInfo(ctx context.Context, address string) (*AddressInfo, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	addressInfo := &AddressInfo{Address: address}
+	for _, tx := range fc.txs {
+		stats := &addressInfo.MempoolStats
+		if tx.Status.Confirmed {
+			stats = &addressInfo.ChainStats
+		}
+
+		involved := false
+		for _, vout := range tx.Vout {
+			if vout.ScriptPubKeyAddress == address {
+				stats.FundedTxoCount++
+				stats.FundedTxoSum += vout.Value
+				involved = true
+			}
+		}
+		for _, vin := range tx.Vin {
+			if vin.Prevout.ScriptPubKeyAddress == address {
+				stats.SpentTxoCount++
+				stats.SpentTxoSum += vin.Prevout.Value
+				involved = true
+			}
+		}
+		if involved {
+			stats.TxCount++
+		}
+	}
+
+	addressInfo.calculateBalances()
+	return addressInfo, nil
+}
+
+// GetAddressTransactions gets the transactions paying to or spending from an address, newest first
+func (fc *FakeChain) GetAddressTransactions(ctx context.Context, address string) ([]Transaction, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	var transactions []Transaction
+	for i := len(fc.txids) - 1; i >= 0; i-- {
+		tx := fc.txs[fc.txids[i]]
+		if tx == nil {
+			continue
+		}
+		if fakeTxInvolves(tx, address) {
+			transactions = append(transactions, *tx)
+		}
+	}
+
+	return transactions, nil
+}
+
+// fakeTxInvolves reports whether a transaction pays to or spends from address
+func fakeTxInvolves(tx *Transaction, address string) bool {
+	for _, vout := range tx.Vout {
+		if vout.ScriptPubKeyAddress == address {
+			return true
+		}
+	}
+	for _, vin := range tx.Vin {
+		if vin.Prevout.ScriptPubKeyAddress == address {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTipHeight gets the height of the best block
+func (fc *FakeChain) GetTipHeight(ctx context.Context) (int64, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return int64(len(fc.blockHashes) - 1), nil
+}
+
+// SetFeeRate sets the fee rate in sat/vB returned by EstimateFeeRate. Until
+// one is set the fake chain has no fee estimate.
+func (fc *FakeChain) SetFeeRate(feeRate float64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.feeRate = feeRate
+}
+
+// EstimateFeeRate returns the fee rate set with SetFeeRate for any target
+func (fc *FakeChain) EstimateFeeRate(ctx context.Context, targetBlocks int) (float64, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if fc.feeRate <= 0 {
+		return 0, fmt.Errorf("no fee estimate")
+	}
+	return fc.feeRate, nil
+}
+
+// GetBlockHash gets the hash of the active chain's block at a height
+func (fc *FakeChain) GetBlockHash(ctx context.Context, height int64) (string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if height < 0 || height >= int64(len(fc.blockHashes)) {
+		return "", fmt.Errorf("block height out of range: %d", height)
+	}
+	return fc.blockHashes[height], nil
+}
+
+// GetTransaction gets a transaction by id
+func (fc *FakeChain) GetTransaction(ctx context.Context, txid string) (*Transaction, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	tx, exists := fc.txs[txid]
+	if !exists {
+		return nil, fmt.Errorf("transaction %s: %w", txid, ErrNotFound)
+	}
+
+	copied := *tx
+	return &copied, nil
+}
+
+// GetAddressUTXOs gets the outputs paying to an address that no known
+// transaction spends
+func (fc *FakeChain) GetAddressUTXOs(ctx context.Context, address string) ([]UTXO, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	var utxos []UTXO
+	for _, txid := range fc.txids {
+		tx := fc.txs[txid]
+		if tx == nil {
+			continue
+		}
+		for index, vout := range tx.Vout {
+			if vout.ScriptPubKeyAddress != address || fc.spentBy(tx.TXID, index) != "" {
+				continue
+			}
+			utxos = append(utxos, UTXO{
+				TXID:   tx.TXID,
+				Vout:   index,
+				Value:  vout.Value,
+				Status: tx.Status,
+			})
+		}
+	}
+
+	return utxos, nil
+}
+
+// BroadcastTransaction adds a transaction to the mempool. Every input must
+// spend a known, unspent fake output; signatures are not checked.
+func (fc *FakeChain) BroadcastTransaction(ctx context.Context, msgTx *wire.MsgTx) (string, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	tx := &Transaction{
+		TXID:     msgTx.TxHash().String(),
+		Version:  int(msgTx.Version),
+		Locktime: int64(msgTx.LockTime),
+	}
+	if _, exists := fc.txs[tx.TXID]; exists {
+		return "", fmt.Errorf("transaction already known: %s", tx.TXID)
+	}
+
+	for _, txIn := range msgTx.TxIn {
+		prevTXID := txIn.PreviousOutPoint.Hash.String()
+		prevVout := int(txIn.PreviousOutPoint.Index)
+
+		prevTx, exists := fc.txs[prevTXID]
+		if !exists || prevVout >= len(prevTx.Vout) {
+			return "", fmt.Errorf("missing input %s:%d", prevTXID, prevVout)
+		}
+		if spender := fc.spentBy(prevTXID, prevVout); spender != "" {
+			return "", fmt.Errorf("input %s:%d already spent by %s", prevTXID, prevVout, spender)
+		}
+
+		tx.Vin = append(tx.Vin, Vin{
+			TXID:    prevTXID,
+			Vout:    prevVout,
+			Prevout: prevTx.Vout[prevVout],
+		})
+	}
+
+	for _, txOut := range msgTx.TxOut {
+		vout := Vout{
+			ScriptPubKey: hex.EncodeToString(txOut.PkScript),
+			Value:        txOut.Value,
+		}
+		class, addresses, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript, fc.netParams)
+		if err == nil && len(addresses) == 1 {
+			vout.ScriptPubKeyType = class.String()
+			vout.ScriptPubKeyAddress = addresses[0].EncodeAddress()
+		}
+		tx.Vout = append(tx.Vout, vout)
+	}
+
+	fc.txs[tx.TXID] = tx
+	fc.txids = append(fc.txids, tx.TXID)
+	fc.mempool = append(fc.mempool, tx)
+	return tx.TXID, nil
+}
@@ -0,0 +1,306 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// FakeZMQPublisher is a minimal ZMTP 3.0 PUB socket standing in for
+// bitcoind's ZMQ notifications in tests and local development. It speaks
+// just enough of the protocol for a ZMQNotifier to subscribe to it.
+type FakeZMQPublisher struct {
+	listener net.Listener
+
+	mu          sync.Mutex
+	subscribers map[net.Conn]*fakeZMQSubscriber
+	sequences   map[string]uint32
+	wg          sync.WaitGroup
+}
+
+// fakeZMQSubscriber is a connected subscriber and the topic prefixes it wants
+type fakeZMQSubscriber struct {
+	mu       sync.Mutex
+	prefixes []string
+}
+
+// NewFakeZMQPublisher starts a publisher listening on a random local port
+func NewFakeZMQPublisher() (*FakeZMQPublisher, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	fp := &FakeZMQPublisher{
+		listener:    listener,
+		subscribers: make(map[net.Conn]*fakeZMQSubscriber),
+		sequences:   make(map[string]uint32),
+	}
+
+	fp.wg.Add(1)
+	go fp.accept()
+	return fp, nil
+}
+
+// Addr returns the endpoint subscribers connect to
+func (fp *FakeZMQPublisher) Addr() string {
+	return "tcp://" + fp.listener.Addr().String()
+}
+
+// WaitForSubscriptions blocks until subscribers hold at least n topic
+// subscriptions in total, so nothing published afterwards is dropped
+func (fp *FakeZMQPublisher) WaitForSubscriptions(n int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		fp.mu.Lock()
+		count := 0
+		for _, sub := range fp.subscribers {
+			sub.mu.Lock()
+			count += len(sub.prefixes)
+			sub.mu.Unlock()
+		}
+		fp.mu.Unlock()
+
+		if count >= n {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d subscriptions, have %d", n, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// PublishTx publishes a rawtx notification
+func (fp *FakeZMQPublisher) PublishTx(tx *wire.MsgTx) error {
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return fmt.Errorf("failed to serialize transaction: %w", err)
+	}
+	return fp.publish(zmqTopicRawTx, buf.Bytes())
+}
+
+// PublishBlock publishes a hashblock notification for a hex block hash
+func (fp *FakeZMQPublisher) PublishBlock(blockHash string) error {
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		return fmt.Errorf("invalid block hash: %w", err)
+	}
+	return fp.publish(zmqTopicHashBlock, hash)
+}
+
+// Close stops the publisher and disconnects all subscribers
+func (fp *FakeZMQPublisher) Close() error {
+	err := fp.listener.Close()
+
+	fp.mu.Lock()
+	for conn := range fp.subscribers {
+		conn.Close()
+	}
+	fp.mu.Unlock()
+
+	fp.wg.Wait()
+	return err
+}
+
+// publish sends a [topic, body, sequence] message to every matching subscriber
+func (fp *FakeZMQPublisher) publish(topic string, body []byte) error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	sequence := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sequence, fp.sequences[topic])
+	fp.sequences[topic]++
+
+	for conn, sub := range fp.subscribers {
+		if !sub.wants(topic) {
+			continue
+		}
+		if err := writeZMTPMessage(conn, [][]byte{[]byte(topic), body, sequence}); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// accept handles incoming subscriber connections
+func (fp *FakeZMQPublisher) accept() {
+	defer fp.wg.Done()
+
+	for {
+		conn, err := fp.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		sub := &fakeZMQSubscriber{}
+		fp.mu.Lock()
+		fp.subscribers[conn] = sub
+		fp.mu.Unlock()
+
+		fp.wg.Add(1)
+		go func() {
+			defer fp.wg.Done()
+			fp.serve(conn, sub)
+
+			fp.mu.Lock()
+			delete(fp.subscribers, conn)
+			fp.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// serve performs the ZMTP handshake and then records subscriptions
+func (fp *FakeZMQPublisher) serve(conn net.Conn, sub *fakeZMQSubscriber) {
+	if err := zmtpHandshake(conn, "PUB"); err != nil {
+		return
+	}
+
+	for {
+		flag, frame, err := readZMTPFrame(conn)
+		if err != nil {
+			return
+		}
+		// A subscription is a message frame of 0x01 followed by the prefix
+		if flag&zmtpFlagCommand != 0 || len(frame) == 0 || frame[0] != 1 {
+			continue
+		}
+
+		sub.mu.Lock()
+		sub.prefixes = append(sub.prefixes, string(frame[1:]))
+		sub.mu.Unlock()
+	}
+}
+
+// wants reports whether the subscriber subscribed to a prefix of topic
+func (sub *fakeZMQSubscriber) wants(topic string) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	for _, prefix := range sub.prefixes {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ZMTP frame flags
+const (
+	zmtpFlagMore    = 0x01
+	zmtpFlagLong    = 0x02
+	zmtpFlagCommand = 0x04
+)
+
+// zmtpHandshake exchanges greetings and READY commands using the NULL mechanism
+func zmtpHandshake(conn net.Conn, socketType string) error {
+	greeting := make([]byte, 64)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3
+	copy(greeting[12:], "NULL")
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	peer := make([]byte, 64)
+	if _, err := io.ReadFull(conn, peer); err != nil {
+		return err
+	}
+	if peer[0] != 0xff || peer[9] != 0x7f {
+		return errors.New("invalid ZMTP greeting")
+	}
+
+	var ready bytes.Buffer
+	ready.WriteByte(byte(len("READY")))
+	ready.WriteString("READY")
+	ready.WriteByte(byte(len("Socket-Type")))
+	ready.WriteString("Socket-Type")
+	binary.Write(&ready, binary.BigEndian, uint32(len(socketType)))
+	ready.WriteString(socketType)
+	if err := writeZMTPFrame(conn, zmtpFlagCommand, ready.Bytes()); err != nil {
+		return err
+	}
+
+	flag, _, err := readZMTPFrame(conn)
+	if err != nil {
+		return err
+	}
+	if flag&zmtpFlagCommand == 0 {
+		return errors.New("expected ZMTP READY command")
+	}
+	return nil
+}
+
+// writeZMTPMessage writes a multipart message
+func writeZMTPMessage(w io.Writer, parts [][]byte) error {
+	for i, part := range parts {
+		var flag byte
+		if i < len(parts)-1 {
+			flag = zmtpFlagMore
+		}
+		if err := writeZMTPFrame(w, flag, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZMTPFrame writes a single frame, using the long size form when needed
+func writeZMTPFrame(w io.Writer, flag byte, body []byte) error {
+	var header []byte
+	if len(body) > 255 {
+		header = make([]byte, 9)
+		header[0] = flag | zmtpFlagLong
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+	} else {
+		header = []byte{flag, byte(len(body))}
+	}
+
+	if _, err := w.Write(append(header, body...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readZMTPFrame reads a single frame
+func readZMTPFrame(r io.Reader) (byte, []byte, error) {
+	var flag [1]byte
+	if _, err := io.ReadFull(r, flag[:]); err != nil {
+		return 0, nil, err
+	}
+
+	var size uint64
+	if flag[0]&zmtpFlagLong != 0 {
+		var sizeBuf [8]byte
+		if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(sizeBuf[:])
+	} else {
+		var sizeBuf [1]byte
+		if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(sizeBuf[0])
+	}
+	if size > 1<<20 {
+		return 0, nil, errors.New("ZMTP frame too large")
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return flag[0], body, nil
+}
@@ -0,0 +1,289 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+const (
+	// BIP84Purpose is the BIP43 purpose used for native SegWit accounts
+	BIP84Purpose = 84
+
+	// externalChain is the BIP44 change level used for receiving addresses
+	externalChain = 0
+
+	// accountDepth is the depth of an m/purpose'/coin'/account' key
+	accountDepth = 3
+)
+
+// ErrWatchOnly is returned when a private key is requested from a keychain
+// that was loaded from an extended public key
+var ErrWatchOnly = errors.New("keychain is watch-only")
+
+// HDKeychain derives deposit keys from a BIP32 account-level extended key.
+// Every deposit address is the external chain child m/purpose'/coin'/account'/0/index,
+// so a single seed backup is enough to regenerate all of them.
+type HDKeychain struct {
+	account     *hdkeychain.ExtendedKey
+	external    *hdkeychain.ExtendedKey
+	addressType AddressType
+	netParams   *chaincfg.Params
+
+	// accountPath is the path of the account key from the master key. It is
+	// derived for master keys; for account-level keys the purpose and coin
+	// are assumed from the address type and network unless told explicitly.
+	accountPath []uint32
+	// fromMaster is set when the account key was derived from a master key,
+	// whose account path is then known for certain
+	fromMaster bool
+
+	// masterFingerprint identifies the seed in PSBT key origins, it is only
+	// known when the keychain was loaded from a master key or told explicitly
+	masterFingerprint uint32
+}
+
+// KeyOrigin tells an offline signer which of its keys signs an input
+type KeyOrigin struct {
+	PubKey            *btcec.PublicKey
+	MasterFingerprint uint32
+	Path              []uint32
+}
+
+// NewHDKeychain creates a keychain from an xprv or xpub string. Master keys
+// (depth 0) are derived down to the first account for the address type's
+// purpose; account-level keys are used as is, with the account index they
+// were derived at. An xpub creates a watch-only keychain.
+func NewHDKeychain(extendedKey string, addressType AddressType, netParams *chaincfg.Params) (*HDKeychain, error) {
+	key, err := hdkeychain.NewKeyFromString(extendedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse extended key: %w", err)
+	}
+
+	if !key.IsForNet(netParams) {
+		return nil, fmt.Errorf("extended key is not for network %s", netParams.Name)
+	}
+
+	account := key
+	accountPath := []uint32{
+		hdkeychain.HardenedKeyStart + addressType.Purpose(),
+		hdkeychain.HardenedKeyStart + netParams.HDCoinType,
+		hdkeychain.HardenedKeyStart + 0,
+	}
+	var masterFingerprint uint32
+	switch key.Depth() {
+	case 0:
+		masterFingerprint, err = keyFingerprint(key)
+		if err != nil {
+			return nil, err
+		}
+		if !key.IsPrivate() {
+			return nil, fmt.Errorf("a master xpub cannot derive hardened accounts, provide an account-level xpub")
+		}
+		for _, child := range accountPath {
+			account, err = account.Derive(child)
+			if err != nil {
+				return nil, fmt.Errorf("failed to derive account key: %w", err)
+			}
+		}
+	case accountDepth:
+		if key.ChildIndex() < hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("account-level key was derived at non-hardened index %d", key.ChildIndex())
+		}
+		accountPath[2] = key.ChildIndex()
+	default:
+		return nil, fmt.Errorf("extended key must be a master or account-level key, got depth %d", key.Depth())
+	}
+
+	external, err := account.Derive(externalChain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive external chain: %w", err)
+	}
+
+	return &HDKeychain{
+		account:     account,
+		external:    external,
+		addressType: addressType,
+		netParams:   netParams,
+		accountPath: accountPath,
+		fromMaster:  key.Depth() == 0,
+
+		masterFingerprint: masterFingerprint,
+	}, nil
+}
+
+// keyFingerprint returns the BIP32 fingerprint of a key in the byte order
+// PSBT key origins use
+func keyFingerprint(key *hdkeychain.ExtendedKey) (uint32, error) {
+	pubKey, err := key.ECPubKey()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get public key: %w", err)
+	}
+	return binary.LittleEndian.Uint32(btcutil.Hash160(pubKey.SerializeCompressed())[:4]), nil
+}
+
+// SetMasterFingerprint sets the fingerprint of the master key an
+// account-level key was derived from, as 8 hex characters
+func (k *HDKeychain) SetMasterFingerprint(fingerprint string) error {
+	raw, err := hex.DecodeString(fingerprint)
+	if err != nil || len(raw) != 4 {
+		return fmt.Errorf("master fingerprint must be 8 hex characters: %q", fingerprint)
+	}
+	k.masterFingerprint = binary.LittleEndian.Uint32(raw)
+	return nil
+}
+
+// SetAccountPath sets the path an account-level key was derived at, such as
+// m/84'/0'/0', for keys whose purpose or coin differ from the address type and
+// network. The path must end at the key's own account index.
+func (k *HDKeychain) SetAccountPath(path string) error {
+	parsed, err := parseAccountPath(path)
+	if err != nil {
+		return err
+	}
+	if parsed[2] != k.account.ChildIndex() {
+		return fmt.Errorf("account path %s does not end at the key's account %d'",
+			path, k.account.ChildIndex()-hdkeychain.HardenedKeyStart)
+	}
+	if k.fromMaster && formatPath(parsed) != formatPath(k.accountPath) {
+		return fmt.Errorf("account path %s differs from %s, the path derived from the master key",
+			path, formatPath(k.accountPath))
+	}
+	k.accountPath = parsed
+	return nil
+}
+
+// parseAccountPath parses an m/purpose'/coin'/account' path, all of whose
+// levels must be hardened
+func parseAccountPath(path string) ([]uint32, error) {
+	levels := strings.Split(path, "/")
+	if len(levels) != accountDepth+1 || levels[0] != "m" {
+		return nil, fmt.Errorf("account path must be m/purpose'/coin'/account': %q", path)
+	}
+	parsed := make([]uint32, 0, accountDepth)
+	for _, level := range levels[1:] {
+		digits, hardened := strings.CutSuffix(level, "'")
+		if !hardened {
+			digits, hardened = strings.CutSuffix(level, "h")
+		}
+		index, err := strconv.ParseUint(digits, 10, 31)
+		if !hardened || err != nil {
+			return nil, fmt.Errorf("account path levels must be hardened indexes: %q", path)
+		}
+		parsed = append(parsed, hdkeychain.HardenedKeyStart+uint32(index))
+	}
+	return parsed, nil
+}
+
+// formatPath formats a BIP32 path, marking hardened levels with '
+func formatPath(path []uint32) string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, level := range path {
+		if level >= hdkeychain.HardenedKeyStart {
+			fmt.Fprintf(&b, "/%d'", level-hdkeychain.HardenedKeyStart)
+		} else {
+			fmt.Fprintf(&b, "/%d", level)
+		}
+	}
+	return b.String()
+}
+
+// AddressType returns the type of the addresses derived from this keychain
+func (k *HDKeychain) AddressType() AddressType {
+	return k.addressType
+}
+
+// IsWatchOnly reports whether the keychain can only derive public keys
+func (k *HDKeychain) IsWatchOnly() bool {
+	return !k.account.IsPrivate()
+}
+
+// Fingerprint identifies the account key so that derivation indexes from
+// different seeds are never mixed up
+func (k *HDKeychain) Fingerprint() string {
+	pubKey, err := k.account.ECPubKey()
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(btcutil.Hash160(pubKey.SerializeCompressed())[:4])
+}
+
+// AccountXpub returns the account-level extended public key, suitable for a
+// watch-only deployment
+func (k *HDKeychain) AccountXpub() (string, error) {
+	pub, err := k.account.Neuter()
+	if err != nil {
+		return "", fmt.Errorf("failed to neuter account key: %w", err)
+	}
+	return pub.String(), nil
+}
+
+// DerivationPath returns the full BIP32 path for the deposit key at index
+func (k *HDKeychain) DerivationPath(index uint32) string {
+	return formatPath(k.path(index))
+}
+
+// path returns the levels of the full BIP32 path for the deposit key at index
+func (k *HDKeychain) path(index uint32) []uint32 {
+	path := make([]uint32, 0, len(k.accountPath)+2)
+	path = append(path, k.accountPath...)
+	return append(path, externalChain, index)
+}
+
+// KeyOrigin returns the public key and full BIP32 path of the deposit key at index
+func (k *HDKeychain) KeyOrigin(index uint32) (*KeyOrigin, error) {
+	pubKey, err := k.DerivePublicKey(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyOrigin{
+		PubKey:            pubKey,
+		MasterFingerprint: k.masterFingerprint,
+		Path:              k.path(index),
+	}, nil
+}
+
+// DerivePublicKey derives the public key for the deposit key at index
+func (k *HDKeychain) DerivePublicKey(index uint32) (*btcec.PublicKey, error) {
+	child, err := k.deriveChild(index)
+	if err != nil {
+		return nil, err
+	}
+	return child.ECPubKey()
+}
+
+// DerivePrivateKey derives the private key for the deposit key at index
+func (k *HDKeychain) DerivePrivateKey(index uint32) (*btcec.PrivateKey, error) {
+	if k.IsWatchOnly() {
+		return nil, ErrWatchOnly
+	}
+
+	child, err := k.deriveChild(index)
+	if err != nil {
+		return nil, err
+	}
+	return child.ECPrivKey()
+}
+
+// deriveChild derives the non-hardened external chain child at index
+func (k *HDKeychain) deriveChild(index uint32) (*hdkeychain.ExtendedKey, error) {
+	if index >= hdkeychain.HardenedKeyStart {
+		return nil, fmt.Errorf("derivation index %d out of range", index)
+	}
+
+	child, err := k.external.Derive(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive child %d: %w", index, err)
+	}
+	return child, nil
+}
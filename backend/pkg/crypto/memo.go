@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"hellomix-backend/pkg/money"
+)
+
+// MemoPolicy says whether payouts on a chain take a memo, the destination
+// tag exchanges use to credit deposits to one of their customers
+type MemoPolicy string
+
+// Memo policies
+const (
+	MemoForbidden MemoPolicy = "forbidden"
+	MemoOptional  MemoPolicy = "optional"
+	MemoRequired  MemoPolicy = "required"
+)
+
+// MemoRule describes the memos a chain accepts
+type MemoRule struct {
+	Policy MemoPolicy
+	// MaxBytes is the largest memo, in bytes of UTF-8
+	MaxBytes int
+}
+
+// memoRules are the memo rules of the chains that carry memos, by address
+// format. Payouts on other chains cannot carry one.
+var memoRules = map[money.AddressFormat]MemoRule{
+	// The SPL Memo program takes any UTF-8 text; the bound keeps a memo
+	// well within a transfer transaction
+	money.AddressFormatSolana: {Policy: MemoOptional, MaxBytes: 256},
+}
+
+// MemoRuleFor returns the memo rule of the chain a currency is paid out on
+func MemoRuleFor(currency string) MemoRule {
+	entry, err := money.LookupCurrency(currency)
+	if err != nil {
+		return MemoRule{Policy: MemoForbidden}
+	}
+	rule, ok := memoRules[entry.AddressFormat]
+	if !ok {
+		return MemoRule{Policy: MemoForbidden}
+	}
+	return rule
+}
+
+// ValidateMemo validates the memo of an output in a currency against the
+// memo rule of its chain, returning an *AddressError describing why a memo
+// was refused. An empty memo is no memo.
+func (av *AddressValidator) ValidateMemo(memo, currency string) error {
+	rule := MemoRuleFor(currency)
+	switch {
+	case memo == "" && rule.Policy == MemoRequired:
+		return addressError(AddressReasonMemoRequired, "payouts in %s need a memo", currency)
+	case memo == "":
+		return nil
+	case rule.Policy == MemoForbidden:
+		return addressError(AddressReasonMemoForbidden, "payouts in %s cannot carry a memo", currency)
+	case !utf8.ValidString(memo):
+		return addressError(AddressReasonInvalidMemo, "memo is not valid UTF-8")
+	case len(memo) > rule.MaxBytes:
+		return addressError(AddressReasonInvalidMemo, "memo is %d bytes, at most %d are allowed", len(memo), rule.MaxBytes)
+	}
+	for _, r := range memo {
+		if unicode.IsControl(r) {
+			return addressError(AddressReasonInvalidMemo, "memo contains control characters")
+		}
+	}
+	return nil
+}
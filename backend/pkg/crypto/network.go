@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Network is a Bitcoin network a deployment runs on. It selects the chain
+// parameters, and with them the address encodings, together with the public
+// explorer used as the default Esplora backend.
+type Network string
+
+// Networks
+const (
+	NetworkMainnet  Network = "mainnet"
+	NetworkTestnet3 Network = "testnet3"
+	NetworkTestnet4 Network = "testnet4"
+	NetworkSignet   Network = "signet"
+	NetworkRegtest  Network = "regtest"
+)
+
+// networkInfo describes a network
+type networkInfo struct {
+	params *chaincfg.Params
+	// esploraURL is the public Esplora API of the network, if any
+	esploraURL string
+	// bitcoindChain is the chain name bitcoind reports for the network
+	bitcoindChain string
+}
+
+// networks are the supported networks, in the order they are tried when
+// naming the network of an address
+var networks = []struct {
+	network Network
+	info    networkInfo
+}{
+	{NetworkMainnet, networkInfo{&chaincfg.MainNetParams, "https://blockstream.info/api", "main"}},
+	{NetworkTestnet3, networkInfo{&chaincfg.TestNet3Params, "https://blockstream.info/testnet/api", "test"}},
+	{NetworkTestnet4, networkInfo{&TestNet4Params, "https://mempool.space/testnet4/api", "testnet4"}},
+	{NetworkSignet, networkInfo{&chaincfg.SigNetParams, "https://mempool.space/signet/api", "signet"}},
+	{NetworkRegtest, networkInfo{&chaincfg.RegressionNetParams, "", "regtest"}},
+}
+
+// testnet4GenesisHash is the hash of the testnet4 genesis block
+var testnet4GenesisHash, _ = chainhash.NewHashFromStr("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043")
+
+// TestNet4Params are the chain parameters of testnet4 (BIP94), which btcd
+// does not define. Testnet4 shares the address encodings and key versions
+// of testnet3; only what identifies the network differs.
+var TestNet4Params = func() chaincfg.Params {
+	params := chaincfg.TestNet3Params
+	params.Name = string(NetworkTestnet4)
+	params.Net = wire.BitcoinNet(0x283f161c)
+	params.DefaultPort = "48333"
+	params.DNSSeeds = []chaincfg.DNSSeed{
+		{Host: "seed.testnet4.bitcoin.sprovoost.nl", HasFiltering: true},
+		{Host: "seed.testnet4.wiz.biz", HasFiltering: true},
+	}
+	// The testnet3 genesis block and checkpoints do not apply
+	params.GenesisBlock = nil
+	params.GenesisHash = testnet4GenesisHash
+	params.Checkpoints = nil
+	return params
+}()
+
+// ParseNetwork parses a network name from configuration
+func ParseNetwork(s string) (Network, error) {
+	network := Network(strings.ToLower(strings.TrimSpace(s)))
+	// testnet names the long-standing test network
+	if network == "testnet" {
+		network = NetworkTestnet3
+	}
+	if _, ok := network.lookup(); !ok {
+		return "", fmt.Errorf("unknown network: %q", s)
+	}
+	return network, nil
+}
+
+// lookup returns the description of a network
+func (n Network) lookup() (networkInfo, bool) {
+	for _, known := range networks {
+		if known.network == n {
+			return known.info, true
+		}
+	}
+	return networkInfo{}, false
+}
+
+// info returns the description of a network, which has to be known
+func (n Network) info() networkInfo {
+	info, ok := n.lookup()
+	if !ok {
+		panic(fmt.Sprintf("unknown network: %q", string(n)))
+	}
+	return info
+}
+
+// Params returns the chain parameters of the network
+func (n Network) Params() *chaincfg.Params {
+	return n.info().params
+}
+
+// HRP returns the human-readable part of the network's SegWit addresses
+func (n Network) HRP() string {
+	return n.info().params.Bech32HRPSegwit
+}
+
+// EsploraURL returns the public Esplora API of the network, empty for
+// regtest, which only exists locally
+func (n Network) EsploraURL() string {
+	return n.info().esploraURL
+}
+
+// BitcoindChain returns the chain name bitcoind reports for the network
+func (n Network) BitcoindChain() string {
+	return n.info().bitcoindChain
+}
+
+// IsMainnet reports whether n is Bitcoin mainnet
+func (n Network) IsMainnet() bool {
+	return n == NetworkMainnet
+}
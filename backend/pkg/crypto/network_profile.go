@@ -0,0 +1,72 @@
+package crypto
+
+import "hellomix-backend/pkg/money"
+
+// EVM chain IDs payouts default to
+const (
+	EthereumMainnetChainID int64 = 1
+	EthereumSepoliaChainID int64 = 11155111
+	PolygonMainnetChainID  int64 = 137
+	PolygonAmoyChainID     int64 = 80002
+)
+
+// NetworkProfile is the set of networks a deployment pays out on. Output
+// addresses are validated against it, so an address of another network,
+// such as a testnet address on a mainnet deployment, is refused.
+type NetworkProfile struct {
+	// Bitcoin is the Bitcoin network
+	Bitcoin Network
+	// CardanoNetworkID is the Cardano network ID, 1 on mainnet and 0 on
+	// the test networks
+	CardanoNetworkID byte
+	// EVMChainID is the chain ID of ETH and ERC-20 token payouts. EVM
+	// addresses carry no network, only EIP-3770 chain-prefixed ones are
+	// checked against it.
+	EVMChainID int64
+	// PolygonChainID is the chain ID of MATIC and Polygon token payouts
+	PolygonChainID int64
+}
+
+// NewNetworkProfile creates the network profile of a deployment on the given
+// Bitcoin network. The other chains follow it: their mainnets on Bitcoin
+// mainnet and their test networks otherwise. A zero evmChainID selects
+// Ethereum mainnet or Sepolia accordingly; Polygon payouts go to Polygon
+// mainnet or Amoy.
+func NewNetworkProfile(network Network, evmChainID int64) NetworkProfile {
+	profile := NetworkProfile{
+		Bitcoin:          network,
+		CardanoNetworkID: cardanoTestnetID,
+		EVMChainID:       evmChainID,
+		PolygonChainID:   PolygonAmoyChainID,
+	}
+	if profile.IsMainnet() {
+		profile.CardanoNetworkID = cardanoMainnetID
+		profile.PolygonChainID = PolygonMainnetChainID
+	}
+	if profile.EVMChainID == 0 {
+		profile.EVMChainID = EthereumSepoliaChainID
+		if profile.IsMainnet() {
+			profile.EVMChainID = EthereumMainnetChainID
+		}
+	}
+	return profile
+}
+
+// Name returns the name of the profile's Bitcoin network
+func (p NetworkProfile) Name() string {
+	return string(p.Bitcoin)
+}
+
+// IsMainnet reports whether the profile pays out on mainnets
+func (p NetworkProfile) IsMainnet() bool {
+	return p.Bitcoin.IsMainnet()
+}
+
+// EVMChain returns the chain ID payouts on an EVM network of the currency
+// registry are sent on
+func (p NetworkProfile) EVMChain(network string) int64 {
+	if network == money.NetworkPolygon {
+		return p.PolygonChainID
+	}
+	return p.EVMChainID
+}
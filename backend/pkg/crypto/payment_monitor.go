@@ -2,229 +2,212 @@ package crypto
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"time"
+	"math"
+	"sync"
 
 	"github.com/btcsuite/btcd/btcec/v2"
-	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/sirupsen/logrus"
 )
 
-// WalletManager handles Bitcoin wallet operations with persistent storage
+// WalletManager handles Bitcoin key and address derivation. It does not keep
+// any private keys itself; every key is derived on demand from the keychain.
 type WalletManager struct {
-	testnet     bool
-	httpClient  *http.Client
-	addresses   map[string]*btcec.PrivateKey // address -> private key mapping
-	netParams   *chaincfg.Params
+	keychain  *HDKeychain
+	netParams *chaincfg.Params
 }
 
-// NewWalletManager creates a new wallet manager
-func NewWalletManager(testnet bool) *WalletManager {
-	var netParams *chaincfg.Params
-	if testnet {
-		netParams = &chaincfg.TestNet3Params
-	} else {
-		netParams = &chaincfg.MainNetParams
-	}
-
+// NewWalletManager creates a new wallet manager backed by an HD keychain
+func NewWalletManager(keychain *HDKeychain) *WalletManager {
 	return &WalletManager{
-		testnet:    testnet,
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		addresses:  make(map[string]*btcec.PrivateKey),
-		netParams:  netParams,
+		keychain:  keychain,
+		netParams: keychain.netParams,
 	}
 }
 
-// GenerateAddressWithKey generates a new Bitcoin address and stores the private key
-func (wm *WalletManager) GenerateAddressWithKey() (string, error) {
-	// Generate a random private key
-	privateKey, err := btcec.NewPrivateKey()
+// Keychain returns the HD keychain addresses are derived from
+func (wm *WalletManager) Keychain() *HDKeychain {
+	return wm.keychain
+}
+
+// AddressType returns the type of the deposit addresses this manager derives
+func (wm *WalletManager) AddressType() AddressType {
+	return wm.keychain.AddressType()
+}
+
+// DeriveAddress derives the deposit address at the given index
+func (wm *WalletManager) DeriveAddress(index uint32) (string, error) {
+	pubKey, err := wm.keychain.DerivePublicKey(index)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate private key: %w", err)
+		return "", fmt.Errorf("failed to derive public key: %w", err)
 	}
 
-	// Create a pay-to-pubkey-hash address
-	pubKey := privateKey.PubKey()
-	pubKeyHash := btcutil.Hash160(pubKey.SerializeCompressed())
-	address, err := btcutil.NewAddressPubKeyHash(pubKeyHash, wm.netParams)
+	address, err := EncodeAddress(pubKey, wm.keychain.AddressType(), wm.netParams)
 	if err != nil {
 		return "", fmt.Errorf("failed to create address: %w", err)
 	}
 
 	addressStr := address.EncodeAddress()
-	
-	// Store the private key for this address
-	wm.addresses[addressStr] = privateKey
-	
-	logrus.Infof("Generated new Bitcoin address: %s", addressStr)
+
+	logrus.Infof("Derived %s Bitcoin address %s at index %d", wm.keychain.AddressType(), addressStr, index)
 	return addressStr, nil
 }
 
-// GetPrivateKey retrieves the private key for an address
-func (wm *WalletManager) GetPrivateKey(address string) (*btcec.PrivateKey, error) {
-	privateKey, exists := wm.addresses[address]
-	if !exists {
-		return nil, fmt.Errorf("private key not found for address: %s", address)
-	}
-	return privateKey, nil
+// DerivePrivateKey derives the private key for the deposit address at the given index
+func (wm *WalletManager) DerivePrivateKey(index uint32) (*btcec.PrivateKey, error) {
+	return wm.keychain.DerivePrivateKey(index)
 }
 
-// BlockchainExplorer handles blockchain API interactions
-type BlockchainExplorer struct {
-	testnet    bool
-	httpClient *http.Client
-	apiURL     string
+// PaymentMonitor monitors Bitcoin payments. Besides querying a chain
+// backend it supports a push mode, matching transactions announced by a
+// ChainNotifier against the watched addresses held in memory.
+type PaymentMonitor struct {
+	backend   ChainBackend
+	netParams *chaincfg.Params
+
+	mu      sync.RWMutex
+	watched map[string]struct{}
 }
 
-// NewBlockchainExplorer creates a new blockchain explorer
-func NewBlockchainExplorer(testnet bool) *BlockchainExplorer {
-	apiURL := "https://blockstream.info/api"
-	if testnet {
-		apiURL = "https://blockstream.info/testnet/api"
+// NewPaymentMonitor creates a new payment monitor on top of a chain backend
+func NewPaymentMonitor(backend ChainBackend, netParams *chaincfg.Params) *PaymentMonitor {
+	return &PaymentMonitor{
+		backend:   backend,
+		netParams: netParams,
+		watched:   make(map[string]struct{}),
 	}
+}
 
-	return &BlockchainExplorer{
-		testnet:    testnet,
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		apiURL:     apiURL,
+// WatchAddress registers an address with backends that need to know about it
+// in advance, and adds it to the addresses matched in push mode
+func (pm *PaymentMonitor) WatchAddress(ctx context.Context, address string) error {
+	if watcher, ok := pm.backend.(AddressWatcher); ok {
+		if err := watcher.WatchAddress(ctx, address); err != nil {
+			return err
+		}
 	}
-}
 
-// AddressInfo represents address information from blockchain API
-type AddressInfo struct {
-	Address               string `json:"address"`
-	ChainStats            Stats  `json:"chain_stats"`
-	MempoolStats          Stats  `json:"mempool_stats"`
-	TotalReceived         int64  `json:"-"` // Will be calculated
-	ConfirmedBalance      int64  `json:"-"` // Will be calculated
-	UnconfirmedBalance    int64  `json:"-"` // Will be calculated
+	pm.mu.Lock()
+	pm.watched[address] = struct{}{}
+	pm.mu.Unlock()
+	return nil
 }
 
-// Stats represents transaction statistics
-type Stats struct {
-	FundedTxoCount int64 `json:"funded_txo_count"`
-	FundedTxoSum   int64 `json:"funded_txo_sum"`
-	SpentTxoCount  int64 `json:"spent_txo_count"`
-	SpentTxoSum    int64 `json:"spent_txo_sum"`
-	TxCount        int64 `json:"tx_count"`
-}
+// SetWatchedAddresses replaces the addresses matched in push mode
+func (pm *PaymentMonitor) SetWatchedAddresses(addresses []string) {
+	watched := make(map[string]struct{}, len(addresses))
+	for _, address := range addresses {
+		watched[address] = struct{}{}
+	}
 
-// Transaction represents a Bitcoin transaction
-type Transaction struct {
-	TXID     string `json:"txid"`
-	Version  int    `json:"version"`
-	Locktime int64  `json:"locktime"`
-	Vin      []Vin  `json:"vin"`
-	Vout     []Vout `json:"vout"`
-	Status   Status `json:"status"`
-	Fee      int64  `json:"fee"`
+	pm.mu.Lock()
+	pm.watched = watched
+	pm.mu.Unlock()
 }
 
-// Vin represents transaction input
-type Vin struct {
-	TXID    string `json:"txid"`
-	Vout    int    `json:"vout"`
-	Prevout Vout   `json:"prevout"`
-}
+// MatchTransaction returns the watched addresses paid by a transaction
+func (pm *PaymentMonitor) MatchTransaction(tx *wire.MsgTx) []string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var matched []string
+	seen := make(map[string]bool)
+	for _, txOut := range tx.TxOut {
+		_, addresses, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript, pm.netParams)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addresses {
+			address := addr.EncodeAddress()
+			if _, ok := pm.watched[address]; ok && !seen[address] {
+				seen[address] = true
+				matched = append(matched, address)
+			}
+		}
+	}
 
-// Vout represents transaction output
-type Vout struct {
-	ScriptPubKey        string `json:"scriptpubkey"`
-	ScriptPubKeyAsm     string `json:"scriptpubkey_asm"`
-	ScriptPubKeyType    string `json:"scriptpubkey_type"`
-	ScriptPubKeyAddress string `json:"scriptpubkey_address"`
-	Value               int64  `json:"value"`
+	return matched
 }
 
-// Status represents transaction status
-type Status struct {
-	Confirmed   bool  `json:"confirmed"`
-	BlockHeight int64 `json:"block_height"`
-	BlockHash   string `json:"block_hash"`
-	BlockTime   int64 `json:"block_time"`
+// PushEvent is chain activity relevant to the watched addresses: either a
+// transaction paying some of them or a new block
+type PushEvent struct {
+	TXID      string
+	Addresses []string
+	BlockHash string
 }
 
-// GetAddressInfo gets information about a Bitcoin address
-func (be *BlockchainExplorer) GetAddressInfo(ctx context.Context, address string) (*AddressInfo, error) {
-	url := fmt.Sprintf("%s/address/%s", be.apiURL, address)
-	
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := be.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	var addressInfo AddressInfo
-	if err := json.Unmarshal(body, &addressInfo); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+// Listen runs push mode, passing transactions that pay watched addresses and
+// every new block to handle. It blocks until ctx is cancelled or the notifier
+// fails.
+func (pm *PaymentMonitor) Listen(ctx context.Context, notifier ChainNotifier, handle func(PushEvent)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := make(chan ChainEvent, 64)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- notifier.Run(ctx, events)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errCh:
+			return err
+		case event := <-events:
+			if event.Tx != nil {
+				addresses := pm.MatchTransaction(event.Tx)
+				if len(addresses) == 0 {
+					continue
+				}
+				txid := event.Tx.TxHash().String()
+				logrus.Infof("Transaction %s pays watched addresses %v", txid, addresses)
+				handle(PushEvent{TXID: txid, Addresses: addresses})
+				continue
+			}
+			handle(PushEvent{BlockHash: event.BlockHash})
+		}
 	}
-
-	// Calculate balances
-	addressInfo.TotalReceived = addressInfo.ChainStats.FundedTxoSum + addressInfo.MempoolStats.FundedTxoSum
-	addressInfo.ConfirmedBalance = addressInfo.ChainStats.FundedTxoSum - addressInfo.ChainStats.SpentTxoSum
-	addressInfo.UnconfirmedBalance = addressInfo.MempoolStats.FundedTxoSum - addressInfo.MempoolStats.SpentTxoSum
-
-	return &addressInfo, nil
 }
 
-// GetAddressTransactions gets transactions for a Bitcoin address
-func (be *BlockchainExplorer) GetAddressTransactions(ctx context.Context, address string) ([]Transaction, error) {
-	url := fmt.Sprintf("%s/address/%s/txs", be.apiURL, address)
-	
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+// MonitorPayment monitors a payment to an address
+func (pm *PaymentMonitor) MonitorPayment(ctx context.Context, address string, expectedAmountSats int64) (*PaymentStatus, error) {
+	return pm.CheckPayment(ctx, address, expectedAmountSats)
+}
 
-	resp, err := be.httpClient.Do(req)
+// TipHeight gets the height of the best block, so a batch of checks can share it
+func (pm *PaymentMonitor) TipHeight(ctx context.Context) (int64, error) {
+	tipHeight, err := pm.backend.GetTipHeight(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status: %d", resp.StatusCode)
+		return 0, fmt.Errorf("failed to get tip height: %w", err)
 	}
+	return tipHeight, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// CheckPayment checks which payments have been received to an address. Every
+// output paying to the address counts toward the expected amount, so a user
+// may pay in several transactions.
+func (pm *PaymentMonitor) CheckPayment(ctx context.Context, address string, expectedAmount int64) (*PaymentStatus, error) {
+	tipHeight, err := pm.TipHeight(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, err
 	}
-
-	var transactions []Transaction
-	if err := json.Unmarshal(body, &transactions); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
-	}
-
-	return transactions, nil
+	return pm.CheckPaymentAt(ctx, address, expectedAmount, tipHeight)
 }
 
-// CheckPayment checks if a payment has been received to an address
-func (be *BlockchainExplorer) CheckPayment(ctx context.Context, address string, expectedAmount int64) (*PaymentStatus, error) {
-	addressInfo, err := be.GetAddressInfo(ctx, address)
+// CheckPaymentAt is CheckPayment with confirmations counted from a known tip height
+func (pm *PaymentMonitor) CheckPaymentAt(ctx context.Context, address string, expectedAmount int64, tipHeight int64) (*PaymentStatus, error) {
+	addressInfo, err := pm.backend.GetAddressInfo(ctx, address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get address info: %w", err)
 	}
 
-	transactions, err := be.GetAddressTransactions(ctx, address)
+	transactions, err := pm.backend.GetAddressTransactions(ctx, address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transactions: %w", err)
 	}
@@ -235,89 +218,117 @@ func (be *BlockchainExplorer) CheckPayment(ctx context.Context, address string,
 		TotalReceived:      addressInfo.TotalReceived,
 		ConfirmedBalance:   addressInfo.ConfirmedBalance,
 		UnconfirmedBalance: addressInfo.UnconfirmedBalance,
+		TipHeight:          tipHeight,
 		Transactions:       transactions,
 	}
 
-	// Check if payment is sufficient
-	if addressInfo.ConfirmedBalance >= expectedAmount {
-		status.Status = "confirmed"
-		status.Confirmations = 1 // At least 1 confirmation
-		
-		// Get exact confirmation count for the payment transaction
-		for _, tx := range transactions {
-			if tx.Status.Confirmed {
-				// Find if this transaction has an output to our address with sufficient amount
-				for _, vout := range tx.Vout {
-					if vout.ScriptPubKeyAddress == address && vout.Value >= expectedAmount {
-						// Calculate confirmations (simplified)
-						if tx.Status.BlockHeight > 0 {
-							// In a real implementation, you'd get current block height
-							status.Confirmations = 1 // Simplified
-						}
-						status.PaymentTXID = tx.TXID
-						break
-					}
-				}
+	// Collect every output paying to the address
+	for _, tx := range transactions {
+		for index, vout := range tx.Vout {
+			if vout.ScriptPubKeyAddress != address {
+				continue
 			}
-		}
-	} else if addressInfo.UnconfirmedBalance >= expectedAmount {
-		status.Status = "unconfirmed"
-		status.Confirmations = 0
-		
-		// Find the unconfirmed transaction
-		for _, tx := range transactions {
-			if !tx.Status.Confirmed {
-				for _, vout := range tx.Vout {
-					if vout.ScriptPubKeyAddress == address && vout.Value >= expectedAmount {
-						status.PaymentTXID = tx.TXID
-						break
-					}
-				}
+
+			output := ReceivedOutput{
+				TXID:  tx.TXID,
+				Vout:  index,
+				Value: vout.Value,
 			}
+			if tx.Status.Confirmed {
+				output.Confirmations = Confirmations(tipHeight, tx.Status.BlockHeight)
+				output.BlockHeight = tx.Status.BlockHeight
+				output.BlockHash = tx.Status.BlockHash
+			}
+			status.Outputs = append(status.Outputs, output)
 		}
-	} else {
-		status.Status = "pending"
-		status.Confirmations = 0
 	}
 
+	status.Summarize()
 	return status, nil
 }
 
+// GetTransaction looks up a payment transaction by id. Unlike the outputs at
+// an address, it can still be found after its outputs have been spent; the
+// error wraps ErrNotFound once it has left both the chain and the mempool.
+func (pm *PaymentMonitor) GetTransaction(ctx context.Context, txid string) (*Transaction, error) {
+	return pm.backend.GetTransaction(ctx, txid)
+}
+
+// IsInActiveChain reports whether the block at height still has the given
+// hash, i.e. it has not been orphaned by a reorg
+func (pm *PaymentMonitor) IsInActiveChain(ctx context.Context, blockHash string, height int64) (bool, error) {
+	activeHash, err := pm.backend.GetBlockHash(ctx, height)
+	if err != nil {
+		return false, fmt.Errorf("failed to get block hash at height %d: %w", height, err)
+	}
+	return activeHash == blockHash, nil
+}
+
 // PaymentStatus represents the status of a payment
 type PaymentStatus struct {
-	Address            string        `json:"address"`
-	ExpectedAmount     int64         `json:"expected_amount"`
-	TotalReceived      int64         `json:"total_received"`
-	ConfirmedBalance   int64         `json:"confirmed_balance"`
-	UnconfirmedBalance int64         `json:"unconfirmed_balance"`
-	Status             string        `json:"status"` // pending, unconfirmed, confirmed
-	Confirmations      int           `json:"confirmations"`
-	PaymentTXID        string        `json:"payment_txid,omitempty"`
-	Transactions       []Transaction `json:"transactions,omitempty"`
+	Address            string           `json:"address"`
+	ExpectedAmount     int64            `json:"expected_amount"`
+	TotalReceived      int64            `json:"total_received"`
+	ConfirmedBalance   int64            `json:"confirmed_balance"`
+	UnconfirmedBalance int64            `json:"unconfirmed_balance"`
+	ReceivedAmount     int64            `json:"received_amount"`
+	ConfirmedAmount    int64            `json:"confirmed_amount"`
+	Status             string           `json:"status"` // pending, unconfirmed, confirmed
+	Confirmations      int              `json:"confirmations"`
+	TipHeight          int64            `json:"tip_height"`
+	PaymentTXID        string           `json:"payment_txid,omitempty"`
+	Outputs            []ReceivedOutput `json:"outputs,omitempty"`
+	Transactions       []Transaction    `json:"transactions,omitempty"`
 }
 
-// PaymentMonitor monitors Bitcoin payments
-type PaymentMonitor struct {
-	explorer *BlockchainExplorer
-	wallet   *WalletManager
-}
+// Summarize recomputes the received amounts, confirmations and status from
+// the outputs
+func (ps *PaymentStatus) Summarize() {
+	ps.ReceivedAmount = 0
+	ps.ConfirmedAmount = 0
+	ps.Confirmations = 0
+	ps.PaymentTXID = ""
+
+	if len(ps.Outputs) == 0 {
+		ps.Status = "pending"
+		return
+	}
 
-// NewPaymentMonitor creates a new payment monitor
-func NewPaymentMonitor(testnet bool) *PaymentMonitor {
-	return &PaymentMonitor{
-		explorer: NewBlockchainExplorer(testnet),
-		wallet:   NewWalletManager(testnet),
+	// The payment is only as confirmed as its least confirmed output
+	ps.Status = "confirmed"
+	ps.Confirmations = ps.Outputs[0].Confirmations
+	ps.PaymentTXID = ps.Outputs[0].TXID
+	for _, output := range ps.Outputs {
+		ps.ReceivedAmount += output.Value
+		if output.Confirmations > 0 {
+			ps.ConfirmedAmount += output.Value
+		}
+		if output.Confirmations < ps.Confirmations {
+			ps.Confirmations = output.Confirmations
+		}
+		if output.Confirmations == 0 {
+			ps.Status = "unconfirmed"
+		}
 	}
 }
 
-// MonitorPayment monitors a payment to an address
-func (pm *PaymentMonitor) MonitorPayment(ctx context.Context, address string, expectedAmountSats int64) (*PaymentStatus, error) {
-	return pm.explorer.CheckPayment(ctx, address, expectedAmountSats)
+// ReceivedOutput is a single output paying to a monitored address
+type ReceivedOutput struct {
+	TXID          string `json:"txid"`
+	Vout          int    `json:"vout"`
+	Value         int64  `json:"value"`
+	Confirmations int    `json:"confirmations"`
+	BlockHeight   int64  `json:"block_height,omitempty"`
+	BlockHash     string `json:"block_hash,omitempty"`
 }
 
-// GeneratePaymentAddress generates a new address for receiving payments
-func (pm *PaymentMonitor) GeneratePaymentAddress() (string, error) {
-	return pm.wallet.GenerateAddressWithKey()
+// Confirmations returns the number of confirmations of a transaction mined
+// at blockHeight when the best block is at tipHeight
+func Confirmations(tipHeight, blockHeight int64) int {
+	if blockHeight <= 0 || tipHeight < blockHeight {
+		return 0
+	}
+	return int(tipHeight - blockHeight + 1)
 }
 
 // SatoshisToBTC converts satoshis to BTC
@@ -325,7 +336,8 @@ func SatoshisToBTC(satoshis int64) float64 {
 	return float64(satoshis) / 100000000.0
 }
 
-// BTCToSatoshis converts BTC to satoshis
+// BTCToSatoshis converts BTC to satoshis, rounding to the nearest satoshi
+// since most decimal amounts have no exact float64 representation
 func BTCToSatoshis(btc float64) int64 {
-	return int64(btc * 100000000.0)
+	return int64(math.Round(btc * 100000000.0))
 }
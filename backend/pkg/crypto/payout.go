@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// PayoutRecipient is an address receiving a percentage of a payout
+type PayoutRecipient struct {
+	Address    btcutil.Address
+	Percentage float64
+}
+
+// BuildPayoutTx builds an unsigned transaction paying amount satoshis, less
+// the network fee at feeRate sat/vB, to the recipients split by percentage.
+// The rest of the inputs is returned to change unless it would be dust, in
+// which case it is left to the miners. It returns the transaction, the value
+// paid to each recipient and the fee.
+func BuildPayoutTx(inputs []SweepInput, recipients []PayoutRecipient, amount int64, change btcutil.Address, feeRate int64) (*wire.MsgTx, []int64, int64, error) {
+	if len(inputs) == 0 {
+		return nil, nil, 0, fmt.Errorf("payout has no inputs")
+	}
+	if len(recipients) == 0 {
+		return nil, nil, 0, fmt.Errorf("payout has no recipients")
+	}
+
+	var total int64
+	tx := wire.NewMsgTx(2)
+	for _, input := range inputs {
+		txIn := wire.NewTxIn(&input.OutPoint, nil, nil)
+		// Signal replaceability so a stuck payout can be fee bumped
+		txIn.Sequence = wire.MaxTxInSequenceNum - 2
+		tx.AddTxIn(txIn)
+		total += input.Value
+	}
+	if amount > total {
+		return nil, nil, 0, fmt.Errorf("payout of %d sats exceeds the %d sats received", amount, total)
+	}
+
+	scripts := make([][]byte, len(recipients))
+	for i, recipient := range recipients {
+		script, err := txscript.PayToAddrScript(recipient.Address)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("failed to build script for %s: %w", recipient.Address, err)
+		}
+		scripts[i] = script
+	}
+	changeScript, err := txscript.PayToAddrScript(change)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to build change script: %w", err)
+	}
+
+	// The recipients pay the fee, estimated with the change output included
+	fee := estimateVSize(inputs, append(scripts, changeScript)) * feeRate
+	net := amount - fee
+	if net <= 0 {
+		return nil, nil, 0, fmt.Errorf("payout of %d sats does not cover the %d sats fee", amount, fee)
+	}
+
+	// Rounding leftovers go to the last recipient
+	values := make([]int64, len(recipients))
+	var allocated int64
+	for i, recipient := range recipients {
+		if i == len(recipients)-1 {
+			values[i] = net - allocated
+		} else {
+			values[i] = int64(float64(net) * recipient.Percentage / 100)
+		}
+		if values[i] < DustLimit {
+			return nil, nil, 0, fmt.Errorf("payout of %d sats to %s is below the dust limit", values[i], recipient.Address)
+		}
+		allocated += values[i]
+		tx.AddTxOut(wire.NewTxOut(values[i], scripts[i]))
+	}
+
+	if changeValue := total - amount; changeValue >= DustLimit {
+		tx.AddTxOut(wire.NewTxOut(changeValue, changeScript))
+	} else {
+		fee += changeValue
+	}
+
+	return tx, values, fee, nil
+}
+
+// EstimatePayoutVSize estimates the virtual size of a signed payout spending
+// a single deposit of depositType to the recipients, with change returned to
+// an address of the same type
+func EstimatePayoutVSize(depositType AddressType, recipients []btcutil.Address) (int64, error) {
+	vsize := int64(txOverheadVBytes) + inputVBytes(depositType) + 8 + 1 + scriptSize(depositType)
+	for _, recipient := range recipients {
+		script, err := txscript.PayToAddrScript(recipient)
+		if err != nil {
+			return 0, fmt.Errorf("failed to build script for %s: %w", recipient, err)
+		}
+		vsize += outputVBytes(script)
+	}
+	return vsize, nil
+}
@@ -0,0 +1,109 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// NewSweepPSBT wraps an unsigned sweep in a BIP174 PSBT for offline signing.
+// SegWit and Taproot inputs carry the output they spend and, for HD deposit
+// addresses, the key origin; legacy inputs would need the full previous
+// transaction and are left without UTXO data.
+func NewSweepPSBT(tx *wire.MsgTx, inputs []SweepInput) (*psbt.Packet, error) {
+	packet, err := psbt.NewFromUnsignedTx(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PSBT: %w", err)
+	}
+
+	for i, input := range inputs {
+		pInput := &packet.Inputs[i]
+		if input.AddressType != AddressTypeP2PKH {
+			pInput.WitnessUtxo = wire.NewTxOut(input.Value, input.PkScript)
+		}
+
+		origin := input.Origin
+		if origin == nil {
+			continue
+		}
+		compressed := origin.PubKey.SerializeCompressed()
+
+		switch input.AddressType {
+		case AddressTypeP2TR:
+			xOnly := schnorr.SerializePubKey(origin.PubKey)
+			pInput.TaprootInternalKey = xOnly
+			pInput.TaprootBip32Derivation = []*psbt.TaprootBip32Derivation{{
+				XOnlyPubKey:          xOnly,
+				MasterKeyFingerprint: origin.MasterFingerprint,
+				Bip32Path:            origin.Path,
+			}}
+		case AddressTypeP2SHP2WPKH:
+			redeemScript, err := txscript.NewScriptBuilder().
+				AddOp(txscript.OP_0).
+				AddData(btcutil.Hash160(compressed)).
+				Script()
+			if err != nil {
+				return nil, fmt.Errorf("failed to build redeem script: %w", err)
+			}
+			pInput.RedeemScript = redeemScript
+			fallthrough
+		default:
+			pInput.Bip32Derivation = []*psbt.Bip32Derivation{{
+				PubKey:               compressed,
+				MasterKeyFingerprint: origin.MasterFingerprint,
+				Bip32Path:            origin.Path,
+			}}
+		}
+	}
+
+	return packet, nil
+}
+
+// DecodePSBT parses a PSBT given either in binary or base64 encoding
+func DecodePSBT(data []byte) (*psbt.Packet, error) {
+	var packet *psbt.Packet
+	var err error
+	if bytes.HasPrefix(data, []byte("psbt\xff")) {
+		packet, err = psbt.NewFromRawBytes(bytes.NewReader(data), false)
+	} else {
+		packet, err = psbt.NewFromRawBytes(strings.NewReader(strings.TrimSpace(string(data))), true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PSBT: %w", err)
+	}
+	return packet, nil
+}
+
+// FinalizePSBT finalizes a fully signed PSBT and extracts the network
+// transaction. When every input carries the output it spends, the signatures
+// are verified as well.
+func FinalizePSBT(packet *psbt.Packet) (*wire.MsgTx, error) {
+	if err := psbt.MaybeFinalizeAll(packet); err != nil {
+		return nil, fmt.Errorf("failed to finalize PSBT: %w", err)
+	}
+
+	tx, err := psbt.Extract(packet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract transaction: %w", err)
+	}
+
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, pInput := range packet.Inputs {
+		if pInput.WitnessUtxo == nil {
+			// Legacy inputs are left for the node to verify
+			return tx, nil
+		}
+		fetcher.AddPrevOut(tx.TxIn[i].PreviousOutPoint, pInput.WitnessUtxo)
+	}
+	if err := verifyInputs(tx, fetcher); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+)
+
+// validateSolanaAddress validates a Solana address, the base58 encoding of a
+// 32-byte ed25519 public key. Program derived addresses are deliberately off
+// the curve and have no private key, so payouts to them are refused.
+func (av *AddressValidator) validateSolanaAddress(address string) *AddressError {
+	if len(address) < 32 || len(address) > 44 {
+		return addressError(AddressReasonInvalidFormat, "Solana addresses are 32 to 44 base58 characters")
+	}
+
+	// base58.Decode returns nothing for characters outside the alphabet
+	key := base58.Decode(address)
+	if len(key) == 0 {
+		return addressError(AddressReasonInvalidFormat, "Solana address contains non-base58 characters")
+	}
+	if len(key) != 32 {
+		return addressError(AddressReasonInvalidEncoding, "Solana address decodes to %d bytes, expected 32", len(key))
+	}
+
+	if !isOnEd25519Curve(key) {
+		return addressError(AddressReasonOffCurve, "Solana address is not on the ed25519 curve, it cannot sign for received funds")
+	}
+	return nil
+}
+
+var (
+	// ed25519P is the field prime 2^255 - 19
+	ed25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	// ed25519D is the curve constant -121665/121666
+	ed25519D = func() *big.Int {
+		d := new(big.Int).ModInverse(big.NewInt(121666), ed25519P)
+		d.Mul(d, big.NewInt(-121665))
+		return d.Mod(d, ed25519P)
+	}()
+)
+
+// isOnEd25519Curve reports whether a compressed Edwards point decompresses,
+// as Solana's own check does: y is read from the low 255 bits, reduced
+// modulo p, and has to have an x with x² = (y² - 1) / (d·y² + 1).
+func isOnEd25519Curve(point []byte) bool {
+	// The encoding is little-endian with the sign of x in the top bit
+	le := make([]byte, len(point))
+	for i, b := range point {
+		le[len(point)-1-i] = b
+	}
+	le[0] &= 0x7f
+
+	y := new(big.Int).SetBytes(le)
+	y.Mod(y, ed25519P)
+	y2 := new(big.Int).Mul(y, y)
+
+	u := new(big.Int).Sub(y2, big.NewInt(1))
+	v := new(big.Int).Mul(ed25519D, y2)
+	v.Add(v, big.NewInt(1)).Mod(v, ed25519P)
+
+	x2 := new(big.Int).ModInverse(v, ed25519P)
+	if x2 == nil {
+		return false
+	}
+	x2.Mul(x2, u).Mod(x2, ed25519P)
+	return new(big.Int).ModSqrt(x2, ed25519P) != nil
+}
@@ -0,0 +1,238 @@
+package crypto
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// DustLimit is the smallest output value a sweep is allowed to create
+const DustLimit = 546
+
+// txOverheadVBytes is the virtual size of a transaction's version, locktime,
+// input and output counts and segwit marker
+const txOverheadVBytes = 11
+
+// SweepInput is a deposit output spent by a sweep transaction. Origin, when
+// known, lets an offline signer find the key of an HD deposit address.
+type SweepInput struct {
+	OutPoint    wire.OutPoint
+	Value       int64
+	Address     string
+	AddressType AddressType
+	PkScript    []byte
+	Origin      *KeyOrigin
+}
+
+// NewSweepInput creates a sweep input for a UTXO paying to a deposit address
+// of the given type
+func NewSweepInput(utxo UTXO, address string, addressType AddressType, netParams *chaincfg.Params) (SweepInput, error) {
+	hash, err := chainhash.NewHashFromStr(utxo.TXID)
+	if err != nil {
+		return SweepInput{}, fmt.Errorf("invalid txid %s: %w", utxo.TXID, err)
+	}
+
+	decoded, err := btcutil.DecodeAddress(address, netParams)
+	if err != nil {
+		return SweepInput{}, fmt.Errorf("invalid deposit address %s: %w", address, err)
+	}
+	pkScript, err := txscript.PayToAddrScript(decoded)
+	if err != nil {
+		return SweepInput{}, fmt.Errorf("failed to build output script for %s: %w", address, err)
+	}
+
+	return SweepInput{
+		OutPoint:    *wire.NewOutPoint(hash, uint32(utxo.Vout)),
+		Value:       utxo.Value,
+		Address:     address,
+		AddressType: addressType,
+		PkScript:    pkScript,
+	}, nil
+}
+
+// inputVBytes estimates the virtual size of a signed input spending an
+// output of the given type
+func inputVBytes(addressType AddressType) int64 {
+	switch addressType {
+	case AddressTypeP2WPKH:
+		return 68
+	case AddressTypeP2SHP2WPKH:
+		return 91
+	case AddressTypeP2TR:
+		return 58
+	default:
+		return 148
+	}
+}
+
+// scriptSize is the length of an output script of the given type
+func scriptSize(addressType AddressType) int64 {
+	switch addressType {
+	case AddressTypeP2PKH:
+		return 25
+	case AddressTypeP2SHP2WPKH, AddressTypeP2SH:
+		return 23
+	case AddressTypeP2WPKH:
+		return 22
+	default:
+		return 34
+	}
+}
+
+// outputVBytes is the size of an output with the given script: value,
+// script length and script
+func outputVBytes(script []byte) int64 {
+	return 8 + 1 + int64(len(script))
+}
+
+// estimateVSize estimates the virtual size of a signed transaction spending
+// inputs to outputs with the given scripts
+func estimateVSize(inputs []SweepInput, outputScripts [][]byte) int64 {
+	vsize := int64(txOverheadVBytes)
+	for _, input := range inputs {
+		vsize += inputVBytes(input.AddressType)
+	}
+	for _, script := range outputScripts {
+		vsize += outputVBytes(script)
+	}
+	return vsize
+}
+
+// EstimateSweepVSize estimates the virtual size of a signed sweep spending
+// inputs to a single output with the given script
+func EstimateSweepVSize(inputs []SweepInput, destinationScript []byte) int64 {
+	return estimateVSize(inputs, [][]byte{destinationScript})
+}
+
+// EstimateSweepInputVSize estimates how much sweeping one deposit of the
+// given type adds to the virtual size of a sweep
+func EstimateSweepInputVSize(addressType AddressType) int64 {
+	return inputVBytes(addressType)
+}
+
+// BuildSweepTx builds an unsigned transaction spending every input to a
+// single destination output, paying feeRate sat/vB. It returns the
+// transaction and its fee.
+func BuildSweepTx(inputs []SweepInput, destination btcutil.Address, feeRate int64) (*wire.MsgTx, int64, error) {
+	if len(inputs) == 0 {
+		return nil, 0, fmt.Errorf("sweep has no inputs")
+	}
+
+	destinationScript, err := txscript.PayToAddrScript(destination)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build destination script: %w", err)
+	}
+
+	var total int64
+	tx := wire.NewMsgTx(2)
+	for _, input := range inputs {
+		txIn := wire.NewTxIn(&input.OutPoint, nil, nil)
+		// Signal replaceability so a stuck sweep can be fee bumped
+		txIn.Sequence = wire.MaxTxInSequenceNum - 2
+		tx.AddTxIn(txIn)
+		total += input.Value
+	}
+
+	fee := EstimateSweepVSize(inputs, destinationScript) * feeRate
+	if total-fee < DustLimit {
+		return nil, 0, fmt.Errorf("sweep of %d sats does not cover the %d sats fee", total, fee)
+	}
+	tx.AddTxOut(wire.NewTxOut(total-fee, destinationScript))
+
+	return tx, fee, nil
+}
+
+// sweepPrevOutFetcher returns the outputs spent by a sweep, as needed for
+// SegWit and Taproot signature hashes
+func sweepPrevOutFetcher(inputs []SweepInput) *txscript.MultiPrevOutFetcher {
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for _, input := range inputs {
+		fetcher.AddPrevOut(input.OutPoint, wire.NewTxOut(input.Value, input.PkScript))
+	}
+	return fetcher
+}
+
+// SignSweepTx signs every input of a transaction spending sweep inputs, as
+// built by BuildSweepTx or BuildPayoutTx. keys[i] is the private key of
+// inputs[i]. Each input is verified after signing.
+func SignSweepTx(tx *wire.MsgTx, inputs []SweepInput, keys []*btcec.PrivateKey) error {
+	if len(inputs) != len(tx.TxIn) || len(keys) != len(inputs) {
+		return fmt.Errorf("sweep has %d inputs, got %d input descriptions and %d keys", len(tx.TxIn), len(inputs), len(keys))
+	}
+
+	fetcher := sweepPrevOutFetcher(inputs)
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+
+	for i, input := range inputs {
+		key := keys[i]
+		txIn := tx.TxIn[i]
+
+		switch input.AddressType {
+		case AddressTypeP2WPKH:
+			witness, err := txscript.WitnessSignature(tx, sigHashes, i, input.Value, input.PkScript, txscript.SigHashAll, key, true)
+			if err != nil {
+				return fmt.Errorf("failed to sign input %d: %w", i, err)
+			}
+			txIn.Witness = witness
+		case AddressTypeP2SHP2WPKH:
+			witnessProgram, err := txscript.NewScriptBuilder().
+				AddOp(txscript.OP_0).
+				AddData(btcutil.Hash160(key.PubKey().SerializeCompressed())).
+				Script()
+			if err != nil {
+				return fmt.Errorf("failed to build witness program: %w", err)
+			}
+			witness, err := txscript.WitnessSignature(tx, sigHashes, i, input.Value, witnessProgram, txscript.SigHashAll, key, true)
+			if err != nil {
+				return fmt.Errorf("failed to sign input %d: %w", i, err)
+			}
+			sigScript, err := txscript.NewScriptBuilder().AddData(witnessProgram).Script()
+			if err != nil {
+				return fmt.Errorf("failed to build signature script: %w", err)
+			}
+			txIn.Witness = witness
+			txIn.SignatureScript = sigScript
+		case AddressTypeP2TR:
+			// BIP86 key path spend, the output key commits to no script tree
+			witness, err := txscript.TaprootWitnessSignature(tx, sigHashes, i, input.Value, input.PkScript, txscript.SigHashDefault, key)
+			if err != nil {
+				return fmt.Errorf("failed to sign input %d: %w", i, err)
+			}
+			txIn.Witness = witness
+		case AddressTypeP2PKH:
+			sigScript, err := txscript.SignatureScript(tx, i, input.PkScript, txscript.SigHashAll, key, true)
+			if err != nil {
+				return fmt.Errorf("failed to sign input %d: %w", i, err)
+			}
+			txIn.SignatureScript = sigScript
+		default:
+			return fmt.Errorf("cannot sign input %d of address type %q", i, input.AddressType)
+		}
+	}
+
+	return verifyInputs(tx, fetcher)
+}
+
+// verifyInputs executes the scripts of every input against the outputs they spend
+func verifyInputs(tx *wire.MsgTx, fetcher *txscript.MultiPrevOutFetcher) error {
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	for i, txIn := range tx.TxIn {
+		prevOut := fetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+		if prevOut == nil {
+			return fmt.Errorf("missing previous output of input %d", i)
+		}
+		engine, err := txscript.NewEngine(prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, prevOut.Value, fetcher)
+		if err != nil {
+			return fmt.Errorf("failed to create script engine for input %d: %w", i, err)
+		}
+		if err := engine.Execute(); err != nil {
+			return fmt.Errorf("input %d does not verify: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package crypto
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+)
+
+// tronAddressVersion is the version byte of Tron addresses, which makes
+// their base58 form start with T. Tron's test networks use the same one.
+const tronAddressVersion = 0x41
+
+// validateTronAddress validates a Tron address, the base58check encoding of
+// the version byte 0x41 followed by a 20-byte account hash
+func (av *AddressValidator) validateTronAddress(address string) *AddressError {
+	if len(address) != 34 || address[0] != 'T' {
+		return addressError(AddressReasonInvalidFormat, "Tron addresses are 34 base58 characters starting with T")
+	}
+
+	hash, version, err := base58.CheckDecode(address)
+	if errors.Is(err, base58.ErrChecksum) {
+		return addressError(AddressReasonInvalidChecksum, "invalid Tron address checksum")
+	}
+	if err != nil {
+		return addressError(AddressReasonInvalidFormat, "Tron address contains non-base58 characters")
+	}
+	if version != tronAddressVersion || len(hash) != 20 {
+		return addressError(AddressReasonInvalidEncoding, "Tron address does not decode to a 0x41-prefixed 20-byte account")
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/gozmq"
+	"github.com/sirupsen/logrus"
+)
+
+// bitcoind ZMQ topics
+const (
+	zmqTopicRawTx     = "rawtx"
+	zmqTopicHashBlock = "hashblock"
+)
+
+// zmqReadTimeout bounds reading the remaining frames of a message
+const zmqReadTimeout = 5 * time.Second
+
+// ZMQNotifier is a ChainNotifier subscribing to bitcoind's rawtx and
+// hashblock ZMQ notifications (-zmqpubrawtx and -zmqpubhashblock)
+type ZMQNotifier struct {
+	rawTxAddr     string
+	hashBlockAddr string
+}
+
+// NewZMQNotifier creates a notifier for the given publisher endpoints, e.g.
+// "tcp://127.0.0.1:28332". Both topics may share a single endpoint.
+func NewZMQNotifier(rawTxAddr, hashBlockAddr string) *ZMQNotifier {
+	return &ZMQNotifier{
+		rawTxAddr:     rawTxAddr,
+		hashBlockAddr: hashBlockAddr,
+	}
+}
+
+// Run subscribes to the configured endpoints and delivers events until ctx
+// is cancelled or a subscription fails
+func (zn *ZMQNotifier) Run(ctx context.Context, events chan<- ChainEvent) error {
+	subscriptions := make(map[string][]string)
+	if zn.rawTxAddr != "" {
+		subscriptions[zn.rawTxAddr] = append(subscriptions[zn.rawTxAddr], zmqTopicRawTx)
+	}
+	if zn.hashBlockAddr != "" {
+		subscriptions[zn.hashBlockAddr] = append(subscriptions[zn.hashBlockAddr], zmqTopicHashBlock)
+	}
+	if len(subscriptions) == 0 {
+		return fmt.Errorf("no ZMQ endpoints configured")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errCh := make(chan error, len(subscriptions))
+	for addr, topics := range subscriptions {
+		conn, err := gozmq.Subscribe(addr, topics, zmqReadTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", addr, err)
+		}
+		logrus.Infof("Subscribed to ZMQ %v notifications at %s", topics, addr)
+
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+		go func(addr string) {
+			errCh <- zn.receive(ctx, addr, conn, events)
+		}(addr)
+	}
+
+	// The first subscription to stop takes the others down with it
+	err := <-errCh
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// receive reads messages from one subscription and turns them into events
+// until ctx is cancelled, returning an error if the subscription ends first
+func (zn *ZMQNotifier) receive(ctx context.Context, addr string, conn *gozmq.Conn, events chan<- ChainEvent) error {
+	lastSequence := make(map[string]uint32)
+
+	for {
+		msg, err := conn.Receive(nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("ZMQ subscription to %s closed", addr)
+			}
+			// Timeouts are also reported while gozmq reconnects
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return fmt.Errorf("failed to receive from %s: %w", addr, err)
+		}
+
+		// Messages are [topic, body, sequence]
+		if len(msg) < 2 {
+			continue
+		}
+		topic := string(msg[0])
+
+		if len(msg) >= 3 && len(msg[2]) == 4 {
+			sequence := binary.LittleEndian.Uint32(msg[2])
+			if last, seen := lastSequence[topic]; seen && sequence != last+1 {
+				logrus.Warnf("Missed %d ZMQ %s notifications from %s", sequence-last-1, topic, addr)
+			}
+			lastSequence[topic] = sequence
+		}
+
+		var event ChainEvent
+		switch topic {
+		case zmqTopicRawTx:
+			tx := wire.NewMsgTx(wire.TxVersion)
+			if err := tx.Deserialize(bytes.NewReader(msg[1])); err != nil {
+				logrus.Warnf("Failed to decode ZMQ rawtx notification: %v", err)
+				continue
+			}
+			event.Tx = tx
+		case zmqTopicHashBlock:
+			// bitcoind publishes the hash in its usual display byte order
+			event.BlockHash = hex.EncodeToString(msg[1])
+		default:
+			continue
+		}
+
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package money represents amounts exactly, as integer base units of their
+// currency (satoshis, wei, lamports, lovelace), and converts them from and to
+// the decimal strings used by the API.
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// MaxDecimals is the largest number of decimal places a currency may have
+const MaxDecimals = 36
+
+// Amount is an exact amount in integer base units. The zero value is zero.
+// Amounts are immutable, arithmetic returns a new Amount.
+type Amount struct {
+	units *big.Int
+}
+
+// NewAmount creates an amount of base units
+func NewAmount(units int64) Amount {
+	return Amount{units: big.NewInt(units)}
+}
+
+// NewAmountFromBig creates an amount from a copy of base units
+func NewAmountFromBig(units *big.Int) Amount {
+	return Amount{units: new(big.Int).Set(units)}
+}
+
+// ParseUnits parses an integer number of base units
+func ParseUnits(s string) (Amount, error) {
+	units, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("invalid amount: %q", s)
+	}
+	return Amount{units: units}, nil
+}
+
+// ParseDecimal parses a decimal string such as "0.29" into base units of a
+// currency with the given decimals. Amounts more precise than a base unit
+// are rejected rather than rounded.
+func ParseDecimal(s string, decimals int) (Amount, error) {
+	if decimals < 0 || decimals > MaxDecimals {
+		return Amount{}, fmt.Errorf("invalid decimals: %d", decimals)
+	}
+
+	str := strings.TrimSpace(s)
+	negative := strings.HasPrefix(str, "-")
+	if negative {
+		str = str[1:]
+	} else {
+		str = strings.TrimPrefix(str, "+")
+	}
+
+	whole, fraction, _ := strings.Cut(str, ".")
+	if whole == "" && fraction == "" || !isDigits(whole) || !isDigits(fraction) {
+		return Amount{}, fmt.Errorf("invalid amount: %q", s)
+	}
+	trimmed := strings.TrimRight(fraction, "0")
+	if len(trimmed) > decimals {
+		return Amount{}, fmt.Errorf("amount %q has more than %d decimal places", s, decimals)
+	}
+
+	digits := whole + trimmed + strings.Repeat("0", decimals-len(trimmed))
+	units, ok := new(big.Int).SetString("0"+digits, 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("invalid amount: %q", s)
+	}
+	if negative {
+		units.Neg(units)
+	}
+	return Amount{units: units}, nil
+}
+
+// isDigits reports whether s consists of ASCII digits only
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// big returns the base units, never nil
+func (a Amount) big() *big.Int {
+	if a.units == nil {
+		return new(big.Int)
+	}
+	return a.units
+}
+
+// Units returns a copy of the base units
+func (a Amount) Units() *big.Int {
+	return new(big.Int).Set(a.big())
+}
+
+// Int64 returns the base units if they fit in an int64
+func (a Amount) Int64() (int64, bool) {
+	units := a.big()
+	return units.Int64(), units.IsInt64()
+}
+
+// String returns the base units in decimal
+func (a Amount) String() string {
+	return a.big().String()
+}
+
+// Decimal formats the amount as a decimal string in the unit of a currency
+// with the given decimals, without trailing zeros, e.g. 29000000 with 8
+// decimals is "0.29"
+func (a Amount) Decimal(decimals int) string {
+	units := a.big()
+	digits := new(big.Int).Abs(units).String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+
+	whole := digits[:len(digits)-decimals]
+	fraction := strings.TrimRight(digits[len(digits)-decimals:], "0")
+
+	s := whole
+	if fraction != "" {
+		s += "." + fraction
+	}
+	if units.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// Sign returns -1, 0 or 1 depending on the sign of the amount
+func (a Amount) Sign() int {
+	return a.big().Sign()
+}
+
+// IsZero reports whether the amount is zero
+func (a Amount) IsZero() bool {
+	return a.Sign() == 0
+}
+
+// Cmp compares two amounts, returning -1, 0 or 1
+func (a Amount) Cmp(b Amount) int {
+	return a.big().Cmp(b.big())
+}
+
+// Add returns a + b
+func (a Amount) Add(b Amount) Amount {
+	return Amount{units: new(big.Int).Add(a.big(), b.big())}
+}
+
+// Sub returns a - b
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{units: new(big.Int).Sub(a.big(), b.big())}
+}
+
+// MulDiv returns a * num / den, rounded down to a whole base unit
+func (a Amount) MulDiv(num, den int64) Amount {
+	units := new(big.Int).Mul(a.big(), big.NewInt(num))
+	return Amount{units: units.Div(units, big.NewInt(den))}
+}
+
+// Convert converts an amount between currencies with the given decimals at
+// their prices in a common quote currency, rounding down to a whole base unit
+func Convert(amount Amount, fromDecimals, toDecimals int, fromPrice, toPrice float64) (Amount, error) {
+	if fromPrice <= 0 || toPrice <= 0 {
+		return Amount{}, fmt.Errorf("invalid prices %v and %v", fromPrice, toPrice)
+	}
+
+	value := new(big.Rat).SetInt(amount.big())
+	value.Mul(value, new(big.Rat).SetFloat64(fromPrice))
+	value.Quo(value, new(big.Rat).SetFloat64(toPrice))
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(toDecimals-fromDecimals))), nil)
+	if toDecimals > fromDecimals {
+		value.Mul(value, new(big.Rat).SetInt(scale))
+	} else {
+		value.Quo(value, new(big.Rat).SetInt(scale))
+	}
+
+	units := new(big.Int).Div(value.Num(), value.Denom())
+	return Amount{units: units}, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// MarshalJSON encodes the base units as a JSON string, as they may not fit
+// in the integers JSON decoders support
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + a.String() + `"`), nil
+}
+
+// UnmarshalJSON decodes base units from a JSON string or integer
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.Trim(data, `"`)
+	if string(data) == "null" || len(data) == 0 {
+		*a = Amount{}
+		return nil
+	}
+
+	amount, err := ParseUnits(string(data))
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// Scan implements sql.Scanner interface for numeric columns
+func (a *Amount) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*a = Amount{}
+		return nil
+	case int64:
+		*a = NewAmount(v)
+		return nil
+	case []byte:
+		return a.scanString(string(v))
+	case string:
+		return a.scanString(v)
+	default:
+		return fmt.Errorf("cannot scan %T into an amount", value)
+	}
+}
+
+// scanString parses a numeric column, which may carry a zero fraction
+func (a *Amount) scanString(s string) error {
+	whole, fraction, _ := strings.Cut(s, ".")
+	if strings.Trim(fraction, "0") != "" {
+		return fmt.Errorf("amount %q is not a whole number of base units", s)
+	}
+
+	amount, err := ParseUnits(whole)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// Value implements driver.Valuer interface
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
@@ -0,0 +1,264 @@
+package money
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// AddressFormat is the address encoding of the chain a currency is paid out
+// on, which selects how output addresses are validated
+type AddressFormat string
+
+// Address formats
+const (
+	AddressFormatBitcoin AddressFormat = "bitcoin"
+	AddressFormatEVM     AddressFormat = "evm"
+	AddressFormatCardano AddressFormat = "cardano"
+	AddressFormatSolana  AddressFormat = "solana"
+	AddressFormatTron    AddressFormat = "tron"
+)
+
+// Networks currencies are paid out on
+const (
+	NetworkBitcoin  = "bitcoin"
+	NetworkEthereum = "ethereum"
+	NetworkCardano  = "cardano"
+	NetworkSolana   = "solana"
+	NetworkPolygon  = "polygon"
+	NetworkTron     = "tron"
+)
+
+// Currency describes an output currency: an asset on the network it is paid
+// out on. Tokens issued on several networks, such as USDT, are one currency
+// per network, USDT-ERC20 and USDT-TRC20, which share their asset's price
+// but not their addresses.
+type Currency struct {
+	// Code identifies the currency in the API, e.g. "USDT-TRC20"
+	Code string
+	// Asset is the priced symbol, e.g. "USDT"
+	Asset string
+	Name  string
+	// Network is the chain the currency is paid out on
+	Network string
+	// Standard is the token standard of tokens, e.g. "TRC20", and empty for
+	// the native currency of a network
+	Standard string
+	// Decimals is the number of decimal places of the base unit, i.e. an
+	// amount of 1 is 10^Decimals base units
+	Decimals      int
+	AddressFormat AddressFormat
+	// MinAmount and MaxAmount bound the output of an exchange, in base units.
+	// A zero MaxAmount leaves the output unbounded.
+	MinAmount Amount
+	MaxAmount Amount
+	// FeeBps is the service fee, in basis points of the BTC amount
+	FeeBps int64
+}
+
+// CheckAmount checks an exchange output, in base units, against the
+// currency's limits
+func (c Currency) CheckAmount(amount Amount) error {
+	if amount.Cmp(c.MinAmount) < 0 {
+		return fmt.Errorf("output of %s %s is below the minimum of %s %s",
+			amount.Decimal(c.Decimals), c.Code, c.MinAmount.Decimal(c.Decimals), c.Code)
+	}
+	if !c.MaxAmount.IsZero() && amount.Cmp(c.MaxAmount) > 0 {
+		return fmt.Errorf("output of %s %s is above the maximum of %s %s",
+			amount.Decimal(c.Decimals), c.Code, c.MaxAmount.Decimal(c.Decimals), c.Code)
+	}
+	return nil
+}
+
+// token describes a token currency, whose code is its asset and network
+func token(code, name, network, standard string, decimals int, format AddressFormat, min, max string) Currency {
+	asset, _, _ := strings.Cut(code, "-")
+	return Currency{
+		Code:          code,
+		Asset:         asset,
+		Name:          name,
+		Network:       network,
+		Standard:      standard,
+		Decimals:      decimals,
+		AddressFormat: format,
+		MinAmount:     mustParseDecimal(min, decimals),
+		MaxAmount:     mustParseDecimal(max, decimals),
+		FeeBps:        50,
+	}
+}
+
+// native describes the native currency of a network
+func native(code, name, network string, decimals int, format AddressFormat, min, max string, feeBps int64) Currency {
+	return Currency{
+		Code:          code,
+		Asset:         code,
+		Name:          name,
+		Network:       network,
+		Decimals:      decimals,
+		AddressFormat: format,
+		MinAmount:     mustParseDecimal(min, decimals),
+		MaxAmount:     mustParseDecimal(max, decimals),
+		FeeBps:        feeBps,
+	}
+}
+
+// mustParseDecimal parses a decimal literal of the registry
+func mustParseDecimal(s string, decimals int) Amount {
+	amount, err := ParseDecimal(s, decimals)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+var (
+	currenciesMu sync.RWMutex
+	// currencies is the registry of currencies by code
+	currencies = indexCurrencies([]Currency{
+		native("BTC", "Bitcoin", NetworkBitcoin, 8, AddressFormatBitcoin, "0.001", "10", 20),   // satoshi
+		native("ETH", "Ethereum", NetworkEthereum, 18, AddressFormatEVM, "0.01", "100", 50),    // wei
+		native("ADA", "Cardano", NetworkCardano, 6, AddressFormatCardano, "100", "500000", 50), // lovelace
+		native("SOL", "Solana", NetworkSolana, 9, AddressFormatSolana, "1", "10000", 50),       // lamport
+		native("MATIC", "Polygon", NetworkPolygon, 18, AddressFormatEVM, "100", "1000000", 50), // wei
+		token("USDT-ERC20", "Tether (Ethereum)", NetworkEthereum, "ERC20", 6, AddressFormatEVM, "10", "50000"),
+		token("USDT-TRC20", "Tether (Tron)", NetworkTron, "TRC20", 6, AddressFormatTron, "10", "50000"),
+		token("USDT-SOL", "Tether (Solana)", NetworkSolana, "SPL", 6, AddressFormatSolana, "10", "50000"),
+		token("USDT-POLYGON", "Tether (Polygon)", NetworkPolygon, "ERC20", 6, AddressFormatEVM, "10", "50000"),
+		token("USDC-ERC20", "USD Coin (Ethereum)", NetworkEthereum, "ERC20", 6, AddressFormatEVM, "10", "50000"),
+		token("USDC-SOL", "USD Coin (Solana)", NetworkSolana, "SPL", 6, AddressFormatSolana, "10", "50000"),
+		token("USDC-POLYGON", "USD Coin (Polygon)", NetworkPolygon, "ERC20", 6, AddressFormatEVM, "10", "50000"),
+	})
+	// currencyAliases maps the codes of tokens from before they were told
+	// apart by network to the currency they were paid out as
+	currencyAliases = map[string]string{
+		"USDT": "USDT-ERC20",
+		"USDC": "USDC-ERC20",
+	}
+)
+
+// indexCurrencies indexes currencies by code
+func indexCurrencies(list []Currency) map[string]Currency {
+	index := make(map[string]Currency, len(list))
+	for _, currency := range list {
+		index[currency.Code] = currency
+	}
+	return index
+}
+
+// RegisterCurrency adds a currency to the registry, replacing the decimals
+// of a known one. A new currency is its own asset, without an address
+// format or limits.
+func RegisterCurrency(currency string, decimals int) error {
+	if decimals < 0 || decimals > MaxDecimals {
+		return fmt.Errorf("invalid decimals for %s: %d", currency, decimals)
+	}
+
+	currenciesMu.Lock()
+	defer currenciesMu.Unlock()
+	code := strings.ToUpper(currency)
+	if alias, ok := currencyAliases[code]; ok {
+		code = alias
+	}
+	entry, ok := currencies[code]
+	if !ok {
+		entry = Currency{Code: code, Asset: code, Name: code}
+	}
+	entry.Decimals = decimals
+	currencies[code] = entry
+	return nil
+}
+
+// LookupCurrency returns a currency by code. Aliases resolve to their
+// currency, whose Code is the canonical one.
+func LookupCurrency(code string) (Currency, error) {
+	currenciesMu.RLock()
+	defer currenciesMu.RUnlock()
+
+	code = strings.ToUpper(code)
+	if alias, ok := currencyAliases[code]; ok {
+		code = alias
+	}
+	currency, ok := currencies[code]
+	if !ok {
+		return Currency{}, fmt.Errorf("unknown currency: %s", code)
+	}
+	return currency, nil
+}
+
+// Decimals returns the number of decimal places of a currency's base unit
+func Decimals(currency string) (int, error) {
+	entry, err := LookupCurrency(currency)
+	if err != nil {
+		return 0, err
+	}
+	return entry.Decimals, nil
+}
+
+// Currencies returns the codes of the registered currencies and their
+// aliases, sorted
+func Currencies() []string {
+	currenciesMu.RLock()
+	defer currenciesMu.RUnlock()
+
+	codes := make([]string, 0, len(currencies)+len(currencyAliases))
+	for code := range currencies {
+		codes = append(codes, code)
+	}
+	for alias := range currencyAliases {
+		codes = append(codes, alias)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// ListCurrencies returns the registered currencies, sorted by code
+func ListCurrencies() []Currency {
+	currenciesMu.RLock()
+	defer currenciesMu.RUnlock()
+
+	list := make([]Currency, 0, len(currencies))
+	for _, currency := range currencies {
+		list = append(list, currency)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Code < list[j].Code
+	})
+	return list
+}
+
+// Assets returns the distinct assets of the registered currencies, the
+// symbols prices are needed for, sorted
+func Assets() []string {
+	currenciesMu.RLock()
+	defer currenciesMu.RUnlock()
+
+	seen := make(map[string]bool)
+	var assets []string
+	for _, currency := range currencies {
+		if !seen[currency.Asset] {
+			seen[currency.Asset] = true
+			assets = append(assets, currency.Asset)
+		}
+	}
+	sort.Strings(assets)
+	return assets
+}
+
+// ParseCurrency parses a decimal amount of a currency into base units
+func ParseCurrency(s, currency string) (Amount, error) {
+	decimals, err := Decimals(currency)
+	if err != nil {
+		return Amount{}, err
+	}
+	return ParseDecimal(s, decimals)
+}
+
+// FormatCurrency formats base units of a currency as a decimal string
+func FormatCurrency(amount Amount, currency string) (string, error) {
+	decimals, err := Decimals(currency)
+	if err != nil {
+		return "", err
+	}
+	return amount.Decimal(decimals), nil
+}
@@ -26,6 +26,7 @@ import {
   formatCurrency, 
   formatPrice, 
   truncateAddress, 
+  getCurrencyAsset,
   getCurrencyIcon,
   getCurrencyName,
   validateCryptoAddress,
@@ -35,6 +36,7 @@ import {
 interface OutputAddress {
   address: string;
   percentage: number;
+  memo?: string;
 }
 
 interface ExchangeFormData {
@@ -46,11 +48,11 @@ interface ExchangeFormData {
 interface Transaction {
   id: string;
   payment_address: string;
-  btc_amount: number;
+  btc_amount: string;
   output_currency: string;
   output_addresses: OutputAddress[];
-  estimated_output: number;
-  fee: number;
+  estimated_output: string;
+  fee: string;
   status: string;
   created_at: string;
 }
@@ -77,6 +79,7 @@ const ExchangeForm: React.FC = () => {
   // API hooks
   const { data: prices, isLoading: pricesLoading } = usePrices();
   const { data: supportedCurrencies } = useSupportedCurrencies();
+  const memoPolicy = supportedCurrencies?.find(c => c.symbol === watchedCurrency)?.memo ?? 'forbidden';
   const createExchange = useCreateExchange();
 
   const addAddress = () => {
@@ -127,9 +130,12 @@ const ExchangeForm: React.FC = () => {
   const onSubmit = (data: ExchangeFormData) => {
     if (currentStep === 3) {
       createExchange.mutate({
-        btc_amount: data.btcAmount,
+        btc_amount: data.btcAmount.toString(),
         output_currency: data.outputCurrency,
-        output_addresses: data.outputAddresses
+        // Memos only go to chains that carry them
+        output_addresses: data.outputAddresses.map(({ memo, ...output }) =>
+          memo && memoPolicy !== 'forbidden' ? { ...output, memo } : output
+        )
       }, {
         onSuccess: async (response) => {
           setTransaction(response.data);
@@ -153,8 +159,13 @@ const ExchangeForm: React.FC = () => {
 
   const currencies = [
     { id: 'ETH', name: 'Ethereum', icon: '⟠' },
-    { id: 'USDT', name: 'Tether', icon: '₮' },
-    { id: 'USDC', name: 'USD Coin', icon: '$' },
+    { id: 'USDT-ERC20', name: 'Tether (Ethereum)', icon: '₮' },
+    { id: 'USDT-TRC20', name: 'Tether (Tron)', icon: '₮' },
+    { id: 'USDT-SOL', name: 'Tether (Solana)', icon: '₮' },
+    { id: 'USDT-POLYGON', name: 'Tether (Polygon)', icon: '₮' },
+    { id: 'USDC-ERC20', name: 'USD Coin (Ethereum)', icon: '$' },
+    { id: 'USDC-SOL', name: 'USD Coin (Solana)', icon: '$' },
+    { id: 'USDC-POLYGON', name: 'USD Coin (Polygon)', icon: '$' },
     { id: 'ADA', name: 'Cardano', icon: '₳' },
     { id: 'SOL', name: 'Solana', icon: '◎' },
     { id: 'MATIC', name: 'Polygon', icon: '⬟' }
@@ -258,7 +269,7 @@ const ExchangeForm: React.FC = () => {
                       <div className="text-sm text-gray-400">{currency.id}</div>
                       {prices && (
                         <div className="text-sm text-green-400">
-                          ${prices[currency.id]?.toLocaleString() || 'N/A'}
+                          ${prices[getCurrencyAsset(currency.id)]?.toLocaleString() || 'N/A'}
                         </div>
                       )}
                     </div>
@@ -306,6 +317,15 @@ const ExchangeForm: React.FC = () => {
                       onChange={(e) => updateAddress(index, 'address', e.target.value)}
                       className="w-full px-4 py-3 bg-gray-800/50 border border-gray-600 rounded-xl text-white placeholder-gray-400 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500"
                     />
+                    {memoPolicy !== 'forbidden' && (
+                      <input
+                        type="text"
+                        placeholder={memoPolicy === 'required' ? 'Memo (required)' : 'Memo (optional, e.g. for exchange deposits)'}
+                        value={addr.memo || ''}
+                        onChange={(e) => updateAddress(index, 'memo', e.target.value)}
+                        className="w-full mt-2 px-4 py-3 bg-gray-800/50 border border-gray-600 rounded-xl text-white placeholder-gray-400 focus:border-indigo-500 focus:ring-1 focus:ring-indigo-500"
+                      />
+                    )}
                   </div>
                   <div className="w-24">
                     <input
@@ -352,6 +372,7 @@ const ExchangeForm: React.FC = () => {
               disabled={
                 createExchange.isPending ||
                 addresses.some(addr => !addr.address) ||
+                (memoPolicy === 'required' && addresses.some(addr => !addr.memo)) ||
                 addresses.reduce((sum, addr) => sum + addr.percentage, 0) !== 100
               }
               className="w-full bg-gradient-to-r from-indigo-500 to-purple-500 text-white py-4 rounded-xl font-medium hover:from-indigo-600 hover:to-purple-600 transition-all disabled:opacity-50 disabled:cursor-not-allowed flex items-center justify-center gap-2"
@@ -45,6 +45,22 @@ const TransactionTracker: React.FC<TransactionTrackerProps> = ({ transactionId,
           borderColor: 'border-yellow-500/30',
           message: 'Waiting for payment confirmation'
         };
+      case 'underpaid':
+        return {
+          icon: AlertCircle,
+          color: 'text-orange-500',
+          bgColor: 'bg-orange-500/10',
+          borderColor: 'border-orange-500/30',
+          message: 'Partial payment received - send the remaining amount'
+        };
+      case 'overpaid':
+        return {
+          icon: AlertCircle,
+          color: 'text-orange-500',
+          bgColor: 'bg-orange-500/10',
+          borderColor: 'border-orange-500/30',
+          message: 'Overpayment received - on hold while the surplus is reviewed'
+        };
       case 'processing':
         return {
           icon: RefreshCw,
@@ -192,7 +208,7 @@ const TransactionTracker: React.FC<TransactionTrackerProps> = ({ transactionId,
             <div>
               <label className="text-sm text-gray-400">Fee</label>
               <p className="font-semibold text-white mt-1">
-                ${formatCurrency(transaction.fee, 2)}
+                {formatCurrency(transaction.fee, 8)} BTC
               </p>
             </div>
 
@@ -244,8 +260,13 @@ const TransactionTracker: React.FC<TransactionTrackerProps> = ({ transactionId,
                   {truncateAddress(addr.address, 12, 12)}
                 </div>
                 <div className="text-xs text-gray-400 mt-1">
-                  {addr.percentage}% • {formatCurrency((transaction.estimated_output * addr.percentage) / 100, 6)} {transaction.output_currency}
+                  {addr.percentage}% • {formatCurrency((Number(transaction.estimated_output) * addr.percentage) / 100, 6)} {transaction.output_currency}
                 </div>
+                {addr.memo && (
+                  <div className="text-xs text-gray-400 mt-1">
+                    Memo: <span className="font-mono">{addr.memo}</span>
+                  </div>
+                )}
               </div>
               <button
                 onClick={() => handleCopy(addr.address, `output-${index}`)}
@@ -1,5 +1,6 @@
 import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query';
 import { api, CreateExchangeRequest, Transaction, SupportedCurrency, PriceData, ValidateAddressRequest } from '@/lib/api';
+import { getCurrencyAsset } from '@/lib/utils';
 
 // Query Keys
 export const queryKeys = {
@@ -101,8 +102,9 @@ export const usePriceCalculation = (btcAmount: number, outputCurrency: string) =
     }
 
     const btcPrice = prices.BTC || 0;
-    const outputPrice = prices[outputCurrency.toUpperCase()] || 0;
     const currency = currencies.find(c => c.symbol.toUpperCase() === outputCurrency.toUpperCase());
+    // Tokens on every network share the price of their asset
+    const outputPrice = prices[currency?.asset ?? getCurrencyAsset(outputCurrency)] || 0;
     const fee = currency?.fee || 0.005;
 
     if (!btcPrice || !outputPrice) {
@@ -12,27 +12,60 @@ const apiClient = axios.create({
 export interface OutputAddress {
   address: string;
   percentage: number;
+  // Memo or destination tag, on chains that carry one
+  memo?: string;
 }
 
-export interface CreateExchangeRequest {
-  btc_amount: number;
+export interface CreateQuoteRequest {
+  // Decimal amounts are exact strings, e.g. "0.29"
+  btc_amount: string;
   output_currency: string;
   output_addresses: OutputAddress[];
 }
 
+// An exchange is created either from a quote, which locks the rate, or from
+// the exchange fields, paid out at the rate of the day
+export type CreateExchangeRequest = CreateQuoteRequest | { quote_id: string };
+
+export interface Quote {
+  quote_id: string;
+  btc_amount: string;
+  output_currency: string;
+  output_addresses: OutputAddress[];
+  rate: string;
+  estimated_output: string;
+  fee: string;
+  fee_breakdown: FeeBreakdown;
+  max_slippage_bps: number;
+  expires_at: string;
+}
+
 export interface Transaction {
   id: string;
   payment_address: string;
-  btc_amount: number;
+  btc_amount: string;
   output_currency: string;
   output_addresses: OutputAddress[];
-  estimated_output: number;
-  fee: number;
+  estimated_output: string;
+  fee: string;
+  fee_breakdown?: FeeBreakdown;
+  quote_id?: string | null;
   status: string;
   created_at: string;
   updated_at?: string;
 }
 
+export interface FeeBreakdown {
+  service_fee: string;
+  network_fee: string;
+  sweep_fee: string;
+  payout_fee: string;
+  payout_fees: string[];
+  spread: string;
+  fee_rate: number;
+  total: string;
+}
+
 export interface ApiResponse<T> {
   success: boolean;
   data: T;
@@ -40,8 +73,15 @@ export interface ApiResponse<T> {
 }
 
 export interface SupportedCurrency {
+  // Code of an asset on a network, e.g. USDT-TRC20
   symbol: string;
   name: string;
+  asset: string;
+  network: string;
+  standard: string;
+  decimals: number;
+  address_format: 'bitcoin' | 'evm' | 'cardano' | 'solana' | 'tron';
+  memo: MemoPolicy;
   min_amount: number;
   max_amount: number;
   fee: number;
@@ -51,15 +91,58 @@ export interface PriceData {
   [symbol: string]: number;
 }
 
+export type CandleInterval = '1m' | '1h' | '1d';
+
+export interface PriceCandle {
+  currency: string;
+  interval: CandleInterval;
+  open_time: string;
+  open: number;
+  high: number;
+  low: number;
+  close: number;
+  ticks: number;
+}
+
+export interface PriceHistory {
+  symbol: string;
+  interval: CandleInterval;
+  from: string;
+  to: string;
+  candles: PriceCandle[];
+}
+
 export interface ValidateAddressRequest {
   address: string;
   currency: string;
+  memo?: string;
 }
 
+export type AddressErrorReason =
+  | 'unsupported_currency'
+  | 'invalid_format'
+  | 'invalid_encoding'
+  | 'invalid_checksum'
+  | 'wrong_network'
+  | 'unsupported_type'
+  | 'off_curve'
+  | 'memo_required'
+  | 'memo_forbidden'
+  | 'invalid_memo';
+
+export type MemoPolicy = 'forbidden' | 'optional' | 'required';
+
 export interface ValidateAddressResponse {
   valid: boolean;
   address: string;
   currency: string;
+  // Bitcoin network payouts are sent on, e.g. mainnet or testnet3
+  network: string;
+  memo_policy: MemoPolicy;
+  address_type?: string;
+  // Set on invalid addresses
+  reason?: AddressErrorReason;
+  message?: string;
 }
 
 export const api = {
@@ -75,12 +158,26 @@ export const api = {
     return response.data.data;
   },
 
+  // Get OHLC price candles, by default the last 100 intervals
+  getPriceHistory: async (symbol: string, interval: CandleInterval = '1h', from?: string, to?: string): Promise<PriceHistory> => {
+    const response = await apiClient.get<ApiResponse<PriceHistory>>('/prices/history', {
+      params: { symbol, interval, from, to },
+    });
+    return response.data.data;
+  },
+
   // Get supported currencies
   getSupportedCurrencies: async (): Promise<SupportedCurrency[]> => {
     const response = await apiClient.get<ApiResponse<SupportedCurrency[]>>('/supported-currencies');
     return response.data.data;
   },
 
+  // Quote an exchange with a locked rate
+  createQuote: async (data: CreateQuoteRequest): Promise<ApiResponse<Quote>> => {
+    const response = await apiClient.post<ApiResponse<Quote>>('/exchange/quote', data);
+    return response.data;
+  },
+
   // Create exchange transaction
   createExchange: async (data: CreateExchangeRequest): Promise<ApiResponse<Transaction>> => {
     const response = await apiClient.post<ApiResponse<Transaction>>('/exchange/initiate', data);
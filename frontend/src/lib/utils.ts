@@ -1,4 +1,8 @@
-export const formatCurrency = (amount: number, decimals = 8): string => {
+export const formatCurrency = (amount: number | string, decimals = 8): string => {
+  // Amounts from the API are exact decimal strings, only round numbers
+  if (typeof amount === 'string') {
+    return amount;
+  }
   return amount.toFixed(decimals).replace(/\.?0+$/, '');
 };
 
@@ -36,6 +40,12 @@ export const truncateAddress = (address: string, startChars = 6, endChars = 4):
   return `${address.slice(0, startChars)}...${address.slice(-endChars)}`;
 };
 
+// getCurrencyAsset returns the asset of a currency code, e.g. USDT for
+// USDT-TRC20
+export const getCurrencyAsset = (symbol: string): string => {
+  return symbol.toUpperCase().split('-')[0];
+};
+
 export const getCurrencyIcon = (symbol: string): string => {
   const icons: { [key: string]: string } = {
     BTC: '₿',
@@ -46,7 +56,7 @@ export const getCurrencyIcon = (symbol: string): string => {
     SOL: '◎',
     MATIC: '⬟',
   };
-  return icons[symbol.toUpperCase()] || symbol;
+  return icons[getCurrencyAsset(symbol)] || symbol;
 };
 
 export const getCurrencyName = (symbol: string): string => {
@@ -59,7 +69,7 @@ export const getCurrencyName = (symbol: string): string => {
     SOL: 'Solana',
     MATIC: 'Polygon',
   };
-  return names[symbol.toUpperCase()] || symbol;
+  return names[getCurrencyAsset(symbol)] || symbol;
 };
 
 export const getStatusColor = (status: string): string => {
@@ -103,12 +113,21 @@ export const validateCryptoAddress = (address: string, currency: string): boolea
     case 'ETH':
     case 'USDT':
     case 'USDC':
+    case 'USDT-ERC20':
+    case 'USDC-ERC20':
     case 'MATIC':
+    case 'USDT-POLYGON':
+    case 'USDC-POLYGON':
       return isValidEthereumAddress(address);
+    case 'USDT-TRC20':
+      // Basic Tron address validation
+      return /^T[1-9A-HJ-NP-Za-km-z]{33}$/.test(address);
     case 'ADA':
       // Basic Cardano address validation
       return /^addr1[a-z0-9]{53,}$/.test(address);
     case 'SOL':
+    case 'USDT-SOL':
+    case 'USDC-SOL':
       // Basic Solana address validation
       return /^[1-9A-HJ-NP-Za-km-z]{32,44}$/.test(address);
     default:
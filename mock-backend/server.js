@@ -99,11 +99,12 @@ app.post('/api/v1/exchange/initiate', (req, res) => {
   const transaction = {
     id: uuidv4(),
     payment_address: generateBitcoinAddress(),
-    btc_amount: btc_amount,
+    // Amounts are decimal strings like the real API
+    btc_amount: String(btc_amount),
     output_currency: output_currency.toUpperCase(),
     output_addresses: output_addresses,
-    estimated_output: estimatedOutput,
-    fee: feeAmount,
+    estimated_output: estimatedOutput.toFixed(8).replace(/\.?0+$/, ''),
+    fee: (Number(btc_amount) * fee).toFixed(8).replace(/\.?0+$/, ''),
     status: 'pending',
     created_at: new Date().toISOString(),
     updated_at: new Date().toISOString()